@@ -0,0 +1,94 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package commands
+
+import (
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/stronker/authx/internal/app/authx/manager"
+	"time"
+)
+
+var keyRingPath = ""
+var newKey = manager.KeyDescriptor{}
+var overlap time.Duration
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage the signing key ring",
+	Long:  `Manage the keys of the signing key ring. A running AUTHX server reloads the key ring when it changes.`,
+}
+
+var promoteKeyCmd = &cobra.Command{
+	Use:   "promote",
+	Short: "Promote a key to sign new tokens",
+	Long:  `Add a key to the key ring and make it the active one. The previous key is accepted until the overlap period ends.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		SetupLogging()
+		descriptor := loadKeyRing()
+		descriptor.Promote(newKey, overlap)
+		if _, err := descriptor.Build(); err != nil {
+			log.Fatal().Str("trace", err.DebugReport()).Msg("invalid key ring")
+		}
+		saveKeyRing(descriptor)
+		log.Info().Str("kid", newKey.KeyID).Str("overlap", overlap.String()).Msg("signing key promoted")
+	},
+}
+
+var retireKeyCmd = &cobra.Command{
+	Use:   "retire",
+	Short: "Remove a key from the key ring",
+	Long:  `Remove a key from the key ring. Tokens signed with the key are no longer accepted.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		SetupLogging()
+		descriptor := loadKeyRing()
+		if err := descriptor.Retire(newKey.KeyID); err != nil {
+			log.Fatal().Str("trace", err.DebugReport()).Msg("cannot retire signing key")
+		}
+		saveKeyRing(descriptor)
+		log.Info().Str("kid", newKey.KeyID).Msg("signing key retired")
+	},
+}
+
+func loadKeyRing() *manager.KeyRingDescriptor {
+	descriptor, err := manager.LoadKeyRingDescriptor(keyRingPath)
+	if err != nil {
+		log.Fatal().Str("trace", err.DebugReport()).Msg("cannot load key ring")
+	}
+	return descriptor
+}
+
+func saveKeyRing(descriptor *manager.KeyRingDescriptor) {
+	if err := descriptor.Save(keyRingPath); err != nil {
+		log.Fatal().Str("trace", err.DebugReport()).Msg("cannot save key ring")
+	}
+}
+
+func init() {
+	d, _ := time.ParseDuration(DefaultExpirationDuration)
+	
+	rootCmd.AddCommand(keysCmd)
+	keysCmd.AddCommand(promoteKeyCmd)
+	keysCmd.AddCommand(retireKeyCmd)
+	keysCmd.PersistentFlags().StringVar(&keyRingPath, "keyRing", "", "Path to the key ring descriptor")
+	keysCmd.PersistentFlags().StringVar(&newKey.KeyID, "kid", "", "Key identifier")
+	_ = keysCmd.MarkPersistentFlagRequired("keyRing")
+	_ = keysCmd.MarkPersistentFlagRequired("kid")
+	promoteKeyCmd.Flags().StringVar(&newKey.Path, "key", "", "Path to the PEM private key or to the shared secret")
+	promoteKeyCmd.Flags().StringVar(&newKey.Algorithm, "alg", "", "Set to HS256 if the key is a shared secret")
+	promoteKeyCmd.Flags().DurationVar(&overlap, "overlap", d, "Period the previous key is still accepted. It must cover the token expiration")
+}
@@ -29,10 +29,22 @@ import (
 const DefaultExpirationDuration = "3h"
 const DefaultDeviceExpiration = "10m"
 const DefaultEdgeControllerJoinExpiration = "1h"
+const DefaultLoginLockout = "15m"
+const DefaultResetTokenExpiration = "1h"
+const DefaultMaxAccessTokenExpiration = "2160h"
+
+// DefaultMaxLoginFailures is the default number of failed logins that lock a user
+const DefaultMaxLoginFailures = 5
+
+// DefaultMaxClientLoginFailures is the default number of failed logins that lock a client address
+const DefaultMaxClientLoginFailures = 50
 
 // DefaultPort is the default port where the service is deployed
 const DefaultPort = 8810
 
+// DefaultJWKSPort is the default port where the public signing keys are published
+const DefaultJWKSPort = 8811
+
 // DefaultSecret is the default secret that Authx uses to sign the token.
 const DefaultSecret = "myLittleSecret"
 
@@ -68,13 +80,37 @@ func init() {
 	d, _ := time.ParseDuration(DefaultExpirationDuration)
 	e, _ := time.ParseDuration(DefaultDeviceExpiration)
 	ece, _ := time.ParseDuration(DefaultEdgeControllerJoinExpiration)
+	ll, _ := time.ParseDuration(DefaultLoginLockout)
+	rte, _ := time.ParseDuration(DefaultResetTokenExpiration)
+	ate, _ := time.ParseDuration(DefaultMaxAccessTokenExpiration)
 	
 	rootCmd.AddCommand(runCmd)
 	runCmd.Flags().IntVar(&cfg.Port, "port", DefaultPort, "Port to launch Authx server")
 	runCmd.Flags().StringVar(&secretPath, "secret", "", "Path to internal secret to generate Tokens")
+	runCmd.Flags().StringVar(&cfg.SigningKeyPath, "signingKey", "", "Path to the PEM private key (RSA or EC P-256) to sign Tokens")
+	runCmd.Flags().StringVar(&cfg.SigningKeyID, "signingKeyID", "", "Key identifier of the signing key")
+	runCmd.Flags().StringVar(&cfg.KeyRingPath, "keyRing", "", "Path to the key ring descriptor with the keys to sign Tokens")
+	runCmd.Flags().IntVar(&cfg.JWKSPort, "jwksPort", DefaultJWKSPort, "Port to publish the JWKS with the public signing keys")
 	runCmd.Flags().DurationVar(&cfg.ExpirationTime, "expiration", d, "Expiration time of Tokens. No more than 3 hours allowed")
 	runCmd.Flags().DurationVar(&cfg.DeviceExpirationTime, "deviceExpiration", e, "Expiration time of devices Tokens")
 	runCmd.Flags().DurationVar(&cfg.EdgeControllerExpTime, "edgeControllerJoinExpiration", ece, "Expiration time of Edge Controller join tokens")
+	runCmd.Flags().StringVar(&cfg.PasswordHash, "passwordHash", "argon2id", "Algorithm to hash the passwords: bcrypt or argon2id. Passwords hashed with another algorithm are rehashed on login")
+	runCmd.Flags().IntVar(&cfg.BCryptCost, "bcryptCost", 10, "Cost of the bcrypt password hashes")
+	runCmd.Flags().Uint32Var(&cfg.Argon2Memory, "argon2Memory", 64*1024, "Memory in KiB used by argon2id")
+	runCmd.Flags().Uint32Var(&cfg.Argon2Iterations, "argon2Iterations", 3, "Iterations of argon2id")
+	runCmd.Flags().Uint8Var(&cfg.Argon2Parallelism, "argon2Parallelism", 4, "Threads used by argon2id")
+	runCmd.Flags().StringVar(&cfg.PasswordPolicyPath, "passwordPolicy", "", "Path to the JSON file with the default password policy and the policies of the organizations")
+	runCmd.Flags().StringVar(&cfg.PasswordDenyListPath, "passwordDenyList", "", "Path to the file with common or breached passwords that are not allowed, one per line")
+	runCmd.Flags().IntVar(&cfg.MaxLoginFailures, "maxLoginFailures", DefaultMaxLoginFailures, "Number of consecutive failed logins that lock a user")
+	runCmd.Flags().IntVar(&cfg.MaxClientLoginFailures, "maxClientLoginFailures", DefaultMaxClientLoginFailures, "Number of consecutive failed logins that lock a client address. Zero disables the limit")
+	runCmd.Flags().StringVar(&cfg.ClientIPHeader, "clientIPHeader", "", "Metadata key where the trusted proxies forward the client address, e.g. x-forwarded-for. The peer address is used if it is empty")
+	runCmd.Flags().StringSliceVar(&cfg.TrustedProxies, "trustedProxies", []string{}, "Networks in CIDR notation of the proxies allowed to set the clientIPHeader")
+	runCmd.Flags().DurationVar(&cfg.LoginLockoutDuration, "loginLockout", ll, "Time a user is locked after too many failed logins. No more than 24 hours allowed")
+	runCmd.Flags().DurationVar(&cfg.ResetTokenExpiration, "resetTokenExpiration", rte, "Expiration time of password reset tokens. No more than 24 hours allowed")
+	runCmd.Flags().StringVar(&cfg.ResetNotifier, "resetNotifier", "", "Notifier that delivers the password reset tokens: log or file. ONLY for development. Password reset is disabled if it is empty")
+	runCmd.Flags().StringVar(&cfg.ResetNotificationPath, "resetNotificationPath", "", "Path to the file where the file notifier writes the password reset tokens")
+	runCmd.Flags().DurationVar(&cfg.MaxAccessTokenExpiration, "maxAccessTokenExpiration", ate, "Maximum expiration time of personal access tokens")
+	runCmd.Flags().StringVar(&cfg.TokenAudience, "tokenAudience", "", "Audience included in the Tokens to identify the environment. Tokens have no audience if it is empty")
 	
 	runCmd.Flags().BoolVar(&cfg.UseInMemoryProviders, "userInMemoryProviders", false, "Whether in-memory providers should be used. ONLY for development")
 	runCmd.Flags().BoolVar(&cfg.UseDBScyllaProviders, "useDBScyllaProviders", true, "Whether dbscylla providers should be used")
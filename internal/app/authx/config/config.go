@@ -24,6 +24,7 @@ import (
 	"github.com/nalej/derrors"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
+	"net"
 	"strings"
 	"time"
 )
@@ -31,6 +32,12 @@ import (
 // Scylla has an expiration time of 3 hours
 const ttlExpirationTime = 3
 
+// Scylla keeps the failed login attempts for 24 hours
+const lockoutTTLExpirationTime = 24
+
+// resetTokenMaxExpirationTime is the maximum number of hours a password reset token can be used
+const resetTokenMaxExpirationTime = 24
+
 // Config is the set of required configuration parameters.
 type Config struct {
 	// Debug level is active.
@@ -39,6 +46,14 @@ type Config struct {
 	Port int
 	// Secret used to sign JWT tokens.
 	Secret string
+	// SigningKeyPath with the path of the PEM private key used to sign JWT tokens. If set, it replaces the secret.
+	SigningKeyPath string
+	// SigningKeyID with the identifier of the signing key included in the kid header.
+	SigningKeyID string
+	// KeyRingPath with the path of the key ring descriptor. If set, it replaces the signing key and the secret.
+	KeyRingPath string
+	// JWKSPort where the public signing keys are published.
+	JWKSPort int
 	// ManagementClusterCertPath with the path of the management cluster certificate.
 	ManagementClusterCertPath string
 	// ManagementClusterCert with the Management cluster certificate.
@@ -63,6 +78,42 @@ type Config struct {
 	CACertPath string
 	// CAPrivateKeyPath with the path of the private key for the CA.
 	CAPrivateKeyPath string
+	// PasswordHash with the algorithm used to hash the passwords: bcrypt or argon2id.
+	PasswordHash string
+	// BCryptCost with the cost of the bcrypt hashes.
+	BCryptCost int
+	// Argon2Memory with the memory in KiB used by argon2id.
+	Argon2Memory uint32
+	// Argon2Iterations with the number of iterations of argon2id.
+	Argon2Iterations uint32
+	// Argon2Parallelism with the number of threads used by argon2id.
+	Argon2Parallelism uint8
+	// PasswordPolicyPath with the path of the JSON file with the password policies.
+	PasswordPolicyPath string
+	// PasswordDenyListPath with the path of the file with the passwords that are not allowed, one per line.
+	PasswordDenyListPath string
+	// MaxLoginFailures with the number of consecutive failed logins that lock a user.
+	MaxLoginFailures int
+	// MaxClientLoginFailures with the number of consecutive failed logins that lock a client address.
+	MaxClientLoginFailures int
+	// ClientIPHeader with the metadata key where the trusted proxies forward the client address.
+	ClientIPHeader string
+	// TrustedProxies with the networks in CIDR notation of the proxies allowed to set the ClientIPHeader.
+	TrustedProxies []string
+	// LoginLockoutDuration with the time a user is locked after reaching the maximum number of failed logins.
+	LoginLockoutDuration time.Duration
+	// ResetTokenExpiration with the time a password reset token can be used.
+	ResetTokenExpiration time.Duration
+	// ResetNotifier with the notifier that delivers the password reset tokens: log or file. The password reset is
+	// disabled if it is empty.
+	ResetNotifier string
+	// ResetNotificationPath with the path of the file used by the file notifier.
+	ResetNotificationPath string
+	// MaxAccessTokenExpiration with the maximum time a personal access token can be used.
+	MaxAccessTokenExpiration time.Duration
+	// TokenAudience with the audience included in the tokens. It identifies the environment so the tokens are not
+	// accepted by the services of another one.
+	TokenAudience string
 }
 
 func (conf *Config) Validate() derrors.Error {
@@ -84,12 +135,57 @@ func (conf *Config) Validate() derrors.Error {
 		return derrors.NewInvalidArgumentError("a type of provider must be selected")
 	}
 
+	if conf.SigningKeyPath != "" && conf.KeyRingPath != "" {
+		return derrors.NewInvalidArgumentError("signingKey and keyRing cannot be used at the same time")
+	}
+	if conf.KeyRingPath != "" && conf.JWKSPort <= 0 {
+		return derrors.NewInvalidArgumentError("jwksPort must be specified to publish the signing keys")
+	}
+	if conf.SigningKeyPath != "" {
+		if conf.SigningKeyID == "" {
+			return derrors.NewInvalidArgumentError("signingKeyID must be specified with a signing key")
+		}
+		if conf.JWKSPort <= 0 {
+			return derrors.NewInvalidArgumentError("jwksPort must be specified to publish the signing keys")
+		}
+	}
+
 	if conf.ExpirationTime.Hours() > ttlExpirationTime {
 		return derrors.NewInvalidArgumentError("currently the duration can not be longer than 3h. Scylla has a 3 hours TTL")
 	}
 	if conf.DeviceExpirationTime.Hours() > ttlExpirationTime {
 		return derrors.NewInvalidArgumentError("currently the duration of device tokens can not be longer than 3h. Scylla has a 3 hours TTL")
 	}
+	if conf.PasswordHash != "bcrypt" && conf.PasswordHash != "argon2id" {
+		return derrors.NewInvalidArgumentError("passwordHash must be bcrypt or argon2id")
+	}
+	if conf.MaxLoginFailures <= 0 {
+		return derrors.NewInvalidArgumentError("maxLoginFailures must be greater than zero")
+	}
+	if conf.MaxClientLoginFailures < 0 {
+		return derrors.NewInvalidArgumentError("maxClientLoginFailures cannot be negative")
+	}
+	if conf.ClientIPHeader != "" && len(conf.TrustedProxies) == 0 {
+		return derrors.NewInvalidArgumentError("trustedProxies must be specified to use clientIPHeader")
+	}
+	if _, err := conf.TrustedProxyNetworks(); err != nil {
+		return err
+	}
+	if conf.LoginLockoutDuration <= 0 || conf.LoginLockoutDuration.Hours() > lockoutTTLExpirationTime {
+		return derrors.NewInvalidArgumentError("loginLockout must be positive and not longer than 24h. Scylla has a 24 hours TTL")
+	}
+	if conf.ResetTokenExpiration <= 0 || conf.ResetTokenExpiration.Hours() > resetTokenMaxExpirationTime {
+		return derrors.NewInvalidArgumentError("resetTokenExpiration must be positive and not longer than 24h")
+	}
+	if conf.ResetNotifier != "" && conf.ResetNotifier != "log" && conf.ResetNotifier != "file" {
+		return derrors.NewInvalidArgumentError("resetNotifier must be empty, log or file")
+	}
+	if conf.ResetNotifier == "file" && conf.ResetNotificationPath == "" {
+		return derrors.NewInvalidArgumentError("resetNotificationPath must be specified to use the file notifier")
+	}
+	if conf.MaxAccessTokenExpiration <= 0 {
+		return derrors.NewInvalidArgumentError("maxAccessTokenExpiration must be positive")
+	}
 	if conf.EdgeControllerExpTime.Hours() > ttlExpirationTime {
 		return derrors.NewInvalidArgumentError("currently the duration of edge controller join tokens cannot be longer than 3h. Scylla has a 3 hours TTL")
 	}
@@ -109,6 +205,20 @@ func (conf *Config) Validate() derrors.Error {
 	return nil
 }
 
+// TrustedProxyNetworks returns the networks of the trusted proxies.
+func (conf *Config) TrustedProxyNetworks() ([]*net.IPNet, derrors.Error) {
+	networks := make([]*net.IPNet, 0, len(conf.TrustedProxies))
+	for _, proxy := range conf.TrustedProxies {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(proxy))
+		if err != nil {
+			return nil, derrors.NewInvalidArgumentError("trustedProxies must be networks in CIDR notation", err).
+				WithParams(proxy)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
 // LoadCert loads the management cluster certificate in memory.
 func (conf *Config) loadCert() derrors.Error {
 	content, err := ioutil.ReadFile(conf.ManagementClusterCertPath)
@@ -124,6 +234,14 @@ func (conf *Config) Print() {
 	log.Info().Str("app", version.AppVersion).Str("commit", version.Commit).Msg("Version")
 	log.Info().Int("port", conf.Port).Msg("gRPC port")
 	log.Info().Str("secret", strings.Repeat("*", len(conf.Secret))).Msg("JWT Token secret")
+	if conf.SigningKeyPath != "" {
+		log.Info().Str("path", conf.SigningKeyPath).Str("kid", conf.SigningKeyID).Msg("JWT signing key")
+		log.Info().Int("port", conf.JWKSPort).Msg("JWKS port")
+	}
+	if conf.KeyRingPath != "" {
+		log.Info().Str("path", conf.KeyRingPath).Msg("JWT signing key ring")
+		log.Info().Int("port", conf.JWKSPort).Msg("JWKS port")
+	}
 	if conf.ManagementClusterCert != "" {
 		log.Info().Str("md5", fmt.Sprintf("%x", md5.Sum([]byte(conf.ManagementClusterCert)))).Msg("Management cluster server certificate")
 	} else {
@@ -132,6 +250,30 @@ func (conf *Config) Print() {
 	log.Info().Str("duration", conf.ExpirationTime.String()).Msg("JWT Expiration time")
 	log.Info().Str("duration", conf.DeviceExpirationTime.String()).Msg("Device expiration time")
 	log.Info().Str("duration", conf.EdgeControllerExpTime.String()).Msg("Edge controller join token expiration time")
+	if conf.PasswordHash == "argon2id" {
+		log.Info().Uint32("memory", conf.Argon2Memory).Uint32("iterations", conf.Argon2Iterations).
+			Uint8("parallelism", conf.Argon2Parallelism).Msg("Password hashing with argon2id")
+	} else {
+		log.Info().Int("cost", conf.BCryptCost).Msg("Password hashing with bcrypt")
+	}
+	if conf.PasswordPolicyPath != "" {
+		log.Info().Str("path", conf.PasswordPolicyPath).Msg("Password policies")
+	}
+	if conf.PasswordDenyListPath != "" {
+		log.Info().Str("path", conf.PasswordDenyListPath).Msg("Password deny list")
+	}
+	log.Info().Int("failures", conf.MaxLoginFailures).Int("clientFailures", conf.MaxClientLoginFailures).
+		Str("clientIPHeader", conf.ClientIPHeader).Strs("trustedProxies", conf.TrustedProxies).Str("duration", conf.LoginLockoutDuration.String()).Msg("Login lockout")
+	if conf.ResetNotifier != "" {
+		log.Info().Str("duration", conf.ResetTokenExpiration.String()).Str("notifier", conf.ResetNotifier).
+			Str("path", conf.ResetNotificationPath).Msg("Password reset")
+	} else {
+		log.Info().Msg("Password reset is disabled")
+	}
+	log.Info().Str("maxExpiration", conf.MaxAccessTokenExpiration.String()).Msg("Personal access tokens")
+	if conf.TokenAudience != "" {
+		log.Info().Str("audience", conf.TokenAudience).Msg("JWT audience")
+	}
 
 	if conf.UseInMemoryProviders {
 		log.Info().Bool("UseInMemoryProviders", conf.UseInMemoryProviders).Msg("Using in-memory providers")
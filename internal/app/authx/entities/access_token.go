@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+import pbAuthx "github.com/nalej/grpc-authx-go"
+
+// PersonalAccessTokenData is the stored information of a personal access token. A personal access token can be
+// exchanged for a user token with a subset of the primitives of its owner. Only the hash of the token is stored.
+type PersonalAccessTokenData struct {
+	Username string `cql:"username"`
+	TokenID  string `cql:"token_id"`
+	// Name is the description given by the user.
+	Name string `cql:"name"`
+	// TokenHash is the hex encoded SHA-256 of the token.
+	TokenHash string `cql:"token_hash"`
+	// Primitives are the primitives granted to the token. Only the ones included in the role of the user are used.
+	Primitives     []string `cql:"primitives"`
+	CreationDate   int64    `cql:"creation_date"`
+	ExpirationDate int64    `cql:"expiration_date"`
+	// LastUsed is the unix time of the last login with the token, zero if it has not been used.
+	LastUsed int64 `cql:"last_used"`
+}
+
+// NewPersonalAccessTokenData creates an instance of the structure.
+func NewPersonalAccessTokenData(username string, tokenID string, name string, tokenHash string, primitives []string,
+	creationDate int64, expirationDate int64) *PersonalAccessTokenData {
+	return &PersonalAccessTokenData{
+		Username:       username,
+		TokenID:        tokenID,
+		Name:           name,
+		TokenHash:      tokenHash,
+		Primitives:     primitives,
+		CreationDate:   creationDate,
+		ExpirationDate: expirationDate,
+	}
+}
+
+// ToGRPC converts the token to its gRPC representation. The hash of the token is not included.
+func (t *PersonalAccessTokenData) ToGRPC() *pbAuthx.PersonalAccessToken {
+	primitives := make([]pbAuthx.AccessPrimitive, 0, len(t.Primitives))
+	for _, p := range t.Primitives {
+		primitives = append(primitives, PrimitiveToGRPC(p))
+	}
+	return &pbAuthx.PersonalAccessToken{
+		Username:       t.Username,
+		TokenId:        t.TokenID,
+		Name:           t.Name,
+		Primitives:     primitives,
+		CreationDate:   t.CreationDate,
+		ExpirationDate: t.ExpirationDate,
+		LastUsed:       t.LastUsed,
+	}
+}
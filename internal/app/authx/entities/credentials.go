@@ -17,6 +17,8 @@
 
 package entities
 
+import "time"
+
 // BasicCredentialsData is the struct that is store in the database.
 type BasicCredentialsData struct {
 	// Username is the credential id.
@@ -27,30 +29,82 @@ type BasicCredentialsData struct {
 	RoleID string
 	// OrganizationID is the assigned organization.
 	OrganizationID string
+	// PasswordChangedAt is the timestamp of the last password change.
+	PasswordChangedAt int64
+	// PasswordHistory contains the hashes of the previous passwords, the most recent first.
+	PasswordHistory [][]byte
+	// MFASecret is the base32 TOTP secret. It is set during the enrolment, before MFA is enabled.
+	MFASecret string
+	// MFAEnabled indicates that the login requires a TOTP code.
+	MFAEnabled bool
+	// MFALastCounter is the time step of the last accepted TOTP code. It prevents reusing a code.
+	MFALastCounter int64
+	// RecoveryCodes contains the hashes of the unused recovery codes.
+	RecoveryCodes []string
 }
 
 // NewBasicCredentialsData creates an instance of BasicCredentialsData.
 func NewBasicCredentialsData(username string, password []byte, roleID string, organizationID string) *BasicCredentialsData {
 	return &BasicCredentialsData{
-		Username:       username,
-		Password:       password,
-		RoleID:         roleID,
-		OrganizationID: organizationID,
+		Username:          username,
+		Password:          password,
+		RoleID:            roleID,
+		OrganizationID:    organizationID,
+		PasswordChangedAt: time.Now().Unix(),
 	}
 }
 
 // EditBasicCredentialsData is an object that allows to edit the credetentials record.
 type EditBasicCredentialsData struct {
-	Password *[]byte
-	RoleID   *string
+	Password          *[]byte
+	RoleID            *string
+	PasswordChangedAt *int64
+	PasswordHistory   *[][]byte
+	MFASecret         *string
+	MFAEnabled        *bool
+	MFALastCounter    *int64
+	RecoveryCodes     *[]string
 }
 
-// WithPassword allows to change the password.
+// WithPassword allows to replace the password hash without registering a password change.
 func (d *EditBasicCredentialsData) WithPassword(password []byte) *EditBasicCredentialsData {
 	d.Password = &password
 	return d
 }
 
+// WithPasswordChange allows to change the password, registering the change timestamp and the resulting history.
+func (d *EditBasicCredentialsData) WithPasswordChange(password []byte, history [][]byte) *EditBasicCredentialsData {
+	changedAt := time.Now().Unix()
+	d.Password = &password
+	d.PasswordChangedAt = &changedAt
+	d.PasswordHistory = &history
+	return d
+}
+
+// WithMFASecret allows to change the TOTP secret.
+func (d *EditBasicCredentialsData) WithMFASecret(secret string) *EditBasicCredentialsData {
+	d.MFASecret = &secret
+	return d
+}
+
+// WithMFAEnabled allows to enable or disable MFA.
+func (d *EditBasicCredentialsData) WithMFAEnabled(enabled bool) *EditBasicCredentialsData {
+	d.MFAEnabled = &enabled
+	return d
+}
+
+// WithMFALastCounter allows to change the time step of the last accepted TOTP code.
+func (d *EditBasicCredentialsData) WithMFALastCounter(counter int64) *EditBasicCredentialsData {
+	d.MFALastCounter = &counter
+	return d
+}
+
+// WithRecoveryCodes allows to change the hashes of the recovery codes.
+func (d *EditBasicCredentialsData) WithRecoveryCodes(codes []string) *EditBasicCredentialsData {
+	d.RecoveryCodes = &codes
+	return d
+}
+
 // WithRoleID allows to change the roleID
 func (d *EditBasicCredentialsData) WithRoleID(roleID string) *EditBasicCredentialsData {
 	d.RoleID = &roleID
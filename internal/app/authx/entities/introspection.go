@@ -0,0 +1,94 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/grpc-authx-go"
+)
+
+// UserTokenType is the type of the tokens issued to users.
+const UserTokenType = "user"
+
+// DeviceTokenType is the type of the tokens issued to devices.
+const DeviceTokenType = "device"
+
+// TokenIntrospection contains the state of a token and its decoded claims. The claims are only set if the token has
+// a valid signature.
+type TokenIntrospection struct {
+	// Active is true if the token can be used.
+	Active bool
+	// Revoked is true if the token has been revoked before its expiration.
+	Revoked        bool
+	TokenType      string
+	TokenID        string
+	Issuer         string
+	IssuedAt       int64
+	ExpiresAt      int64
+	UserID         string
+	OrganizationID string
+	RoleName       string
+	Primitives     []string
+	DeviceGroupID  string
+	DeviceID       string
+	Audience       string
+	// AuthenticationMethods contains the amr claim.
+	AuthenticationMethods []string
+	PrincipalType         string
+	TokenSource           string
+	TokenSourceID         string
+	// Actor is the act claim of the exchanged tokens.
+	Actor *token.Actor
+}
+
+// NewInactiveTokenIntrospection creates the result for a token that is not valid.
+func NewInactiveTokenIntrospection() *TokenIntrospection {
+	return &TokenIntrospection{Active: false}
+}
+
+func (ti *TokenIntrospection) ToGRPC() *grpc_authx_go.IntrospectResponse {
+	return &grpc_authx_go.IntrospectResponse{
+		Active:                ti.Active,
+		Revoked:               ti.Revoked,
+		TokenType:             ti.TokenType,
+		TokenId:               ti.TokenID,
+		Issuer:                ti.Issuer,
+		IssuedAt:              ti.IssuedAt,
+		ExpiresAt:             ti.ExpiresAt,
+		UserId:                ti.UserID,
+		OrganizationId:        ti.OrganizationID,
+		RoleName:              ti.RoleName,
+		Primitives:            ti.Primitives,
+		DeviceGroupId:         ti.DeviceGroupID,
+		DeviceId:              ti.DeviceID,
+		Audience:              ti.Audience,
+		PrincipalType:         ti.PrincipalType,
+		TokenSource:           ti.TokenSource,
+		TokenSourceId:         ti.TokenSourceID,
+		AuthenticationMethods: ti.AuthenticationMethods,
+		Actors:                actorsToGRPC(ti.Actor),
+	}
+}
+
+// actorsToGRPC returns the chain of actors starting with the current one.
+func actorsToGRPC(actor *token.Actor) []*grpc_authx_go.TokenActor {
+	result := make([]*grpc_authx_go.TokenActor, 0)
+	for ; actor != nil; actor = actor.Actor {
+		result = append(result, &grpc_authx_go.TokenActor{Subject: actor.Subject, PrincipalType: actor.PrincipalType})
+	}
+	return result
+}
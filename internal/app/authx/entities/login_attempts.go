@@ -0,0 +1,49 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+// UserAttemptsPrefix is the prefix of the keys that count the failed logins of a username.
+const UserAttemptsPrefix = "user:"
+
+// ClientAttemptsPrefix is the prefix of the keys that count the failed logins from a client IP.
+const ClientAttemptsPrefix = "ip:"
+
+// LoginAttemptsData contains the failed login attempts of a username or a client IP.
+type LoginAttemptsData struct {
+	AttemptKey string `cql:"attempt_key"`
+	// Failures is the number of consecutive failed attempts.
+	Failures int `cql:"failures"`
+	// LastFailure is the unix time of the last failed attempt.
+	LastFailure int64 `cql:"last_failure"`
+	// LockedUntil is the unix time until the logins are rejected. Zero means the key is not locked.
+	LockedUntil int64 `cql:"locked_until"`
+}
+
+// NewLoginAttemptsData creates an instance of the structure without failures.
+func NewLoginAttemptsData(attemptKey string) *LoginAttemptsData {
+	return &LoginAttemptsData{AttemptKey: attemptKey}
+}
+
+// UserAttemptsKey returns the key of the attempts of a username.
+func UserAttemptsKey(username string) string {
+	return UserAttemptsPrefix + username
+}
+
+// ClientAttemptsKey returns the key of the attempts from a client IP.
+func ClientAttemptsKey(ip string) string {
+	return ClientAttemptsPrefix + ip
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+import pbAuthx "github.com/nalej/grpc-authx-go"
+
+// MFAEnrollment contains the information required to register a TOTP secret in an authenticator application.
+type MFAEnrollment struct {
+	// Secret is the base32 TOTP secret.
+	Secret string
+	// URI is the otpauth URI of the secret, usually shown as a QR code.
+	URI string
+}
+
+// NewMFAEnrollment creates an instance of the structure.
+func NewMFAEnrollment(secret string, uri string) *MFAEnrollment {
+	return &MFAEnrollment{Secret: secret, URI: uri}
+}
+
+// ToGRPC converts the enrollment to its gRPC representation.
+func (e *MFAEnrollment) ToGRPC() *pbAuthx.MFAEnrollment {
+	return &pbAuthx.MFAEnrollment{Secret: e.Secret, Uri: e.URI}
+}
+
+// MFARequirementData defines which users of an organization must use MFA.
+type MFARequirementData struct {
+	OrganizationID string
+	// AllUsers requires MFA for all the users of the organization.
+	AllUsers bool
+	// Primitives requires MFA for the users whose role includes any of the primitives.
+	Primitives []string
+}
+
+// NewMFARequirementData creates an instance of the structure.
+func NewMFARequirementData(organizationID string, allUsers bool, primitives []string) *MFARequirementData {
+	return &MFARequirementData{
+		OrganizationID: organizationID,
+		AllUsers:       allUsers,
+		Primitives:     primitives,
+	}
+}
+
+// NewMFARequirementFromGRPC creates the structure from its gRPC representation.
+func NewMFARequirementFromGRPC(requirement *pbAuthx.MFARequirement) *MFARequirementData {
+	primitives := make([]string, 0, len(requirement.Primitives))
+	for _, p := range requirement.Primitives {
+		primitives = append(primitives, p.String())
+	}
+	return NewMFARequirementData(requirement.OrganizationId, requirement.AllUsers, primitives)
+}
+
+// Required checks if a user with a set of primitives must use MFA.
+func (r *MFARequirementData) Required(primitives []string) bool {
+	if r.AllUsers {
+		return true
+	}
+	for _, required := range r.Primitives {
+		for _, p := range primitives {
+			if p == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ToGRPC converts the requirement to its gRPC representation.
+func (r *MFARequirementData) ToGRPC() *pbAuthx.MFARequirement {
+	primitives := make([]pbAuthx.AccessPrimitive, 0, len(r.Primitives))
+	for _, p := range r.Primitives {
+		primitives = append(primitives, PrimitiveToGRPC(p))
+	}
+	return &pbAuthx.MFARequirement{
+		OrganizationId: r.OrganizationID,
+		AllUsers:       r.AllUsers,
+		Primitives:     primitives,
+	}
+}
@@ -0,0 +1,37 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+// PasswordResetTokenData is the stored information of a password reset token. Only the hash of the token is stored,
+// the token itself is only known by the user that receives it.
+type PasswordResetTokenData struct {
+	// TokenHash is the hex encoded SHA-256 of the token.
+	TokenHash string `cql:"token_hash"`
+	// Username is the user whose password can be reset.
+	Username string `cql:"username"`
+	// ExpirationDate is the unix time after which the token cannot be used.
+	ExpirationDate int64 `cql:"expiration_date"`
+}
+
+// NewPasswordResetTokenData creates an instance of the structure.
+func NewPasswordResetTokenData(tokenHash string, username string, expirationDate int64) *PasswordResetTokenData {
+	return &PasswordResetTokenData{
+		TokenHash:      tokenHash,
+		Username:       username,
+		ExpirationDate: expirationDate,
+	}
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+// RevokedTokenData is the information stored about a token that has been revoked before its expiration.
+type RevokedTokenData struct {
+	TokenID        string `cql:"token_id"`
+	Username       string `cql:"username"`
+	ExpirationDate int64  `cql:"expiration_date"`
+}
+
+// NewRevokedTokenData creates an instance of the structure
+func NewRevokedTokenData(tokenID string, username string, expirationDate int64) *RevokedTokenData {
+	return &RevokedTokenData{
+		TokenID:        tokenID,
+		Username:       username,
+		ExpirationDate: expirationDate,
+	}
+}
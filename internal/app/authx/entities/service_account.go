@@ -0,0 +1,103 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+import pbAuthx "github.com/nalej/grpc-authx-go"
+
+// ServiceAccountData is a principal of an organization used by the platform components to call other components.
+// A service account is bound to a role and authenticates with a client secret or with a key pair.
+type ServiceAccountData struct {
+	OrganizationID   string `cql:"organization_id"`
+	ServiceAccountID string `cql:"service_account_id"`
+	Name             string `cql:"name"`
+	RoleID           string `cql:"role_id"`
+	// SecretHash is the hex encoded SHA-256 of the client secret. It is empty if the account uses a key pair.
+	SecretHash string `cql:"secret_hash"`
+	// PublicKey is the PEM encoded public key that verifies the client assertions. It is empty if the account uses
+	// a client secret.
+	PublicKey    string `cql:"public_key"`
+	Enabled      bool   `cql:"enabled"`
+	CreationDate int64  `cql:"creation_date"`
+}
+
+// NewServiceAccountData creates an instance of the structure.
+func NewServiceAccountData(organizationID string, serviceAccountID string, name string, roleID string,
+	secretHash string, publicKey string, creationDate int64) *ServiceAccountData {
+	return &ServiceAccountData{
+		OrganizationID:   organizationID,
+		ServiceAccountID: serviceAccountID,
+		Name:             name,
+		RoleID:           roleID,
+		SecretHash:       secretHash,
+		PublicKey:        publicKey,
+		Enabled:          true,
+		CreationDate:     creationDate,
+	}
+}
+
+// IsKeyPair checks if the service account authenticates with a key pair.
+func (sa *ServiceAccountData) IsKeyPair() bool {
+	return sa.PublicKey != ""
+}
+
+// ToGRPC converts the service account to its gRPC representation. The credentials are not included.
+func (sa *ServiceAccountData) ToGRPC() *pbAuthx.ServiceAccount {
+	return &pbAuthx.ServiceAccount{
+		OrganizationId:   sa.OrganizationID,
+		ServiceAccountId: sa.ServiceAccountID,
+		Name:             sa.Name,
+		RoleId:           sa.RoleID,
+		KeyPair:          sa.IsKeyPair(),
+		Enabled:          sa.Enabled,
+		CreationDate:     sa.CreationDate,
+	}
+}
+
+// EditServiceAccountData is the structure that is used to edit a service account.
+type EditServiceAccountData struct {
+	Enabled *bool
+	RoleID  *string
+}
+
+// NewEditServiceAccountData creates a new instance of the structure.
+func NewEditServiceAccountData() *EditServiceAccountData {
+	return &EditServiceAccountData{}
+}
+
+// WithEnabled enables or disables the service account.
+func (d *EditServiceAccountData) WithEnabled(enabled bool) *EditServiceAccountData {
+	d.Enabled = &enabled
+	return d
+}
+
+// WithRoleID binds the service account to another role.
+func (d *EditServiceAccountData) WithRoleID(roleID string) *EditServiceAccountData {
+	d.RoleID = &roleID
+	return d
+}
+
+// NewEditServiceAccountFromGRPC creates the edition of an update request.
+func NewEditServiceAccountFromGRPC(request *pbAuthx.UpdateServiceAccountRequest) *EditServiceAccountData {
+	edit := NewEditServiceAccountData()
+	if request.UpdateEnabled {
+		edit.WithEnabled(request.Enabled)
+	}
+	if request.UpdateRoleId {
+		edit.WithRoleID(request.RoleId)
+	}
+	return edit
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package entities
+
+import (
+	"github.com/nalej/grpc-authx-go"
+)
+
+// ClientInfo identifies the client that opens or renews a session.
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// NewClientInfo creates an instance of the structure.
+func NewClientInfo(ip string, userAgent string) *ClientInfo {
+	return &ClientInfo{IP: ip, UserAgent: userAgent}
+}
+
+// Session is a login of a user that has not expired or been terminated. The session is identified by its current
+// token.
+type Session struct {
+	Username       string
+	TokenID        string
+	IssuedAt       int64
+	LastRefresh    int64
+	ExpirationDate int64
+	ClientIP       string
+	UserAgent      string
+}
+
+// NewSessionFromTokenData creates the session of the current token of a refresh token family.
+func NewSessionFromTokenData(token TokenData) *Session {
+	return &Session{
+		Username:       token.Username,
+		TokenID:        token.TokenID,
+		IssuedAt:       token.IssuedAt,
+		LastRefresh:    token.LastRefresh,
+		ExpirationDate: token.ExpirationDate,
+		ClientIP:       token.ClientIP,
+		UserAgent:      token.UserAgent,
+	}
+}
+
+func (s *Session) ToGRPC() *grpc_authx_go.Session {
+	return &grpc_authx_go.Session{
+		Username:       s.Username,
+		TokenId:        s.TokenID,
+		IssuedAt:       s.IssuedAt,
+		LastRefresh:    s.LastRefresh,
+		ExpirationDate: s.ExpirationDate,
+		ClientIp:       s.ClientIP,
+		UserAgent:      s.UserAgent,
+	}
+}
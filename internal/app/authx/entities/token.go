@@ -23,6 +23,18 @@ type TokenData struct {
 	TokenID        string `cql:"token_id"`
 	RefreshToken   []byte `cql:"refresh_token"`
 	ExpirationDate int64  `cql:"expiration_date"`
+	// FamilyID identifies the chain of refresh tokens started by a login.
+	FamilyID string `cql:"family_id"`
+	// Consumed is set once the refresh token has been used to renew the token.
+	Consumed bool `cql:"consumed"`
+	// IssuedAt is the time of the login that started the session.
+	IssuedAt int64 `cql:"issued_at"`
+	// LastRefresh is the time of the last renewal of the session token.
+	LastRefresh int64  `cql:"last_refresh"`
+	ClientIP    string `cql:"client_ip"`
+	UserAgent   string `cql:"user_agent"`
+	// TokenSourceID is the identifier of the credential used to start the session when it is not the user login.
+	TokenSourceID string `cql:"token_source_id"`
 }
 
 // NewTokenData creates an instance of the structure
@@ -34,3 +46,25 @@ func NewTokenData(username string, tokenID string, refreshToken []byte,
 		RefreshToken:   refreshToken,
 		ExpirationDate: expirationDate}
 }
+
+// NewTokenDataInFamily creates an instance of the structure that belongs to a refresh token family.
+func NewTokenDataInFamily(username string, tokenID string, familyID string, refreshToken []byte,
+	expirationDate int64) *TokenData {
+
+	token := NewTokenData(username, tokenID, refreshToken, expirationDate)
+	token.FamilyID = familyID
+	return token
+}
+
+// SetClient records the client that uses the token. The previous client is kept if the information is not available.
+func (t *TokenData) SetClient(client *ClientInfo) {
+	if client == nil {
+		return
+	}
+	if client.IP != "" {
+		t.ClientIP = client.IP
+	}
+	if client.UserAgent != "" {
+		t.UserAgent = client.UserAgent
+	}
+}
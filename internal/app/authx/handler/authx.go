@@ -27,24 +27,35 @@ import (
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-user-go"
 	"github.com/nalej/grpc-utils/pkg/conversions"
+	authxEntities "github.com/stronker/authx/internal/app/authx/entities"
 	"github.com/stronker/authx/internal/app/authx/manager"
 	"github.com/stronker/authx/internal/app/entities"
+	"net"
+	"time"
 )
 
-// MinPasswordLength Minimum password length for user credentials set to 6
-const MinPasswordLength = 6
-
 // Authx is the struct that handles the gRPC service.
 type Authx struct {
 	// Manager is the struct responsible of the service business logic.
 	Manager *manager.Authx
+	// ClientIPHeader is the metadata key where the trusted proxies forward the address of the client. The peer
+	// address is used if it is empty.
+	ClientIPHeader string
+	// TrustedProxies are the networks of the proxies allowed to set the ClientIPHeader.
+	TrustedProxies []*net.IPNet
 }
 
-// NewAuthx creates a new handler.
+// NewAuthx creates a new handler that takes the address of the client from the peer of the requests.
 func NewAuthx(manager *manager.Authx) *Authx {
 	return &Authx{Manager: manager}
 }
 
+// NewAuthxWithTrustedProxies creates a new handler that takes the address of the client from the given metadata key
+// when the request comes from one of the trusted proxies.
+func NewAuthxWithTrustedProxies(manager *manager.Authx, clientIPHeader string, trustedProxies []*net.IPNet) *Authx {
+	return &Authx{Manager: manager, ClientIPHeader: clientIPHeader, TrustedProxies: trustedProxies}
+}
+
 // DeleteCredentials remove an existing credential using the username.
 func (h *Authx) DeleteCredentials(_ context.Context, request *pbAuthx.DeleteCredentialsRequest) (*pbCommon.Success, error) {
 	if request.Username == "" {
@@ -71,17 +82,54 @@ func (h *Authx) AddBasicCredentials(_ context.Context, request *pbAuthx.AddBasic
 	if request.Password == "" {
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("password is mandatory"))
 	}
-	if err := ValidatePassword(request.Password); err != nil {
-		return nil, err
-	}
 	
 	err := h.Manager.AddBasicCredentials(request.Username, request.OrganizationId, request.RoleId, request.Password)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// UnlockCredentials removes the lock of a user after too many failed logins.
+func (h *Authx) UnlockCredentials(_ context.Context, request *pbAuthx.UnlockCredentialsRequest) (*pbCommon.Success, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	err := h.Manager.UnlockCredentials(request.Username)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// RequestPasswordReset sends a single-use password reset token to a user. The response does not reveal if the user
+// exists.
+func (h *Authx) RequestPasswordReset(_ context.Context, request *pbAuthx.RequestPasswordResetRequest) (*pbCommon.Success, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	err := h.Manager.RequestPasswordReset(request.Username)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
 	return &pbCommon.Success{}, nil
 }
 
+// ResetPassword replaces the password of a user with a password reset token.
+func (h *Authx) ResetPassword(_ context.Context, request *pbAuthx.ResetPasswordRequest) (*pbCommon.Success, error) {
+	if request.Token == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("token is mandatory"))
+	}
+	if request.NewPassword == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("newPassword is mandatory"))
+	}
+	err := h.Manager.ResetPassword(request.Token, request.NewPassword)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
 // ChangePassword update an existing password.
 func (h *Authx) ChangePassword(ctx context.Context, request *pbAuthx.ChangePasswordRequest) (*pbCommon.Success, error) {
 	if request.Username == "" {
@@ -94,34 +142,258 @@ func (h *Authx) ChangePassword(ctx context.Context, request *pbAuthx.ChangePassw
 	if request.NewPassword == "" {
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("newPassword is mandatory"))
 	}
-	if err := ValidatePassword(request.NewPassword); err != nil {
-		return nil, err
+	
+	err := h.Manager.ChangePassword(request.Username, request.Password, request.NewPassword, h.clientInfo(ctx))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// LoginWithBasicCredentials login in the system and recovers a auth token.
+func (h *Authx) LoginWithBasicCredentials(ctx context.Context, request *pbAuthx.LoginWithBasicCredentialsRequest) (*pbAuthx.LoginResponse, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.Password == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("password is mandatory"))
 	}
 	
-	err := h.Manager.ChangePassword(request.Username, request.Password, request.NewPassword)
+	response, err := h.Manager.LoginWithBasicCredentials(request.Username, request.Password, h.clientInfo(ctx))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return response, nil
+}
+
+// LoginWithMFA completes a login with the MFA challenge and a TOTP or recovery code.
+func (h *Authx) LoginWithMFA(ctx context.Context, request *pbAuthx.LoginWithMFARequest) (*pbAuthx.LoginResponse, error) {
+	if request.Challenge == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("challenge is mandatory"))
+	}
+	if request.Code == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("code is mandatory"))
+	}
+	response, err := h.Manager.LoginWithMFA(request.Challenge, request.Code, h.clientInfo(ctx))
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
-	return &pbCommon.Success{}, nil
+	return response, nil
 }
 
-func ValidatePassword(password string) error {
-	if len(password) < MinPasswordLength {
-		return conversions.ToGRPCError(derrors.NewInvalidArgumentError("password must be at least 6 characters long"))
+// EnrollMFA generates a new TOTP secret for a user.
+func (h *Authx) EnrollMFA(ctx context.Context, request *pbAuthx.EnrollMFARequest) (*pbAuthx.MFAEnrollment, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.Password == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("password is mandatory"))
 	}
-	return nil
+	enrollment, err := h.Manager.EnrollMFA(request.Username, request.Password, h.clientInfo(ctx))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return enrollment.ToGRPC(), nil
 }
 
-// LoginWithBasicCredentials login in the system and recovers a auth token.
-func (h *Authx) LoginWithBasicCredentials(_ context.Context, request *pbAuthx.LoginWithBasicCredentialsRequest) (*pbAuthx.LoginResponse, error) {
+// VerifyMFA enables MFA with the password and a code of the enrolled secret and returns the recovery codes.
+func (h *Authx) VerifyMFA(ctx context.Context, request *pbAuthx.VerifyMFARequest) (*pbAuthx.RecoveryCodes, error) {
 	if request.Username == "" {
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
 	}
 	if request.Password == "" {
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("password is mandatory"))
 	}
-	
-	response, err := h.Manager.LoginWithBasicCredentials(request.Username, request.Password)
+	if request.Code == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("code is mandatory"))
+	}
+	codes, err := h.Manager.VerifyMFA(request.Username, request.Password, request.Code, h.clientInfo(ctx))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &pbAuthx.RecoveryCodes{Codes: codes}, nil
+}
+
+// DisableMFA disables MFA for a user.
+func (h *Authx) DisableMFA(ctx context.Context, request *pbAuthx.DisableMFARequest) (*pbCommon.Success, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.Password == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("password is mandatory"))
+	}
+	if request.Code == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("code is mandatory"))
+	}
+	err := h.Manager.DisableMFA(request.Username, request.Password, request.Code, h.clientInfo(ctx))
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// AddPersonalAccessToken creates a personal access token for a user. The token is only returned in the response.
+func (h *Authx) AddPersonalAccessToken(_ context.Context, request *pbAuthx.AddPersonalAccessTokenRequest) (*pbAuthx.NewPersonalAccessToken, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.Name == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("name is mandatory"))
+	}
+	if len(request.Primitives) == 0 {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("primitives is mandatory"))
+	}
+	if request.Expiration < 0 {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("expiration cannot be negative"))
+	}
+	accessToken, data, err := h.Manager.AddPersonalAccessToken(request.Username, request.Name,
+		manager.PrimitivesToString(request.Primitives), time.Duration(request.Expiration)*time.Second)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbAuthx.NewPersonalAccessToken{Token: accessToken, PersonalAccessToken: data.ToGRPC()}, nil
+}
+
+// ListPersonalAccessTokens returns the personal access tokens of a user.
+func (h *Authx) ListPersonalAccessTokens(_ context.Context, request *grpc_user_go.UserId) (*pbAuthx.PersonalAccessTokenList, error) {
+	if request.Email == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("email is mandatory"))
+	}
+	tokens, err := h.Manager.ListPersonalAccessTokens(request.Email)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	result := make([]*pbAuthx.PersonalAccessToken, 0, len(tokens))
+	for _, t := range tokens {
+		result = append(result, t.ToGRPC())
+	}
+	return &pbAuthx.PersonalAccessTokenList{Tokens: result}, nil
+}
+
+// RevokePersonalAccessToken removes a personal access token of a user.
+func (h *Authx) RevokePersonalAccessToken(_ context.Context, request *pbAuthx.PersonalAccessTokenId) (*pbCommon.Success, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.TokenId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("tokenID is mandatory"))
+	}
+	err := h.Manager.RevokePersonalAccessToken(request.Username, request.TokenId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// LoginWithAPIKey exchanges a personal access token for a user token.
+func (h *Authx) LoginWithAPIKey(ctx context.Context, request *pbAuthx.LoginWithAPIKeyRequest) (*pbAuthx.LoginResponse, error) {
+	if request.ApiKey == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("apiKey is mandatory"))
+	}
+	response, err := h.Manager.LoginWithAPIKey(request.ApiKey, h.clientInfo(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// AddServiceAccount creates a service account of an organization. If the request does not include a public key, the
+// response contains the client secret of the service account.
+func (h *Authx) AddServiceAccount(_ context.Context, request *pbAuthx.AddServiceAccountRequest) (*pbAuthx.NewServiceAccount, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	if request.Name == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("name is mandatory"))
+	}
+	if request.RoleId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("roleID is mandatory"))
+	}
+	account, secret, err := h.Manager.AddServiceAccount(request.OrganizationId, request.Name, request.RoleId, request.PublicKey)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbAuthx.NewServiceAccount{ServiceAccount: account.ToGRPC(), ClientSecret: secret}, nil
+}
+
+// GetServiceAccount returns a service account of an organization.
+func (h *Authx) GetServiceAccount(_ context.Context, request *pbAuthx.ServiceAccountId) (*pbAuthx.ServiceAccount, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	if request.ServiceAccountId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("serviceAccountID is mandatory"))
+	}
+	account, err := h.Manager.GetServiceAccount(request.OrganizationId, request.ServiceAccountId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return account.ToGRPC(), nil
+}
+
+// ListServiceAccounts returns the service accounts of an organization.
+func (h *Authx) ListServiceAccounts(_ context.Context, request *grpc_organization_go.OrganizationId) (*pbAuthx.ServiceAccountList, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	accounts, err := h.Manager.ListServiceAccounts(request.OrganizationId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	result := make([]*pbAuthx.ServiceAccount, 0, len(accounts))
+	for _, a := range accounts {
+		result = append(result, a.ToGRPC())
+	}
+	return &pbAuthx.ServiceAccountList{ServiceAccounts: result}, nil
+}
+
+// UpdateServiceAccount enables, disables or changes the role of a service account.
+func (h *Authx) UpdateServiceAccount(_ context.Context, request *pbAuthx.UpdateServiceAccountRequest) (*pbCommon.Success, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	if request.ServiceAccountId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("serviceAccountID is mandatory"))
+	}
+	if request.UpdateRoleId && request.RoleId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("roleID is mandatory"))
+	}
+	err := h.Manager.UpdateServiceAccount(request.OrganizationId, request.ServiceAccountId,
+		authxEntities.NewEditServiceAccountFromGRPC(request))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// RemoveServiceAccount deletes a service account.
+func (h *Authx) RemoveServiceAccount(_ context.Context, request *pbAuthx.ServiceAccountId) (*pbCommon.Success, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	if request.ServiceAccountId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("serviceAccountID is mandatory"))
+	}
+	err := h.Manager.RemoveServiceAccount(request.OrganizationId, request.ServiceAccountId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// LoginWithClientCredentials authenticates a service account with a client secret or a client assertion.
+func (h *Authx) LoginWithClientCredentials(ctx context.Context, request *pbAuthx.ClientCredentialsRequest) (*pbAuthx.LoginResponse, error) {
+	if request.OrganizationId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("organizationID is mandatory"))
+	}
+	if request.ClientId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("clientID is mandatory"))
+	}
+	if request.ClientSecret == "" && request.ClientAssertion == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("clientSecret or clientAssertion is mandatory"))
+	}
+	response, err := h.Manager.LoginWithClientCredentials(request.OrganizationId, request.ClientId,
+		request.ClientSecret, request.ClientAssertion, h.clientInfo(ctx))
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
@@ -129,7 +401,7 @@ func (h *Authx) LoginWithBasicCredentials(_ context.Context, request *pbAuthx.Lo
 }
 
 // RefreshToken renews an existing token.
-func (h *Authx) RefreshToken(_ context.Context, request *pbAuthx.RefreshTokenRequest) (*pbAuthx.LoginResponse, error) {
+func (h *Authx) RefreshToken(ctx context.Context, request *pbAuthx.RefreshTokenRequest) (*pbAuthx.LoginResponse, error) {
 	
 	if request.RefreshToken == "" {
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("refreshToken is mandatory"))
@@ -139,13 +411,99 @@ func (h *Authx) RefreshToken(_ context.Context, request *pbAuthx.RefreshTokenReq
 		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("token is mandatory"))
 	}
 	
-	response, err := h.Manager.RefreshToken(request.Token, request.RefreshToken)
+	response, err := h.Manager.RefreshToken(request.Token, request.RefreshToken, h.clientInfo(ctx))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return response, nil
+}
+
+// ExchangeToken returns a token derived from a valid token with a subset of its primitives.
+func (h *Authx) ExchangeToken(_ context.Context, request *pbAuthx.ExchangeTokenRequest) (*pbAuthx.LoginResponse, error) {
+	if request.SubjectToken == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("subjectToken is mandatory"))
+	}
+	if len(request.Primitives) == 0 {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("primitives is mandatory"))
+	}
+	if request.Expiration < 0 {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("expiration cannot be negative"))
+	}
+	response, err := h.Manager.ExchangeToken(request.SubjectToken, request.ActorToken,
+		manager.PrimitivesToString(request.Primitives), request.Audience, time.Duration(request.Expiration)*time.Second)
 	if err != nil {
 		return nil, conversions.ToGRPCError(err)
 	}
 	return response, nil
 }
 
+// Logout revokes a token and its refresh token.
+func (h *Authx) Logout(_ context.Context, request *pbAuthx.LogoutRequest) (*pbCommon.Success, error) {
+	if request.Token == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("token is mandatory"))
+	}
+	err := h.Manager.Logout(request.Token)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// IsTokenRevoked checks if a token has been revoked.
+func (h *Authx) IsTokenRevoked(_ context.Context, request *pbAuthx.TokenId) (*pbAuthx.TokenRevocation, error) {
+	if request.TokenId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("tokenID is mandatory"))
+	}
+	revoked, err := h.Manager.IsTokenRevoked(request.TokenId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbAuthx.TokenRevocation{TokenId: request.TokenId, Revoked: revoked}, nil
+}
+
+// ListSessions returns the active sessions of a user.
+func (h *Authx) ListSessions(_ context.Context, request *grpc_user_go.UserId) (*pbAuthx.SessionList, error) {
+	if request.Email == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("email is mandatory"))
+	}
+	sessions, err := h.Manager.ListSessions(request.Email)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	result := make([]*pbAuthx.Session, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, s.ToGRPC())
+	}
+	return &pbAuthx.SessionList{Sessions: result}, nil
+}
+
+// TerminateSession revokes the tokens of a session of a user.
+func (h *Authx) TerminateSession(_ context.Context, request *pbAuthx.SessionId) (*pbCommon.Success, error) {
+	if request.Username == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("username is mandatory"))
+	}
+	if request.TokenId == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("tokenID is mandatory"))
+	}
+	err := h.Manager.TerminateSession(request.Username, request.TokenId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// Introspect returns the state and the claims of a user or device token.
+func (h *Authx) Introspect(_ context.Context, request *pbAuthx.IntrospectRequest) (*pbAuthx.IntrospectResponse, error) {
+	if request.Token == "" {
+		return nil, conversions.ToGRPCError(derrors.NewInvalidArgumentError("token is mandatory"))
+	}
+	result, err := h.Manager.Introspect(request.Token)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return result.ToGRPC(), nil
+}
+
 // AddRole adds a role with a authorization properties.
 func (h *Authx) AddRole(_ context.Context, request *pbAuthx.Role) (*pbCommon.Success, error) {
 	if request.RoleId == "" {
@@ -202,6 +560,45 @@ func (h *Authx) ListRoles(ctx context.Context, organizationID *grpc_organization
 	}, nil
 }
 
+// SetMFARequirement defines which users of an organization must use MFA.
+func (h *Authx) SetMFARequirement(ctx context.Context, requirement *grpc_authx_go.MFARequirement) (*pbCommon.Success, error) {
+	vErr := entities.ValidMFARequirement(requirement)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	err := h.Manager.SetMFARequirement(authxEntities.NewMFARequirementFromGRPC(requirement))
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
+// GetMFARequirement retrieves the MFA requirement of an organization.
+func (h *Authx) GetMFARequirement(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*grpc_authx_go.MFARequirement, error) {
+	vErr := entities.ValidOrganizationID(organizationID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	requirement, err := h.Manager.GetMFARequirement(organizationID.OrganizationId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return requirement.ToGRPC(), nil
+}
+
+// RemoveMFARequirement removes the MFA requirement of an organization.
+func (h *Authx) RemoveMFARequirement(ctx context.Context, organizationID *grpc_organization_go.OrganizationId) (*pbCommon.Success, error) {
+	vErr := entities.ValidOrganizationID(organizationID)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	err := h.Manager.RemoveMFARequirement(organizationID.OrganizationId)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return &pbCommon.Success{}, nil
+}
+
 // Retrieve the role associated with a user.
 func (h *Authx) GetUserRole(ctx context.Context, userID *grpc_user_go.UserId) (*grpc_authx_go.Role, error) {
 	vErr := entities.ValidUserID(userID)
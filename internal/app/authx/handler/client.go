@@ -0,0 +1,82 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package handler
+
+import (
+	"context"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"strings"
+)
+
+// UserAgentHeader is the metadata key that contains the user agent of the gRPC client.
+const UserAgentHeader = "user-agent"
+
+// clientInfo extracts the address and the user agent of the client from the context of a request. The address is the
+// peer of the request unless the peer is a trusted proxy. Behind a trusted proxy the address is taken from the
+// metadata key set by the proxies, skipping from the end the entries appended by other trusted proxies. The previous
+// entries are sent by the client and cannot be trusted.
+func (h *Authx) clientInfo(ctx context.Context) *entities.ClientInfo {
+	client := &entities.ClientInfo{IP: peerIP(ctx)}
+	md, hasMetadata := metadata.FromIncomingContext(ctx)
+	if h.ClientIPHeader != "" && hasMetadata && h.trustedProxy(client.IP) {
+		if values := md.Get(h.ClientIPHeader); len(values) > 0 {
+			entries := strings.Split(values[len(values)-1], ",")
+			for i := len(entries) - 1; i >= 0; i-- {
+				client.IP = strings.TrimSpace(entries[i])
+				if !h.trustedProxy(client.IP) {
+					break
+				}
+			}
+		}
+	}
+	if hasMetadata {
+		if values := md.Get(UserAgentHeader); len(values) > 0 {
+			client.UserAgent = values[0]
+		}
+	}
+	return client
+}
+
+// trustedProxy checks if an address belongs to one of the trusted proxies.
+func (h *Authx) trustedProxy(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range h.TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the address of the peer that sent a request.
+func peerIP(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		return p.Addr.String()
+	}
+	return host
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package handler
+
+import (
+	"context"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+)
+
+var _ = ginkgo.Describe("Client info", func() {
+	gateway := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 443}}
+	_, proxies, _ := net.ParseCIDR("10.0.0.0/24")
+
+	ginkgo.It("takes the address appended by a trusted proxy", func() {
+		h := NewAuthxWithTrustedProxies(nil, "x-forwarded-for", []*net.IPNet{proxies})
+		md := metadata.Pairs("x-forwarded-for", "1.1.1.1, 192.168.1.10, 10.0.0.2", UserAgentHeader, "test-agent")
+		ctx := peer.NewContext(metadata.NewIncomingContext(context.Background(), md), gateway)
+		client := h.clientInfo(ctx)
+		gomega.Expect(client.IP).To(gomega.Equal("192.168.1.10"))
+		gomega.Expect(client.UserAgent).To(gomega.Equal("test-agent"))
+	})
+
+	ginkgo.It("ignores the header sent by a client that is not a trusted proxy", func() {
+		h := NewAuthxWithTrustedProxies(nil, "x-forwarded-for", []*net.IPNet{proxies})
+		md := metadata.Pairs("x-forwarded-for", "1.1.1.1")
+		client := &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 5000}}
+		ctx := peer.NewContext(metadata.NewIncomingContext(context.Background(), md), client)
+		gomega.Expect(h.clientInfo(ctx).IP).To(gomega.Equal("192.168.1.10"))
+	})
+
+	ginkgo.It("uses the peer address by default", func() {
+		h := NewAuthx(nil)
+		md := metadata.Pairs("x-forwarded-for", "1.1.1.1")
+		ctx := peer.NewContext(metadata.NewIncomingContext(context.Background(), md), gateway)
+		gomega.Expect(h.clientInfo(ctx).IP).To(gomega.Equal("10.0.0.1"))
+	})
+})
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package handler
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/stronker/authx/internal/app/authx/manager"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// PasswordField is the field reported in the violations of the password policy.
+const PasswordField = "password"
+
+// PasswordExpiredViolation is the type of the precondition failure reported when a password has expired.
+const PasswordExpiredViolation = "PASSWORD_EXPIRED"
+
+// toGRPCError transforms an error of the manager into a gRPC error. The violations of the password policy are
+// included as BadRequest details so the clients can show them to the user, and the expired passwords are reported
+// as a PreconditionFailure so the clients can ask the user to change it.
+func toGRPCError(err derrors.Error) error {
+	if expiredErr, ok := err.(*manager.PasswordExpiredError); ok {
+		return passwordExpiredError(expiredErr)
+	}
+	policyErr, ok := err.(*manager.PasswordPolicyError)
+	if !ok {
+		return conversions.ToGRPCError(err)
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range policyErr.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       PasswordField,
+			Description: v.Rule + ": " + v.Message,
+		})
+	}
+	st, sErr := status.New(codes.InvalidArgument, policyErr.Error()).WithDetails(badRequest)
+	if sErr != nil {
+		return conversions.ToGRPCError(err)
+	}
+	return st.Err()
+}
+
+// passwordExpiredError transforms the error of an expired password into a FailedPrecondition gRPC error.
+func passwordExpiredError(err *manager.PasswordExpiredError) error {
+	preconditionFailure := &errdetails.PreconditionFailure{
+		Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        PasswordExpiredViolation,
+			Subject:     err.Username,
+			Description: manager.PasswordExpiredMessage,
+		}},
+	}
+	st, sErr := status.New(codes.FailedPrecondition, err.Error()).WithDetails(preconditionFailure)
+	if sErr != nil {
+		return conversions.ToGRPCError(err)
+	}
+	return st.Err()
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package jwks
+
+import (
+	"encoding/json"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/rs/zerolog/log"
+	"net/http"
+)
+
+// Path where the key set is published.
+const Path = "/.well-known/jwks.json"
+
+// KeySource provides the current set of public keys.
+type KeySource interface {
+	// KeySet returns the public keys that are valid to verify tokens.
+	KeySet() *token.JSONWebKeySet
+}
+
+// Handler publishes the public keys used to sign the JWT tokens.
+type Handler struct {
+	source KeySource
+}
+
+// NewHandler creates a new handler. The key set is read on every request so rotated keys are published as soon as
+// they are loaded.
+func NewHandler(source KeySource) *Handler {
+	return &Handler{source: source}
+}
+
+// ServeHTTP returns the JWKS document.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(h.source.KeySet())
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot write JWKS response")
+	}
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/rs/zerolog/log"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"github.com/stronker/authx/internal/app/authx/providers/access_token"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAccessTokenExpiration is the default maximum time a personal access token can be used.
+const DefaultMaxAccessTokenExpiration = 90 * 24 * time.Hour
+
+// PersonalAccessTokenPrefix is the prefix of the personal access tokens. It helps to identify leaked tokens.
+const PersonalAccessTokenPrefix = "pat_"
+
+// accessTokenLength is the number of random bytes of a personal access token.
+const accessTokenLength = 32
+
+// AccessTokens contains the storage and the limits of the personal access tokens.
+type AccessTokens struct {
+	Provider access_token.Provider
+	// MaxExpiration is the maximum time a personal access token can be used.
+	MaxExpiration time.Duration
+}
+
+// NewAccessTokens creates a new AccessTokens.
+func NewAccessTokens(provider access_token.Provider, maxExpiration time.Duration) *AccessTokens {
+	return &AccessTokens{Provider: provider, MaxExpiration: maxExpiration}
+}
+
+// NewAccessTokensMockup creates an AccessTokens with in-memory storage.
+func NewAccessTokensMockup() *AccessTokens {
+	return NewAccessTokens(access_token.NewAccessTokenMockup(), DefaultMaxAccessTokenExpiration)
+}
+
+// AddPersonalAccessToken creates a named personal access token for a user with a subset of the primitives of its
+// role. If the expiration is zero, the maximum expiration is used. The token is only returned by this method, only its
+// hash is stored.
+func (m *Authx) AddPersonalAccessToken(username string, name string, primitives []string, expiration time.Duration) (string, *entities.PersonalAccessTokenData, derrors.Error) {
+	if expiration < 0 || expiration > m.AccessTokens.MaxExpiration {
+		return "", nil, derrors.NewInvalidArgumentError("invalid expiration of the personal access token").
+			WithParams(expiration.String(), m.AccessTokens.MaxExpiration.String())
+	}
+	if expiration == 0 {
+		expiration = m.AccessTokens.MaxExpiration
+	}
+	credentials, err := m.CredentialsProvider.Get(username)
+	if err != nil {
+		return "", nil, err
+	}
+	role, err := m.RoleProvider.Get(credentials.OrganizationID, credentials.RoleID)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, p := range primitives {
+		if !containsPrimitive(role.Primitives, p) {
+			return "", nil, derrors.NewInvalidArgumentError("the role of the user does not include the primitive").
+				WithParams(username, p)
+		}
+	}
+	secret, err := generateSecretToken(accessTokenLength)
+	if err != nil {
+		return "", nil, err
+	}
+	accessToken := PersonalAccessTokenPrefix + secret
+	now := time.Now()
+	data := entities.NewPersonalAccessTokenData(username, token.GenerateUUID(), name, hashSecretToken(accessToken),
+		primitives, now.Unix(), now.Add(expiration).Unix())
+	err = m.AccessTokens.Provider.Add(data)
+	if err != nil {
+		return "", nil, err
+	}
+	return accessToken, data, nil
+}
+
+// ListPersonalAccessTokens returns the personal access tokens of a user.
+func (m *Authx) ListPersonalAccessTokens(username string) ([]entities.PersonalAccessTokenData, derrors.Error) {
+	return m.AccessTokens.Provider.List(username)
+}
+
+// RevokePersonalAccessToken removes a personal access token of a user and revokes the user tokens obtained with it.
+func (m *Authx) RevokePersonalAccessToken(username string, tokenID string) derrors.Error {
+	err := m.AccessTokens.Provider.Delete(username, tokenID)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeBySource(username, tokenID)
+}
+
+// LoginWithAPIKey exchanges a personal access token for a user token. The claim of the token contains the primitives
+// of the personal access token that are still included in the role of the user.
+func (m *Authx) LoginWithAPIKey(apiKey string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	if !strings.HasPrefix(apiKey, PersonalAccessTokenPrefix) {
+		return nil, derrors.NewUnauthenticatedError("invalid API key")
+	}
+	accessToken, err := m.AccessTokens.Provider.GetByHash(hashSecretToken(apiKey))
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("invalid API key")
+		}
+		return nil, err
+	}
+	personalClaim, err := m.accessTokenClaim(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	err = m.AccessTokens.Provider.UpdateLastUsed(accessToken.Username, accessToken.TokenID, time.Now().Unix())
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("username", accessToken.Username).
+			Str("tokenID", accessToken.TokenID).Msg("cannot update the last use of the personal access token")
+	}
+	gToken, err := m.Token.Generate(personalClaim, m.accessTokenExpiration(accessToken), m.secret, client)
+	if err != nil {
+		return nil, err
+	}
+	return &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken}, nil
+}
+
+// refreshAccessTokenClaim builds the claim of a refreshed token obtained with a personal access token. The refresh
+// fails if the personal access token has expired or has been revoked.
+func (m *Authx) refreshAccessTokenClaim(claim *token.Claim) (*token.PersonalClaim, *entities.PersonalAccessTokenData, derrors.Error) {
+	accessToken, err := m.AccessTokens.Provider.Get(claim.UserID, claim.TokenSourceID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, nil, derrors.NewUnauthenticatedError("the personal access token has been revoked").
+				WithParams(claim.UserID, claim.TokenSourceID)
+		}
+		return nil, nil, err
+	}
+	personalClaim, err := m.accessTokenClaim(accessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	return personalClaim, accessToken, nil
+}
+
+// accessTokenClaim builds the claim of a valid personal access token with the current information of the user.
+// Users that must enrol MFA cannot use their personal access tokens until they do it.
+func (m *Authx) accessTokenClaim(accessToken *entities.PersonalAccessTokenData) (*token.PersonalClaim, derrors.Error) {
+	if time.Now().Unix() > accessToken.ExpirationDate {
+		return nil, derrors.NewUnauthenticatedError("the personal access token has expired").
+			WithParams(accessToken.Username, accessToken.TokenID)
+	}
+	credentials, err := m.CredentialsProvider.Get(accessToken.Username)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("the user no longer exists", err).WithParams(accessToken.Username)
+		}
+		return nil, err
+	}
+	role, err := m.RoleProvider.Get(credentials.OrganizationID, credentials.RoleID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("the role of the user no longer exists", err).
+				WithParams(credentials.Username, credentials.RoleID)
+		}
+		return nil, err
+	}
+	if !credentials.MFAEnabled {
+		required, err := m.mfaRequired(credentials.OrganizationID, role.Primitives)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			return nil, derrors.NewUnauthenticatedError("MFA enrolment is required").WithParams(credentials.Username)
+		}
+	}
+	primitives := make([]string, 0, len(accessToken.Primitives))
+	for _, p := range accessToken.Primitives {
+		if containsPrimitive(role.Primitives, p) {
+			primitives = append(primitives, p)
+		}
+	}
+	if len(primitives) == 0 {
+		return nil, derrors.NewUnauthenticatedError("the role of the user does not include the primitives of the personal access token").
+			WithParams(accessToken.Username, accessToken.TokenID)
+	}
+	claim := token.NewPersonalClaim(credentials.Username, role.Name, primitives, credentials.OrganizationID)
+	claim.TokenSource = token.PersonalAccessTokenSource
+	claim.TokenSourceID = accessToken.TokenID
+	return claim, nil
+}
+
+// accessTokenExpiration returns the expiration of the user tokens obtained with a personal access token, which cannot
+// be used after the personal access token expires.
+func (m *Authx) accessTokenExpiration(accessToken *entities.PersonalAccessTokenData) time.Duration {
+	remaining := time.Unix(accessToken.ExpirationDate, 0).Sub(time.Now())
+	if remaining < m.expirationDuration {
+		return remaining
+	}
+	return m.expirationDuration
+}
+
+// containsPrimitive checks if a list of primitives includes a primitive.
+func containsPrimitive(primitives []string, primitive string) bool {
+	for _, p := range primitives {
+		if p == primitive {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"strings"
+	"time"
+)
+
+var _ = ginkgo.Describe("Personal access tokens", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+	apps := pbAuthx.AccessPrimitive_APPS.String()
+	resources := pbAuthx.AccessPrimitive_RESOURCES.String()
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives: []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG, pbAuthx.AccessPrimitive_APPS,
+				pbAuthx.AccessPrimitive_RESOURCES},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should exchange a personal access token for a user token", func() {
+		apiKey, data, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(strings.HasPrefix(apiKey, PersonalAccessTokenPrefix)).To(gomega.BeTrue())
+		gomega.Expect(data.TokenHash).NotTo(gomega.ContainSubstring(apiKey))
+
+		response, err := manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		claim := tokenClaim(response.Token)
+		gomega.Expect(claim.UserID).To(gomega.Equal(userName))
+		gomega.Expect(claim.Primitives).To(gomega.Equal([]string{apps}))
+		gomega.Expect(claim.TokenSource).To(gomega.Equal(token.PersonalAccessTokenSource))
+		gomega.Expect(claim.TokenSourceID).To(gomega.Equal(data.TokenID))
+
+		list, err := manager.ListPersonalAccessTokens(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(list).To(gomega.HaveLen(1))
+		gomega.Expect(list[0].Name).To(gomega.Equal("ci"))
+		gomega.Expect(list[0].LastUsed).NotTo(gomega.BeZero())
+
+		refreshed, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		refreshedClaim := tokenClaim(refreshed.Token)
+		gomega.Expect(refreshedClaim.Primitives).To(gomega.Equal([]string{apps}))
+		gomega.Expect(refreshedClaim.TokenSourceID).To(gomega.Equal(data.TokenID))
+	})
+
+	ginkgo.It("should not grant primitives that are not included in the role", func() {
+		_, _, err := manager.AddPersonalAccessToken(userName, "ci", []string{pbAuthx.AccessPrimitive_ORG_MNGT.String()}, time.Hour)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+
+		apiKey, _, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps, resources}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.RoleProvider.Edit(organizationID, roleID, entities.NewEditRoleData().
+			WithPrimitives([]string{pbAuthx.AccessPrimitive_ORG.String(), resources}))
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(response.Token).Primitives).To(gomega.Equal([]string{resources}))
+	})
+
+	ginkgo.It("should limit the expiration", func() {
+		_, _, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, DefaultMaxAccessTokenExpiration+time.Hour)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+
+		_, data, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(data.ExpirationDate - data.CreationDate).To(gomega.Equal(int64(DefaultMaxAccessTokenExpiration.Seconds())))
+	})
+
+	ginkgo.It("should reject expired personal access tokens", func() {
+		apiKey := PersonalAccessTokenPrefix + "expired"
+		past := time.Now().Add(-time.Hour).Unix()
+		err := manager.AccessTokens.Provider.Add(entities.NewPersonalAccessTokenData(userName, "t1", "ci",
+			hashSecretToken(apiKey), []string{apps}, past, past))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should reject revoked personal access tokens", func() {
+		apiKey, data, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.RevokePersonalAccessToken(userName, data.TokenID)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		_, err = manager.RefreshToken(response.Token, response.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should revoke the tokens obtained with a revoked personal access token", func() {
+		apiKey, data, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		session, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.RevokePersonalAccessToken(userName, data.TokenID)
+		gomega.Expect(err).To(gomega.Succeed())
+		expectRevoked(manager, response)
+
+		revoked, err := manager.IsTokenRevoked(tokenClaim(session.Token).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should reject unknown API keys", func() {
+		_, err := manager.LoginWithAPIKey(PersonalAccessTokenPrefix+"unknown", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should require the enrolment of MFA when the organization requires it", func() {
+		apiKey, _, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.SetMFARequirement(entities.NewMFARequirementData(organizationID, true, []string{}))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should remove the personal access tokens with the credentials", func() {
+		apiKey, _, err := manager.AddPersonalAccessToken(userName, "ci", []string{apps}, time.Hour)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.DeleteCredentials(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		list, err := manager.ListPersonalAccessTokens(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(list).To(gomega.BeEmpty())
+		_, err = manager.LoginWithAPIKey(apiKey, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
@@ -24,11 +24,13 @@ import (
 	"github.com/nalej/grpc-device-go"
 	"github.com/nalej/grpc-organization-go"
 	"github.com/nalej/grpc-user-go"
+	"github.com/rs/zerolog/log"
 	"github.com/stronker/authx/internal/app/authx/entities"
 	"github.com/stronker/authx/internal/app/authx/providers/credentials"
 	"github.com/stronker/authx/internal/app/authx/providers/device"
 	"github.com/stronker/authx/internal/app/authx/providers/device_token"
 	"github.com/stronker/authx/internal/app/authx/providers/role"
+	"github.com/stronker/authx/internal/app/authx/providers/service_account"
 	"time"
 )
 
@@ -51,24 +53,36 @@ type Authx struct {
 	DeviceToken         DeviceToken                  // device_token
 	DeviceExpiration    time.Duration                // device_token expiration
 	DeviceTokenProvider device_token.Provider
+	Lockout             Lockout // brute-force protection of the basic credentials
+	PasswordPolicies    *PasswordPolicies
+	PasswordReset       *PasswordReset // self-service password reset
+	AccessTokens        *AccessTokens  // personal access tokens
+	// ServiceAccountProvider stores the service accounts of the organizations.
+	ServiceAccountProvider service_account.Provider
 }
 
 // NewAuthx creates a new manager.
 func NewAuthx(password Password, tokenManager Token, deviceToken DeviceToken, credentialsProvider credentials.BasicCredentials,
 	roleProvide role.Role, deviceProvider device.Provider, secret string, expirationDuration time.Duration, deviceExpiration time.Duration,
-	deviceTokenProvider device_token.Provider) *Authx {
+	deviceTokenProvider device_token.Provider, lockout Lockout, passwordPolicies *PasswordPolicies,
+	passwordReset *PasswordReset, accessTokens *AccessTokens, serviceAccountProvider service_account.Provider) *Authx {
 	
 	return &Authx{
-		Password:            password,
-		Token:               tokenManager,
-		CredentialsProvider: credentialsProvider,
-		RoleProvider:        roleProvide,
-		DeviceProvider:      deviceProvider,
-		secret:              secret,
-		expirationDuration:  expirationDuration,
-		DeviceToken:         deviceToken,
-		DeviceExpiration:    deviceExpiration,
-		DeviceTokenProvider: deviceTokenProvider,
+		Password:               password,
+		Token:                  tokenManager,
+		CredentialsProvider:    credentialsProvider,
+		RoleProvider:           roleProvide,
+		DeviceProvider:         deviceProvider,
+		secret:                 secret,
+		expirationDuration:     expirationDuration,
+		DeviceToken:            deviceToken,
+		DeviceExpiration:       deviceExpiration,
+		DeviceTokenProvider:    deviceTokenProvider,
+		Lockout:                lockout,
+		PasswordPolicies:       passwordPolicies,
+		PasswordReset:          passwordReset,
+		AccessTokens:           accessTokens,
+		ServiceAccountProvider: serviceAccountProvider,
 	}
 	
 }
@@ -82,12 +96,21 @@ func NewAuthxMockup() *Authx {
 	return NewAuthx(NewBCryptPassword(), NewJWTTokenMockup(), NewJWTDeviceToken(dcProvider, dtMockup),
 		credentials.NewBasicCredentialMockup(), role.NewRoleMockup(),
 		dcProvider, DefaultSecret, d, e,
-		dtMockup)
+		dtMockup, NewLockoutMockup(), NewPasswordPolicies(NewDefaultPasswordPolicy()), NewPasswordResetMockup(),
+		NewAccessTokensMockup(), service_account.NewServiceAccountMockup())
 }
 
 // DeleteCredentials deletes the credential for a specific username.
 func (m *Authx) DeleteCredentials(username string) derrors.Error {
-	return m.CredentialsProvider.Delete(username)
+	err := m.CredentialsProvider.Delete(username)
+	if err != nil {
+		return err
+	}
+	err = m.AccessTokens.Provider.DeleteByUsername(username)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeAll(username)
 }
 
 // AddBasicCredentials generate credential for a specific user.
@@ -96,6 +119,10 @@ func (m *Authx) AddBasicCredentials(username string, organizationID string, role
 	if err != nil {
 		return err
 	}
+	err = m.checkPasswordPolicy(organizationID, username, password)
+	if err != nil {
+		return err
+	}
 	
 	exist, err := m.CredentialsProvider.Exist(username)
 	if err != nil {
@@ -114,57 +141,370 @@ func (m *Authx) AddBasicCredentials(username string, organizationID string, role
 	return m.CredentialsProvider.Add(entity)
 }
 
-// LoginWithBasicCredentials check the password and returns a valid token.
-func (m *Authx) LoginWithBasicCredentials(username string, password string) (*pbAuthx.LoginResponse, derrors.Error) {
+// LoginWithBasicCredentials check the password and returns a valid token. The client is recorded in the session.
+// The failed attempts are counted per username and client, and the login is rejected while any of them is locked.
+// If the user has enabled MFA, the response only contains a challenge that must be completed with LoginWithMFA.
+func (m *Authx) LoginWithBasicCredentials(username string, password string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	err := m.Lockout.Attempt(username, client)
+	if err != nil {
+		return nil, err
+	}
 	credentials, err := m.CredentialsProvider.Get(username)
 	if err != nil {
+		if err.Type() == derrors.NotFound {
+			// Unknown usernames are reported as invalid credentials to avoid revealing which users exist.
+			return nil, m.loginFailure(username)
+		}
+		m.releaseAttempt(username, client)
 		return nil, err
 	}
 	err = m.Password.CompareHashAndPassword(credentials.Password, password)
 	if err != nil {
-		return nil, err
+		return nil, m.loginFailure(username)
 	}
-	role, err := m.RoleProvider.Get(credentials.OrganizationID, credentials.RoleID)
+	if m.Password.NeedsRehash(credentials.Password) {
+		m.rehashPassword(username, password)
+	}
+	if credentials.MFAEnabled {
+		// With MFA the previous failures are kept until the second factor is verified.
+		m.releaseAttempt(username, client)
+	} else {
+		m.loginSuccess(username, client)
+	}
+	policy := m.PasswordPolicies.Policy(credentials.OrganizationID)
+	if policy.IsExpired(credentials.PasswordChangedAt) {
+		return nil, NewPasswordExpiredError(username)
+	}
+	if credentials.MFAEnabled {
+		return m.mfaChallenge(username)
+	}
+	return m.loginResponse(credentials, []string{token.PasswordAuthentication}, client)
+}
+
+// loginSuccess resets the failed login attempts of a user and releases the attempt of the client.
+func (m *Authx) loginSuccess(username string, client *entities.ClientInfo) {
+	err := m.Lockout.Success(username, client)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("username", username).Msg("cannot reset failed login attempts")
+	}
+}
+
+// releaseAttempt releases the reserved attempt of a login that did not fail, either because the first factor was
+// valid or because of an internal error.
+func (m *Authx) releaseAttempt(username string, client *entities.ClientInfo) {
+	err := m.Lockout.Release(username, client)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("username", username).Msg("cannot release login attempt")
+	}
+}
+
+// loginResponse generates the tokens of a new session authenticated with a set of methods.
+func (m *Authx) loginResponse(credentials *entities.BasicCredentialsData, methods []string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	personalClaim, err := m.personalClaim(credentials, methods)
 	if err != nil {
 		return nil, err
 	}
-	personalClaim := token.NewPersonalClaim(username, role.Name, role.Primitives, credentials.OrganizationID)
-	gToken, err := m.Token.Generate(personalClaim, m.expirationDuration, m.secret)
+	gToken, err := m.Token.Generate(personalClaim, m.expirationDuration, m.secret, client)
 	if err != nil {
 		
 		return nil, err
 	}
-	response := &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken}
+	response := &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken,
+		MfaEnrolmentRequired: personalClaim.IsMFAEnrolment()}
 	return response, nil
 }
 
-func (m *Authx) ChangePassword(username string, password string, newPassword string) derrors.Error {
-	credentials, err := m.CredentialsProvider.Get(username)
+// rehashPassword updates the hash of a password generated with an outdated algorithm or parameters. The login is not
+// affected if the hash cannot be updated.
+func (m *Authx) rehashPassword(username string, password string) {
+	hashedPassword, err := m.Password.GenerateHashedPassword(password)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("username", username).Msg("cannot rehash password")
+		return
+	}
+	edit := entities.NewEditBasicCredentialsData().WithPassword(hashedPassword)
+	err = m.CredentialsProvider.Edit(username, edit)
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("username", username).Msg("cannot store rehashed password")
+	}
+}
+
+// loginFailure returns the error for the client of a failed login. The failure is already counted by the reserved
+// attempt.
+func (m *Authx) loginFailure(username string) derrors.Error {
+	return derrors.NewUnauthenticatedError("invalid credentials").WithParams(username)
+}
+
+// UnlockCredentials removes the lock and the failed login attempts of a user.
+func (m *Authx) UnlockCredentials(username string) derrors.Error {
+	exists, err := m.CredentialsProvider.Exist(username)
 	if err != nil {
 		return err
 	}
+	if !*exists {
+		return derrors.NewNotFoundError("credentials").WithParams(username)
+	}
+	return m.Lockout.Unlock(username)
+}
+
+// checkPasswordPolicy returns an error with the violations if a password does not satisfy the policy of the
+// organization.
+func (m *Authx) checkPasswordPolicy(organizationID string, username string, password string) derrors.Error {
+	violations := m.PasswordPolicies.Validate(organizationID, username, password)
+	if len(violations) > 0 {
+		return NewPasswordPolicyError(violations)
+	}
+	return nil
+}
+
+// checkPasswordHistory returns an error if a new password matches the current password or any of the previous ones
+// kept by the policy.
+func (m *Authx) checkPasswordHistory(policy PasswordPolicy, credentials *entities.BasicCredentialsData, newPassword string) derrors.Error {
+	if policy.HistorySize <= 0 {
+		return nil
+	}
+	previous := append([][]byte{credentials.Password}, credentials.PasswordHistory...)
+	if len(previous) > policy.HistorySize {
+		previous = previous[:policy.HistorySize]
+	}
+	for _, hashedPassword := range previous {
+		if m.Password.CompareHashAndPassword(hashedPassword, newPassword) == nil {
+			return NewPasswordReusedError(policy.HistorySize)
+		}
+	}
+	return nil
+}
+
+// passwordHistory returns the history after a password change: the replaced password followed by the previous ones,
+// limited to the ones that, together with the new password, are checked by the policy.
+func passwordHistory(policy PasswordPolicy, credentials *entities.BasicCredentialsData) [][]byte {
+	if policy.HistorySize <= 1 {
+		return [][]byte{}
+	}
+	history := append([][]byte{credentials.Password}, credentials.PasswordHistory...)
+	if len(history) > policy.HistorySize-1 {
+		history = history[:policy.HistorySize-1]
+	}
+	return history
+}
+
+// ChangePassword replaces the password of a user after checking the current one. The new password must satisfy the
+// policy and must not be in the password history. All the sessions of the user are revoked.
+func (m *Authx) ChangePassword(username string, password string, newPassword string, client *entities.ClientInfo) derrors.Error {
+	credentials, err := m.checkPassword(username, password, client)
+	if err != nil {
+		return err
+	}
+	m.loginSuccess(username, client)
+	return m.setPassword(credentials, newPassword)
+}
+
+// checkPassword verifies the current password of a user before a sensitive operation. The attempt is reserved in the
+// lockout like the logins so the operation cannot be used to guess the password. If the password is valid the caller
+// resets the failures once all the factors of the operation are verified, or releases the attempt.
+func (m *Authx) checkPassword(username string, password string, client *entities.ClientInfo) (*entities.BasicCredentialsData, derrors.Error) {
+	err := m.Lockout.Attempt(username, client)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := m.CredentialsProvider.Get(username)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, m.loginFailure(username)
+		}
+		m.releaseAttempt(username, client)
+		return nil, err
+	}
 	err = m.Password.CompareHashAndPassword(credentials.Password, password)
+	if err != nil {
+		return nil, m.loginFailure(username)
+	}
+	return credentials, nil
+}
+
+// setPassword replaces the password of a user if it satisfies the policy and it is not in the password history. All
+// the sessions of the user are revoked.
+func (m *Authx) setPassword(credentials *entities.BasicCredentialsData, newPassword string) derrors.Error {
+	policy, err := m.checkNewPassword(credentials, newPassword)
 	if err != nil {
 		return err
 	}
+	return m.savePassword(policy, credentials, newPassword)
+}
+
+// checkNewPassword checks that a new password satisfies the policy of the user and it is not in the password
+// history. It returns the policy of the user.
+func (m *Authx) checkNewPassword(credentials *entities.BasicCredentialsData, newPassword string) (PasswordPolicy, derrors.Error) {
+	err := m.checkPasswordPolicy(credentials.OrganizationID, credentials.Username, newPassword)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	policy := m.PasswordPolicies.Policy(credentials.OrganizationID)
+	err = m.checkPasswordHistory(policy, credentials, newPassword)
+	if err != nil {
+		return PasswordPolicy{}, err
+	}
+	return policy, nil
+}
+
+// savePassword stores a new password that has already been checked and revokes all the sessions and the password
+// reset tokens of the user.
+func (m *Authx) savePassword(policy PasswordPolicy, credentials *entities.BasicCredentialsData, newPassword string) derrors.Error {
 	hashedPassword, err := m.Password.GenerateHashedPassword(newPassword)
 	if err != nil {
 		return err
 	}
-	edit := entities.NewEditBasicCredentialsData().WithPassword(hashedPassword)
-	return m.CredentialsProvider.Edit(username, edit)
+	edit := entities.NewEditBasicCredentialsData().WithPasswordChange(hashedPassword, passwordHistory(policy, credentials))
+	err = m.CredentialsProvider.Edit(credentials.Username, edit)
+	if err != nil {
+		return err
+	}
+	err = m.PasswordReset.Revoke(credentials.Username)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeAll(credentials.Username)
+}
+
+// RequestPasswordReset issues a single-use reset token and sends it to the user. Unknown usernames are ignored to
+// avoid revealing which users exist. It fails if no notifier is configured.
+func (m *Authx) RequestPasswordReset(username string) derrors.Error {
+	if !m.PasswordReset.Enabled() {
+		return derrors.NewFailedPreconditionError("password reset is disabled, there is no notifier")
+	}
+	exists, err := m.CredentialsProvider.Exist(username)
+	if err != nil {
+		return err
+	}
+	if !*exists {
+		log.Debug().Str("username", username).Msg("password reset requested for unknown user")
+		return nil
+	}
+	return m.PasswordReset.Issue(username)
 }
 
-// RefreshToken renew an old token.
-func (m *Authx) RefreshToken(oldToken string, refreshToken string) (*pbAuthx.LoginResponse, derrors.Error) {
-	gToken, err := m.Token.Refresh(oldToken, refreshToken, m.expirationDuration, m.secret)
+// ResetPassword consumes a reset token and replaces the password of its user. The new password must satisfy the
+// policy. All the sessions of the user are revoked and any lock of the credentials is removed.
+func (m *Authx) ResetPassword(token string, newPassword string) derrors.Error {
+	resetToken, err := m.PasswordReset.Get(token)
+	if err != nil {
+		return err
+	}
+	credentials, err := m.CredentialsProvider.Get(resetToken.Username)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return derrors.NewUnauthenticatedError("invalid reset token")
+		}
+		return err
+	}
+	// The password is checked before consuming the token so the user can choose another one if it is rejected.
+	policy, err := m.checkNewPassword(credentials, newPassword)
+	if err != nil {
+		return err
+	}
+	err = m.PasswordReset.Consume(resetToken)
+	if err != nil {
+		return err
+	}
+	err = m.savePassword(policy, credentials, newPassword)
+	if err != nil {
+		return err
+	}
+	return m.Lockout.Unlock(credentials.Username)
+}
+
+// personalClaim builds the claim of a user with the current information of its role.
+// If the organization requires MFA for the user and it has not been used, the claim is restricted to the enrolment
+// of MFA, or rejected if the user has already enrolled it.
+func (m *Authx) personalClaim(credentials *entities.BasicCredentialsData, methods []string) (*token.PersonalClaim, derrors.Error) {
+	role, err := m.RoleProvider.Get(credentials.OrganizationID, credentials.RoleID)
 	if err != nil {
 		return nil, err
 	}
-	response := &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken}
+	claim := token.NewPersonalClaim(credentials.Username, role.Name, role.Primitives, credentials.OrganizationID)
+	claim.AuthenticationMethods = methods
+	if claim.HasAuthenticationMethod(token.MultiFactorAuthentication) {
+		return claim, nil
+	}
+	required, err := m.mfaRequired(credentials.OrganizationID, role.Primitives)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		if credentials.MFAEnabled {
+			return nil, derrors.NewUnauthenticatedError("MFA is required").WithParams(credentials.Username)
+		}
+		claim.Primitives = []string{token.MFAEnrolmentPrimitive}
+	}
+	return claim, nil
+}
+
+// RefreshToken renew an old token. The new token contains the current role of the user, so the refresh fails if the
+// user or the role no longer exist.
+func (m *Authx) RefreshToken(oldToken string, refreshToken string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	claim, err := m.Token.GetTokenInfo(oldToken, m.secret)
+	if err != nil {
+		return nil, err
+	}
+	if claim.IsServicePrincipal() {
+		return nil, derrors.NewUnauthenticatedError("the tokens of service accounts cannot be refreshed").
+			WithParams(claim.OrganizationID, claim.UserID)
+	}
+	if claim.TokenSource == token.PersonalAccessTokenSource {
+		personalClaim, accessToken, err := m.refreshAccessTokenClaim(claim)
+		if err != nil {
+			return nil, err
+		}
+		gToken, err := m.Token.Refresh(oldToken, refreshToken, personalClaim, m.accessTokenExpiration(accessToken), m.secret, client)
+		if err != nil {
+			return nil, err
+		}
+		return &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken}, nil
+	}
+	credentials, err := m.CredentialsProvider.Get(claim.UserID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("the user no longer exists", err).WithParams(claim.UserID)
+		}
+		return nil, err
+	}
+	personalClaim, err := m.personalClaim(credentials, claim.AuthenticationMethods)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("the role of the user no longer exists", err).
+				WithParams(claim.UserID, credentials.RoleID)
+		}
+		return nil, err
+	}
+	gToken, err := m.Token.Refresh(oldToken, refreshToken, personalClaim, m.expirationDuration, m.secret, client)
+	if err != nil {
+		return nil, err
+	}
+	response := &pbAuthx.LoginResponse{Token: gToken.Token, RefreshToken: gToken.RefreshToken,
+		MfaEnrolmentRequired: personalClaim.IsMFAEnrolment()}
 	return response, nil
 }
 
+// Logout revokes a token and its refresh token.
+func (m *Authx) Logout(token string) derrors.Error {
+	return m.Token.Revoke(token, m.secret)
+}
+
+// IsTokenRevoked checks if a token has been revoked.
+func (m *Authx) IsTokenRevoked(tokenID string) (bool, derrors.Error) {
+	return m.Token.IsRevoked(tokenID)
+}
+
+// ListSessions returns the active sessions of a user.
+func (m *Authx) ListSessions(username string) ([]entities.Session, derrors.Error) {
+	return m.Token.ListSessions(username)
+}
+
+// TerminateSession revokes the tokens of a session of a user.
+func (m *Authx) TerminateSession(username string, tokenID string) derrors.Error {
+	return m.Token.TerminateSession(username, tokenID)
+}
+
 // AddRole add a new role to the authorization system.
 func (m *Authx) AddRole(role *pbAuthx.Role) derrors.Error {
 	entity := entities.NewRoleData(role.OrganizationId, role.RoleId, role.Name, role.Internal, PrimitivesToString(role.Primitives))
@@ -187,7 +527,11 @@ func (m *Authx) EditUserRole(username string, roleID string) derrors.Error {
 	}
 	
 	edit := entities.NewEditBasicCredentialsData().WithRoleID(roleID)
-	return m.CredentialsProvider.Edit(username, edit)
+	err = m.CredentialsProvider.Edit(username, edit)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeAll(username)
 }
 
 func (m *Authx) ListRoles(organizationID *grpc_organization_go.OrganizationId) ([]entities.RoleData, derrors.Error) {
@@ -220,6 +564,22 @@ func (m *Authx) Clean() derrors.Error {
 	if err != nil {
 		return err
 	}
+	err = m.Lockout.Clean()
+	if err != nil {
+		return err
+	}
+	err = m.PasswordReset.Clean()
+	if err != nil {
+		return err
+	}
+	err = m.AccessTokens.Provider.Truncate()
+	if err != nil {
+		return err
+	}
+	err = m.ServiceAccountProvider.Truncate()
+	if err != nil {
+		return err
+	}
 	
 	return nil
 }
@@ -20,9 +20,13 @@ package manager
 import (
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
 	pbAuthx "github.com/nalej/grpc-authx-go"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 var _ = ginkgo.Describe("Authx", func() {
@@ -101,17 +105,70 @@ var _ = ginkgo.Describe("Authx", func() {
 		})
 
 		ginkgo.It("should login with correct password", func() {
-			response, err := manager.LoginWithBasicCredentials(userName, pass)
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(response).NotTo(gomega.BeNil())
 		})
 
 		ginkgo.It("should login with incorrect password", func() {
-			response, err := manager.LoginWithBasicCredentials(userName, pass+"wrong")
+			response, err := manager.LoginWithBasicCredentials(userName, pass+"wrong", nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(response).To(gomega.BeNil())
 		})
 
+		ginkgo.It("should reject a login with an unknown username", func() {
+			response, err := manager.LoginWithBasicCredentials(userName+"unknown", pass, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			gomega.Expect(response).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should throttle the logins after consecutive failures", func() {
+			for i := 0; i < 2; i++ {
+				_, err := manager.LoginWithBasicCredentials(userName, pass+"wrong", nil)
+				gomega.Expect(err).To(gomega.HaveOccurred())
+				gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			}
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+			gomega.Expect(response).To(gomega.BeNil())
+
+			err = manager.UnlockCredentials(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			response, err = manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(response).NotTo(gomega.BeNil())
+		})
+
+		ginkgo.It("should fail to unlock unknown credentials", func() {
+			err := manager.UnlockCredentials(userName + "unknown")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("should reject a new password that does not satisfy the policy", func() {
+			manager.PasswordPolicies.Organizations[organizationID] = PasswordPolicy{MinLength: 8, RequireDigit: true}
+			defer delete(manager.PasswordPolicies.Organizations, organizationID)
+
+			err := manager.ChangePassword(userName, pass, "short", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+			policyErr, ok := err.(*PasswordPolicyError)
+			gomega.Expect(ok).To(gomega.BeTrue())
+			gomega.Expect(violatedRules(policyErr.Violations)).To(gomega.ConsistOf(MinLengthRule, DigitRule))
+
+			err = manager.ChangePassword(userName, pass, "MyNewPassword1", nil)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+
+		ginkgo.It("should reject credentials with a password in the deny list", func() {
+			manager.PasswordPolicies.AddToDenyList("qwertyuiop")
+			err := manager.AddBasicCredentials("u2", organizationID, roleID, "qwertyuiop")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			_, ok := err.(*PasswordPolicyError)
+			gomega.Expect(ok).To(gomega.BeTrue())
+		})
+
 		ginkgo.It("should change to a valid roleID", func() {
 			err := manager.EditUserRole(userName, roleID2)
 			gomega.Expect(err).To(gomega.Succeed())
@@ -131,26 +188,38 @@ var _ = ginkgo.Describe("Authx", func() {
 		})
 		ginkgo.It("should change password with correct password", func() {
 			newPassword := pass + "New"
-			err := manager.ChangePassword(userName, pass, newPassword)
+			err := manager.ChangePassword(userName, pass, newPassword, nil)
 			gomega.Expect(err).To(gomega.Succeed())
-			response, err := manager.LoginWithBasicCredentials(userName, newPassword)
+			response, err := manager.LoginWithBasicCredentials(userName, newPassword, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(response).NotTo(gomega.BeNil())
 		})
 		ginkgo.It("should change password with correct incorrect password", func() {
 			newPassword := pass + "New"
-			err := manager.ChangePassword(userName, pass+"wrong", newPassword)
+			err := manager.ChangePassword(userName, pass+"wrong", newPassword, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("should throttle the password changes after consecutive failures", func() {
+			newPassword := pass + "New"
+			for i := 0; i < 2; i++ {
+				err := manager.ChangePassword(userName, pass+"wrong", newPassword, nil)
+				gomega.Expect(err).To(gomega.HaveOccurred())
+				gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			}
+			err := manager.ChangePassword(userName, pass, newPassword, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
 		})
 
 		ginkgo.It("should change password with correct incorrect username", func() {
 			newPassword := pass + "New"
-			err := manager.ChangePassword(userName+"wrong", pass, newPassword)
+			err := manager.ChangePassword(userName+"wrong", pass, newPassword, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 		})
 
 		ginkgo.It("should refresh token", func() {
-			response, err := manager.LoginWithBasicCredentials(userName, pass)
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(response).NotTo(gomega.BeNil())
 
@@ -164,14 +233,14 @@ var _ = ginkgo.Describe("Authx", func() {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken)
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(newResponse).NotTo(gomega.BeNil())
 
 		})
 
 		ginkgo.It("should reject invalid refresh token", func() {
-			response, err := manager.LoginWithBasicCredentials(userName, pass)
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(response).NotTo(gomega.BeNil())
 
@@ -185,10 +254,195 @@ var _ = ginkgo.Describe("Authx", func() {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken+"wrong")
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken+"wrong", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(newResponse).To(gomega.BeNil())
+
+		})
+
+		ginkgo.It("should revoke a token on logout", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			tk, jwtErr := jwt.ParseWithClaims(response.Token, &token.Claim{}, func(token *jwt.Token) (interface{}, error) {
+				return []byte(DefaultSecret), nil
+			})
+			gomega.Expect(jwtErr).To(gomega.Succeed())
+			cl := tk.Claims.(*token.Claim)
+
+			revoked, err := manager.IsTokenRevoked(cl.Id)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(revoked).To(gomega.BeFalse())
+
+			err = manager.Logout(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			revoked, err = manager.IsTokenRevoked(cl.Id)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(revoked).To(gomega.BeTrue())
+
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(newResponse).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should refresh the token with the current role", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			// Change the role without revoking the sessions.
+			err = manager.CredentialsProvider.Edit(userName, entities.NewEditBasicCredentialsData().WithRoleID(roleID2))
+			gomega.Expect(err).To(gomega.Succeed())
+
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			claim, err := manager.Token.GetTokenInfo(newResponse.Token, DefaultSecret)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(claim.RoleName).To(gomega.Equal("rName2"))
+		})
+
+		ginkgo.It("should reject the refresh of a deleted user", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			// Delete the credentials without revoking the sessions.
+			err = manager.CredentialsProvider.Delete(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(newResponse).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should revoke the sessions when the role changes", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = manager.EditUserRole(userName, roleID2)
+			gomega.Expect(err).To(gomega.Succeed())
+			expectRevoked(manager, response)
+		})
+
+		ginkgo.It("should revoke the sessions when the password changes", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = manager.ChangePassword(userName, pass, pass+"New", nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			expectRevoked(manager, response)
+		})
+
+		ginkgo.It("should revoke the sessions when the credentials are deleted", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			err = manager.DeleteCredentials(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			expectRevoked(manager, response)
+		})
+
+		ginkgo.It("should reject logout with an invalid token", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
 
+			err = manager.Logout(response.Token + "wrong")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("should list the sessions of a user", func() {
+			client := entities.NewClientInfo("10.0.0.1", "test-agent")
+			response, err := manager.LoginWithBasicCredentials(userName, pass, client)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(newResponse).NotTo(gomega.BeNil())
+
+			sessions, err := manager.ListSessions(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(sessions).To(gomega.HaveLen(2))
+			found := false
+			for _, s := range sessions {
+				if s.ClientIP == client.IP {
+					found = true
+					gomega.Expect(s.UserAgent).To(gomega.Equal(client.UserAgent))
+					gomega.Expect(s.IssuedAt).NotTo(gomega.BeZero())
+					gomega.Expect(s.LastRefresh).NotTo(gomega.BeZero())
+				}
+			}
+			gomega.Expect(found).To(gomega.BeTrue())
+		})
+
+		ginkgo.It("should terminate a session", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			other, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			claim, err := manager.Token.GetTokenInfo(response.Token, DefaultSecret)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.TerminateSession(userName, claim.Id)
+			gomega.Expect(err).To(gomega.Succeed())
+			expectRevoked(manager, response)
+
+			sessions, err := manager.ListSessions(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(sessions).To(gomega.HaveLen(1))
+
+			newResponse, err := manager.RefreshToken(other.Token, other.RefreshToken, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(newResponse).NotTo(gomega.BeNil())
+		})
+
+		ginkgo.It("should fail to terminate an unknown session", func() {
+			err := manager.TerminateSession(userName, "unknown")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+
+		ginkgo.It("should introspect an active token", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeTrue())
+			gomega.Expect(result.Revoked).To(gomega.BeFalse())
+			gomega.Expect(result.TokenType).To(gomega.Equal(entities.UserTokenType))
+			gomega.Expect(result.UserID).To(gomega.Equal(userName))
+			gomega.Expect(result.OrganizationID).To(gomega.Equal(organizationID))
+			gomega.Expect(result.RoleName).To(gomega.Equal("rName1"))
+			gomega.Expect(result.ExpiresAt).To(gomega.BeNumerically(">", result.IssuedAt))
+			gomega.Expect(result.PrincipalType).To(gomega.Equal(token.UserPrincipal))
+			gomega.Expect(result.AuthenticationMethods).To(gomega.ContainElement(token.PasswordAuthentication))
+		})
+
+		ginkgo.It("should introspect a revoked token", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.Logout(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
+			gomega.Expect(result.Revoked).To(gomega.BeTrue())
+			gomega.Expect(result.UserID).To(gomega.Equal(userName))
+		})
+
+		ginkgo.It("should introspect an invalid token as inactive", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token + "wrong")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
+			gomega.Expect(result.UserID).To(gomega.BeEmpty())
+
+			result, err = manager.Introspect("garbage")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
 		})
 		ginkgo.AfterEach(func() {
 			err := manager.Clean()
@@ -196,4 +450,267 @@ var _ = ginkgo.Describe("Authx", func() {
 		})
 	})
 
+	ginkgo.Context("with a device", func() {
+		organizationID := "o1"
+		deviceGroupID := "g1"
+		deviceID := "d1"
+
+		var deviceApiKey string
+
+		ginkgo.BeforeEach(func() {
+			_, err := manager.AddDeviceGroupCredentials(&pbAuthx.AddDeviceGroupCredentialsRequest{
+				OrganizationId:            organizationID,
+				DeviceGroupId:             deviceGroupID,
+				Enabled:                   true,
+				DefaultDeviceConnectivity: true,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			credentials, err := manager.AddDeviceCredentials(&pbAuthx.AddDeviceCredentialsRequest{
+				OrganizationId: organizationID,
+				DeviceGroupId:  deviceGroupID,
+				DeviceId:       deviceID,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			deviceApiKey = credentials.DeviceApiKey
+		})
+
+		ginkgo.It("should introspect an active device token", func() {
+			response, err := manager.LoginDeviceCredentials(&pbAuthx.DeviceLoginRequest{
+				OrganizationId: organizationID,
+				DeviceApiKey:   deviceApiKey,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeTrue())
+			gomega.Expect(result.TokenType).To(gomega.Equal(entities.DeviceTokenType))
+			gomega.Expect(result.DeviceGroupID).To(gomega.Equal(deviceGroupID))
+			gomega.Expect(result.DeviceID).To(gomega.Equal(deviceID))
+		})
+
+		ginkgo.It("should introspect a device token of a removed group as inactive", func() {
+			response, err := manager.LoginDeviceCredentials(&pbAuthx.DeviceLoginRequest{
+				OrganizationId: organizationID,
+				DeviceApiKey:   deviceApiKey,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.DeviceProvider.Truncate()
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should introspect a token of a disabled device as inactive", func() {
+			response, err := manager.LoginDeviceCredentials(&pbAuthx.DeviceLoginRequest{
+				OrganizationId: organizationID,
+				DeviceApiKey:   deviceApiKey,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.UpdateDeviceCredentials(&pbAuthx.UpdateDeviceCredentialsRequest{
+				OrganizationId: organizationID,
+				DeviceGroupId:  deviceGroupID,
+				DeviceId:       deviceID,
+				Enabled:        false,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should introspect a removed device token as inactive", func() {
+			response, err := manager.LoginDeviceCredentials(&pbAuthx.DeviceLoginRequest{
+				OrganizationId: organizationID,
+				DeviceApiKey:   deviceApiKey,
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.DeviceTokenProvider.Truncate()
+			gomega.Expect(err).To(gomega.Succeed())
+
+			result, err := manager.Introspect(response.Token)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.Active).To(gomega.BeFalse())
+		})
+
+		ginkgo.AfterEach(func() {
+			err := manager.Clean()
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+	})
+
+})
+
+var _ = ginkgo.Describe("Authx password migration", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+
+	ginkgo.BeforeEach(func() {
+		manager.Password = NewBCryptPasswordWithCost(bcrypt.MinCost)
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should rehash an outdated password on login", func() {
+		manager.Password = NewArgon2idPassword(testArgon2idParams)
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.Password.NeedsRehash(credentials.Password)).To(gomega.BeFalse())
+
+		response, err = manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should not rehash a password after a failed login", func() {
+		manager.Password = NewArgon2idPassword(testArgon2idParams)
+		_, err := manager.LoginWithBasicCredentials(userName, pass+"wrong", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.Password.NeedsRehash(credentials.Password)).To(gomega.BeTrue())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
 })
+
+var _ = ginkgo.Describe("Authx password history and expiration", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject the last passwords of the history", func() {
+		manager.PasswordPolicies.Organizations[organizationID] = PasswordPolicy{MinLength: 8, HistorySize: 3}
+		defer delete(manager.PasswordPolicies.Organizations, organizationID)
+
+		err := manager.ChangePassword(userName, pass, pass, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		policyErr, ok := err.(*PasswordPolicyError)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(violatedRules(policyErr.Violations)).To(gomega.ConsistOf(HistoryRule))
+
+		passwords := []string{pass, pass + "2", pass + "3", pass + "4"}
+		for i := 1; i < len(passwords); i++ {
+			err = manager.ChangePassword(userName, passwords[i-1], passwords[i], nil)
+			gomega.Expect(err).To(gomega.Succeed())
+		}
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(credentials.PasswordHistory).To(gomega.HaveLen(2))
+
+		for _, reused := range passwords[1:] {
+			err = manager.ChangePassword(userName, passwords[3], reused, nil)
+			_, ok = err.(*PasswordPolicyError)
+			gomega.Expect(ok).To(gomega.BeTrue())
+		}
+		err = manager.ChangePassword(userName, passwords[3], passwords[0], nil)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not keep the history if the policy does not require it", func() {
+		err := manager.ChangePassword(userName, pass, pass+"New", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(credentials.PasswordHistory).To(gomega.BeEmpty())
+		err = manager.ChangePassword(userName, pass+"New", pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reject the login with an expired password until it is changed", func() {
+		manager.PasswordPolicies.Organizations[organizationID] = PasswordPolicy{MinLength: 8, MaxAgeDays: 90}
+		defer delete(manager.PasswordPolicies.Organizations, organizationID)
+
+		changedAt := time.Now().Add(-91 * 24 * time.Hour).Unix()
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		credentials.PasswordChangedAt = changedAt
+		err = manager.CredentialsProvider.Add(credentials)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(response).To(gomega.BeNil())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.FailedPrecondition))
+		_, ok := err.(*PasswordExpiredError)
+		gomega.Expect(ok).To(gomega.BeTrue())
+
+		err = manager.ChangePassword(userName, pass, pass+"New", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err = manager.LoginWithBasicCredentials(userName, pass+"New", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should not report an expired password with wrong credentials", func() {
+		manager.PasswordPolicies.Organizations[organizationID] = PasswordPolicy{MinLength: 8, MaxAgeDays: 90}
+		defer delete(manager.PasswordPolicies.Organizations, organizationID)
+
+		credentials, err := manager.CredentialsProvider.Get(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		credentials.PasswordChangedAt = time.Now().Add(-91 * 24 * time.Hour).Unix()
+		err = manager.CredentialsProvider.Add(credentials)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = manager.LoginWithBasicCredentials(userName, pass+"wrong", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+// expectRevoked checks that a token has been revoked and cannot be refreshed.
+func expectRevoked(manager *Authx, response *pbAuthx.LoginResponse) {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	tk, jwtErr := parser.ParseWithClaims(response.Token, &token.Claim{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(DefaultSecret), nil
+	})
+	gomega.Expect(jwtErr).To(gomega.Succeed())
+
+	revoked, err := manager.IsTokenRevoked(tk.Claims.(*token.Claim).Id)
+	gomega.Expect(err).To(gomega.Succeed())
+	gomega.Expect(revoked).To(gomega.BeTrue())
+
+	newResponse, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+	gomega.Expect(err).To(gomega.HaveOccurred())
+	gomega.Expect(newResponse).To(gomega.BeNil())
+}
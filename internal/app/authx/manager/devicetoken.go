@@ -66,8 +66,7 @@ func (m *JWTDeviceToken) Generate(deviceClaim *token.DeviceClaim, expirationPeri
 	
 	newClaim := token.NewDeviceClaim(deviceClaim.OrganizationID, deviceClaim.DeviceGroupID, deviceClaim.DeviceID, expirationPeriod)
 	
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, newClaim)
-	tokenString, err := t.SignedString([]byte(secret))
+	tokenString, err := token.NewHMACSigningKey("", secret).Sign(newClaim)
 	if err != nil {
 		return nil, derrors.NewInternalError("impossible generate JWT Device token", err)
 	}
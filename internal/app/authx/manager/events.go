@@ -0,0 +1,71 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/rs/zerolog/log"
+	"time"
+)
+
+// SecurityEventType identifies the kind of security event.
+type SecurityEventType string
+
+// RefreshTokenReuse is raised when a refresh token that has already been consumed is presented again.
+const RefreshTokenReuse SecurityEventType = "RefreshTokenReuse"
+
+// SecurityEvent describes a suspicious activity detected by authx.
+type SecurityEvent struct {
+	Type     SecurityEventType
+	Username string
+	// FamilyID is the refresh token family affected by the event.
+	FamilyID string
+	// TokenID is the identifier of the token that triggered the event.
+	TokenID   string
+	Timestamp int64
+}
+
+// NewSecurityEvent creates a new event with the current timestamp.
+func NewSecurityEvent(eventType SecurityEventType, username string, familyID string, tokenID string) *SecurityEvent {
+	return &SecurityEvent{
+		Type:      eventType,
+		Username:  username,
+		FamilyID:  familyID,
+		TokenID:   tokenID,
+		Timestamp: time.Now().Unix(),
+	}
+}
+
+// SecurityEventListener receives the security events.
+type SecurityEventListener interface {
+	// Notify an event.
+	Notify(event *SecurityEvent)
+}
+
+// LogSecurityEventListener writes the security events in the log.
+type LogSecurityEventListener struct{}
+
+// NewLogSecurityEventListener creates a new listener.
+func NewLogSecurityEventListener() SecurityEventListener {
+	return &LogSecurityEventListener{}
+}
+
+// Notify an event.
+func (l *LogSecurityEventListener) Notify(event *SecurityEvent) {
+	log.Warn().Str("type", string(event.Type)).Str("username", event.Username).
+		Str("familyID", event.FamilyID).Str("tokenID", event.TokenID).
+		Int64("timestamp", event.Timestamp).Msg("security event")
+}
@@ -0,0 +1,135 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"strings"
+	"time"
+)
+
+// DefaultExchangeExpiration is the expiration of the exchanged tokens if the request does not set it.
+const DefaultExchangeExpiration = 5 * time.Minute
+
+// MaxExchangeExpiration is the maximum expiration of the exchanged tokens.
+const MaxExchangeExpiration = 15 * time.Minute
+
+// validTokenClaim returns the claim of a valid user token that has not been revoked.
+func (m *Authx) validTokenClaim(tokenString string) (*token.Claim, derrors.Error) {
+	claim, err := m.Token.GetTokenInfo(tokenString, m.secret)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := m.Token.IsRevoked(claim.Id)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, derrors.NewUnauthenticatedError("the token has been revoked").WithParams(claim.Id)
+	}
+	return claim, nil
+}
+
+// AudienceSeparator separates the audience of the environment from the audience of a backend. A token limited to
+// "production" can be exchanged for a token limited to "production/billing", which is only accepted by the services
+// that include that audience in their configuration.
+const AudienceSeparator = "/"
+
+// narrowsAudience checks if an audience is the audience of a token or one of its backends. An empty audience keeps
+// the audience of the token.
+func narrowsAudience(audience string, tokenAudience string) bool {
+	if audience == "" || tokenAudience == "" || audience == tokenAudience {
+		return true
+	}
+	return strings.HasPrefix(audience, tokenAudience+AudienceSeparator) &&
+		len(audience) > len(tokenAudience)+len(AudienceSeparator)
+}
+
+// ExchangeToken returns a token derived from a valid token, as defined in RFC 8693. The new token is limited to a
+// subset of the primitives of the subject token, can be limited to an audience and expires before the subject token.
+// If the subject token has an audience, the new token can only be limited to that audience or to the audience of one
+// of its backends. If an actor token is given, the new token identifies its principal as the actor. Only the service
+// accounts of the organization of the subject can act on its behalf, and the new token is also limited to the
+// primitives of the actor. The exchanged tokens cannot be refreshed and belong to the session of the subject token,
+// so they are revoked with it.
+func (m *Authx) ExchangeToken(subjectToken string, actorToken string, primitives []string, audience string,
+	expiration time.Duration) (*pbAuthx.LoginResponse, derrors.Error) {
+	if expiration < 0 || expiration > MaxExchangeExpiration {
+		return nil, derrors.NewInvalidArgumentError("invalid expiration of the exchanged token").
+			WithParams(expiration.String(), MaxExchangeExpiration.String())
+	}
+	if expiration == 0 {
+		expiration = DefaultExchangeExpiration
+	}
+	subject, err := m.validTokenClaim(subjectToken)
+	if err != nil {
+		return nil, err
+	}
+	if subject.IsMFAEnrolment() {
+		return nil, derrors.NewUnauthenticatedError("MFA enrolment is required").WithParams(subject.UserID)
+	}
+	for _, p := range primitives {
+		if !containsPrimitive(subject.Primitives, p) {
+			return nil, derrors.NewInvalidArgumentError("the subject token does not include the primitive").
+				WithParams(subject.UserID, p)
+		}
+	}
+	personalClaim := subject.PersonalClaim
+	personalClaim.Primitives = primitives
+	if actorToken != "" {
+		actor, err := m.validTokenClaim(actorToken)
+		if err != nil {
+			return nil, err
+		}
+		if !actor.IsServicePrincipal() || actor.OrganizationID != subject.OrganizationID {
+			return nil, derrors.NewPermissionDeniedError("the actor cannot act on behalf of the subject").
+				WithParams(actor.UserID, subject.UserID)
+		}
+		for _, p := range primitives {
+			if !containsPrimitive(actor.Primitives, p) {
+				return nil, derrors.NewPermissionDeniedError("the actor token does not include the primitive").
+					WithParams(actor.UserID, p)
+			}
+		}
+		personalClaim.Actor = &token.Actor{
+			Subject:       actor.UserID,
+			PrincipalType: actor.GetPrincipalType(),
+			Actor:         subject.Actor,
+		}
+	}
+	now := time.Now()
+	remaining := time.Unix(subject.ExpiresAt, 0).Sub(now)
+	if remaining < expiration {
+		expiration = remaining
+	}
+	if !narrowsAudience(audience, subject.Audience) {
+		return nil, derrors.NewInvalidArgumentError("the audience is not included in the audience of the subject token").
+			WithParams(subject.UserID, audience, subject.Audience)
+	}
+	claim := token.NewClaim(personalClaim, Issuer, now, expiration)
+	claim.Audience = audience
+	if audience == "" {
+		claim.Audience = subject.Audience
+	}
+	signed, err := m.Token.SignInSession(claim, subject.Id, m.secret)
+	if err != nil {
+		return nil, err
+	}
+	return &pbAuthx.LoginResponse{Token: signed}, nil
+}
@@ -0,0 +1,225 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("Token exchange", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+	apps := pbAuthx.AccessPrimitive_APPS.String()
+	var userToken string
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG, pbAuthx.AccessPrimitive_APPS},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		userToken = response.Token
+	})
+
+	ginkgo.It("should down-scope a token", func() {
+		response, err := manager.ExchangeToken(userToken, "", []string{apps}, "backend", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.RefreshToken).To(gomega.BeEmpty())
+		claim := tokenClaim(response.Token)
+		gomega.Expect(claim.UserID).To(gomega.Equal(userName))
+		gomega.Expect(claim.Primitives).To(gomega.Equal([]string{apps}))
+		gomega.Expect(claim.Audience).To(gomega.Equal("backend"))
+		gomega.Expect(claim.Actor).To(gomega.BeNil())
+		gomega.Expect(claim.AuthenticationMethods).To(gomega.Equal([]string{token.PasswordAuthentication}))
+		gomega.Expect(claim.ExpiresAt - claim.IssuedAt).To(gomega.Equal(int64(DefaultExchangeExpiration.Seconds())))
+		gomega.Expect(claim.Id).NotTo(gomega.Equal(tokenClaim(userToken).Id))
+	})
+
+	ginkgo.It("should limit the audience to the audience of the subject token", func() {
+		jwtToken := manager.Token.(*JWTToken)
+		jwtToken.Audience = "production"
+		defer func() { jwtToken.Audience = "" }()
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		for _, audience := range []string{"backend", "productionbackend", "production/", "staging/backend"} {
+			_, err = manager.ExchangeToken(response.Token, "", []string{apps}, audience, 0)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+		}
+
+		exchanged, err := manager.ExchangeToken(response.Token, "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(exchanged.Token).Audience).To(gomega.Equal("production"))
+		exchanged, err = manager.ExchangeToken(response.Token, "", []string{apps}, "production", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(exchanged.Token).Audience).To(gomega.Equal("production"))
+
+		// a token of the environment can be narrowed to a backend, but not widened again
+		exchanged, err = manager.ExchangeToken(response.Token, "", []string{apps}, "production/backend", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(exchanged.Token).Audience).To(gomega.Equal("production/backend"))
+		_, err = manager.ExchangeToken(exchanged.Token, "", []string{apps}, "production", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+	})
+
+	ginkgo.It("should include the actor", func() {
+		account, secret, err := manager.AddServiceAccount(organizationID, "gateway", roleID, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		actor, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		response, err := manager.ExchangeToken(userToken, actor.Token, []string{apps}, "", time.Minute)
+		gomega.Expect(err).To(gomega.Succeed())
+		claim := tokenClaim(response.Token)
+		gomega.Expect(claim.Actor).To(gomega.Equal(&token.Actor{Subject: account.ServiceAccountID,
+			PrincipalType: token.ServicePrincipal}))
+
+		// the actors are chained when an exchanged token is exchanged again
+		other, otherSecret, err := manager.AddServiceAccount(organizationID, "backend", roleID, "")
+		gomega.Expect(err).To(gomega.Succeed())
+		otherActor, err := manager.LoginWithClientCredentials(organizationID, other.ServiceAccountID, otherSecret, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		chained, err := manager.ExchangeToken(response.Token, otherActor.Token, []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		chainedClaim := tokenClaim(chained.Token)
+		gomega.Expect(chainedClaim.Actor.Subject).To(gomega.Equal(other.ServiceAccountID))
+		gomega.Expect(chainedClaim.Actor.Actor).To(gomega.Equal(claim.Actor))
+		gomega.Expect(chainedClaim.ExpiresAt).To(gomega.BeNumerically("<=", claim.ExpiresAt))
+	})
+
+	ginkgo.It("should only accept the service accounts of the organization as actors", func() {
+		// a user cannot act on behalf of another user
+		_, err := manager.ExchangeToken(userToken, userToken, []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.PermissionDenied))
+
+		err = manager.AddRole(&pbAuthx.Role{
+			OrganizationId: "o2",
+			RoleId:         "r2",
+			Name:           "rName2",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_APPS},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		account, secret, err := manager.AddServiceAccount("o2", "gateway", "r2", "")
+		gomega.Expect(err).To(gomega.Succeed())
+		actor, err := manager.LoginWithClientCredentials("o2", account.ServiceAccountID, secret, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.ExchangeToken(userToken, actor.Token, []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.PermissionDenied))
+	})
+
+	ginkgo.It("should not grant primitives that are not included in the actor token", func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         "r2",
+			Name:           "rName2",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		account, secret, err := manager.AddServiceAccount(organizationID, "gateway", "r2", "")
+		gomega.Expect(err).To(gomega.Succeed())
+		actor, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.ExchangeToken(userToken, actor.Token, []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.PermissionDenied))
+	})
+
+	ginkgo.It("should revoke the exchanged tokens with the session of the subject token", func() {
+		response, err := manager.ExchangeToken(userToken, "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		sessions, err := manager.ListSessions(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(sessions).To(gomega.HaveLen(1))
+
+		err = manager.Logout(userToken)
+		gomega.Expect(err).To(gomega.Succeed())
+		revoked, err := manager.Token.IsRevoked(tokenClaim(response.Token).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should revoke the exchanged tokens when the password changes", func() {
+		response, err := manager.ExchangeToken(userToken, "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.ChangePassword(userName, pass, "MyNewLittlePassword", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		revoked, err := manager.Token.IsRevoked(tokenClaim(response.Token).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should not refresh the exchanged tokens", func() {
+		login, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.ExchangeToken(login.Token, "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.RefreshToken(response.Token, login.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+
+		// the session of the subject token is not affected
+		_, err = manager.RefreshToken(login.Token, login.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not grant primitives that are not included in the token", func() {
+		_, err := manager.ExchangeToken(userToken, "", []string{pbAuthx.AccessPrimitive_ORG_MNGT.String()}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+	})
+
+	ginkgo.It("should limit the expiration", func() {
+		_, err := manager.ExchangeToken(userToken, "", []string{apps}, "", MaxExchangeExpiration+time.Minute)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+	})
+
+	ginkgo.It("should reject revoked and invalid tokens", func() {
+		_, err := manager.ExchangeToken("invalid", "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+
+		err = manager.Logout(userToken)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.ExchangeToken(userToken, "", []string{apps}, "", 0)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Introspect returns the state of a user or device token. A token that cannot be verified is reported as inactive,
+// errors are only returned if the state cannot be determined.
+func (m *Authx) Introspect(tokenString string) (*entities.TokenIntrospection, derrors.Error) {
+	deviceClaim := &token.DeviceClaim{}
+	_, _, jwtErr := new(jwt.Parser).ParseUnverified(tokenString, deviceClaim)
+	if jwtErr != nil {
+		return entities.NewInactiveTokenIntrospection(), nil
+	}
+	if deviceClaim.DeviceGroupID != "" {
+		return m.introspectDeviceToken(tokenString, deviceClaim)
+	}
+	return m.introspectUserToken(tokenString)
+}
+
+func (m *Authx) introspectUserToken(tokenString string) (*entities.TokenIntrospection, derrors.Error) {
+	claim, err := m.Token.GetTokenInfo(tokenString, m.secret)
+	if err != nil {
+		return entities.NewInactiveTokenIntrospection(), nil
+	}
+	revoked, err := m.Token.IsRevoked(claim.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &entities.TokenIntrospection{
+		Active:                !revoked,
+		Revoked:               revoked,
+		TokenType:             entities.UserTokenType,
+		TokenID:               claim.Id,
+		Issuer:                claim.Issuer,
+		IssuedAt:              claim.IssuedAt,
+		ExpiresAt:             claim.ExpiresAt,
+		UserID:                claim.UserID,
+		OrganizationID:        claim.OrganizationID,
+		RoleName:              claim.RoleName,
+		Primitives:            claim.Primitives,
+		Audience:              claim.Audience,
+		PrincipalType:         claim.GetPrincipalType(),
+		AuthenticationMethods: claim.AuthenticationMethods,
+		TokenSource:           claim.TokenSource,
+		TokenSourceID:         claim.TokenSourceID,
+		Actor:                 claim.Actor,
+	}, nil
+}
+
+// introspectDeviceToken verifies a device token with the secret of the group found in the unverified claim.
+func (m *Authx) introspectDeviceToken(tokenString string, unverified *token.DeviceClaim) (*entities.TokenIntrospection, derrors.Error) {
+	group, err := m.DeviceProvider.GetDeviceGroup(unverified.OrganizationID, unverified.DeviceGroupID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return entities.NewInactiveTokenIntrospection(), nil
+		}
+		return nil, err
+	}
+	claim, err := m.DeviceToken.GetTokenInfo(tokenString, group.Secret)
+	if err != nil {
+		return entities.NewInactiveTokenIntrospection(), nil
+	}
+	active := group.Enabled
+	if active {
+		active, err = m.isDeviceActive(claim)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &entities.TokenIntrospection{
+		Active:         active,
+		TokenType:      entities.DeviceTokenType,
+		TokenID:        claim.Id,
+		Issuer:         claim.Issuer,
+		IssuedAt:       claim.IssuedAt,
+		ExpiresAt:      claim.ExpiresAt,
+		OrganizationID: claim.OrganizationID,
+		Primitives:     claim.Primitives,
+		DeviceGroupID:  claim.DeviceGroupID,
+		DeviceID:       claim.DeviceID,
+		Audience:       claim.Audience,
+		PrincipalType:  token.DevicePrincipal,
+	}, nil
+}
+
+// isDeviceActive checks that the device of a token is enabled and that the token has not been removed.
+func (m *Authx) isDeviceActive(claim *token.DeviceClaim) (bool, derrors.Error) {
+	device, err := m.DeviceProvider.GetDevice(claim.OrganizationID, claim.DeviceGroupID, claim.DeviceID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	if !device.Enabled {
+		return false, nil
+	}
+	exists, err := m.DeviceTokenProvider.Exist(claim.DeviceID, claim.Id)
+	if err != nil {
+		return false, err
+	}
+	return *exists, nil
+}
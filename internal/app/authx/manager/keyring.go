@@ -0,0 +1,264 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"sync"
+	"time"
+)
+
+// KeyRing contains the keys used to sign and verify the user tokens. Only the active key signs new tokens, the
+// previous keys are still accepted for verification until they retire.
+type KeyRing struct {
+	sync.RWMutex
+	keys        map[string]*RingKey
+	activeKeyID string
+}
+
+// RingKey is a signing key with its retirement date.
+type RingKey struct {
+	Key *token.SigningKey
+	// RetireAt is the unix time after which the key is no longer valid. Zero means the key does not retire.
+	RetireAt int64
+}
+
+// NewKeyRing creates an empty key ring.
+func NewKeyRing() *KeyRing {
+	return &KeyRing{keys: make(map[string]*RingKey, 0)}
+}
+
+// NewKeyRingWithKey creates a key ring with a single active key.
+func NewKeyRingWithKey(key *token.SigningKey) *KeyRing {
+	ring := NewKeyRing()
+	ring.keys[key.KeyID] = &RingKey{Key: key}
+	ring.activeKeyID = key.KeyID
+	return ring
+}
+
+// Add a new key to the ring without activating it.
+func (k *KeyRing) Add(key *token.SigningKey, retireAt int64) derrors.Error {
+	k.Lock()
+	defer k.Unlock()
+	if _, exists := k.keys[key.KeyID]; exists {
+		return derrors.NewAlreadyExistsError("signing key").WithParams(key.KeyID)
+	}
+	k.keys[key.KeyID] = &RingKey{Key: key, RetireAt: retireAt}
+	return nil
+}
+
+// Promote sets a key as the active one. The previous active key retires after the overlap period so the tokens
+// signed with it are still accepted until they expire.
+func (k *KeyRing) Promote(keyID string, overlap time.Duration) derrors.Error {
+	k.Lock()
+	defer k.Unlock()
+	promoted, exists := k.keys[keyID]
+	if !exists {
+		return derrors.NewNotFoundError("signing key").WithParams(keyID)
+	}
+	if previous, ok := k.keys[k.activeKeyID]; ok && k.activeKeyID != keyID {
+		previous.RetireAt = time.Now().Add(overlap).Unix()
+	}
+	promoted.RetireAt = 0
+	k.activeKeyID = keyID
+	return nil
+}
+
+// Retire removes a key from the ring. The active key cannot be retired.
+func (k *KeyRing) Retire(keyID string) derrors.Error {
+	k.Lock()
+	defer k.Unlock()
+	if keyID == k.activeKeyID {
+		return derrors.NewFailedPreconditionError("the active signing key cannot be retired").WithParams(keyID)
+	}
+	if _, exists := k.keys[keyID]; !exists {
+		return derrors.NewNotFoundError("signing key").WithParams(keyID)
+	}
+	delete(k.keys, keyID)
+	return nil
+}
+
+// ActiveKey returns the key that signs new tokens.
+func (k *KeyRing) ActiveKey() (*token.SigningKey, derrors.Error) {
+	k.RLock()
+	defer k.RUnlock()
+	active, exists := k.keys[k.activeKeyID]
+	if !exists {
+		return nil, derrors.NewFailedPreconditionError("there is no active signing key")
+	}
+	return active.Key, nil
+}
+
+// VerificationKey returns the key to verify a token signed with a given kid and algorithm.
+func (k *KeyRing) VerificationKey(keyID string, algorithm string) (interface{}, derrors.Error) {
+	k.RLock()
+	defer k.RUnlock()
+	ringKey, exists := k.keys[keyID]
+	if !exists || ringKey.retired(time.Now()) {
+		return nil, derrors.NewUnauthenticatedError("unknown signing key").WithParams(keyID)
+	}
+	if ringKey.Key.Method.Alg() != algorithm {
+		return nil, derrors.NewUnauthenticatedError("unexpected signing method").WithParams(keyID, algorithm)
+	}
+	return ringKey.Key.VerificationKey(), nil
+}
+
+// KeySet returns the public keys that are not retired.
+func (k *KeyRing) KeySet() *token.JSONWebKeySet {
+	k.RLock()
+	defer k.RUnlock()
+	now := time.Now()
+	keys := make([]*token.SigningKey, 0, len(k.keys))
+	for _, ringKey := range k.keys {
+		if !ringKey.retired(now) {
+			keys = append(keys, ringKey.Key)
+		}
+	}
+	return token.NewJSONWebKeySet(keys...)
+}
+
+// Replace updates the content of the ring with the one of another ring.
+func (k *KeyRing) Replace(other *KeyRing) {
+	other.RLock()
+	defer other.RUnlock()
+	k.Lock()
+	defer k.Unlock()
+	k.keys = other.keys
+	k.activeKeyID = other.activeKeyID
+}
+
+func (r *RingKey) retired(now time.Time) bool {
+	return r.RetireAt != 0 && now.Unix() > r.RetireAt
+}
+
+// KeyRingDescriptor is the file that describes the keys of a key ring.
+type KeyRingDescriptor struct {
+	// Active is the kid of the active key.
+	Active string `json:"active"`
+	// Keys is the list of keys in the ring.
+	Keys []KeyDescriptor `json:"keys"`
+}
+
+// KeyDescriptor points to the file of a key. Asymmetric keys are PEM private keys and HS256 keys are files
+// with the shared secret.
+type KeyDescriptor struct {
+	KeyID     string `json:"kid"`
+	Path      string `json:"path"`
+	Algorithm string `json:"alg,omitempty"`
+	RetireAt  int64  `json:"retire_at,omitempty"`
+}
+
+// Load reads the key pointed by the descriptor.
+func (kd *KeyDescriptor) Load() (*token.SigningKey, derrors.Error) {
+	if kd.Algorithm != jwt.SigningMethodHS256.Alg() {
+		return token.LoadSigningKey(kd.KeyID, kd.Path)
+	}
+	content, err := ioutil.ReadFile(kd.Path)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible read secret file", err).WithParams(kd.Path)
+	}
+	return token.NewHMACSigningKey(kd.KeyID, string(content)), nil
+}
+
+// LoadKeyRingDescriptor reads a key ring descriptor.
+func LoadKeyRingDescriptor(path string) (*KeyRingDescriptor, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible read key ring file", err).WithParams(path)
+	}
+	descriptor := &KeyRingDescriptor{}
+	err = json.Unmarshal(content, descriptor)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible unmarshal key ring file", err).WithParams(path)
+	}
+	return descriptor, nil
+}
+
+// Save writes the descriptor into a file.
+func (d *KeyRingDescriptor) Save(path string) derrors.Error {
+	content, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return derrors.NewInternalError("impossible marshal key ring", err)
+	}
+	err = ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		return derrors.NewInternalError("impossible write key ring file", err).WithParams(path)
+	}
+	return nil
+}
+
+// Promote adds a key to the descriptor, makes it the active one and schedules the retirement of the previous one.
+func (d *KeyRingDescriptor) Promote(key KeyDescriptor, overlap time.Duration) {
+	retireAt := time.Now().Add(overlap).Unix()
+	found := false
+	for i := range d.Keys {
+		if d.Keys[i].KeyID == key.KeyID {
+			found = true
+			d.Keys[i].RetireAt = 0
+			if key.Path != "" {
+				d.Keys[i].Path = key.Path
+				d.Keys[i].Algorithm = key.Algorithm
+			}
+		} else if d.Keys[i].KeyID == d.Active {
+			d.Keys[i].RetireAt = retireAt
+		}
+	}
+	if !found {
+		key.RetireAt = 0
+		d.Keys = append(d.Keys, key)
+	}
+	d.Active = key.KeyID
+}
+
+// Retire removes a key from the descriptor.
+func (d *KeyRingDescriptor) Retire(keyID string) derrors.Error {
+	if keyID == d.Active {
+		return derrors.NewFailedPreconditionError("the active signing key cannot be retired").WithParams(keyID)
+	}
+	for i := range d.Keys {
+		if d.Keys[i].KeyID == keyID {
+			d.Keys = append(d.Keys[:i], d.Keys[i+1:]...)
+			return nil
+		}
+	}
+	return derrors.NewNotFoundError("signing key").WithParams(keyID)
+}
+
+// Build loads the keys of the descriptor into a new key ring.
+func (d *KeyRingDescriptor) Build() (*KeyRing, derrors.Error) {
+	ring := NewKeyRing()
+	for _, kd := range d.Keys {
+		key, err := kd.Load()
+		if err != nil {
+			return nil, err
+		}
+		err = ring.Add(key, kd.RetireAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, exists := ring.keys[d.Active]; !exists {
+		return nil, derrors.NewInvalidArgumentError("active signing key not found in the key ring").WithParams(d.Active)
+	}
+	ring.activeKeyID = d.Active
+	return ring, nil
+}
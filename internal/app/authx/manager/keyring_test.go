@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/providers/revocation"
+	nalejToken "github.com/stronker/authx/internal/app/authx/providers/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var _ = ginkgo.Describe("KeyRing", func() {
+	claim := token.NewPersonalClaim("u1", "r1", []string{"p1", "p2"}, "o1")
+	expirationPeriod, _ := time.ParseDuration("10m")
+
+	var keyRing *KeyRing
+	var manager Token
+
+	ginkgo.BeforeEach(func() {
+		keyRing = NewKeyRingWithKey(token.NewHMACSigningKey("k1", "myLittleSecret112131"))
+		manager = NewJWTTokenWithKeyRing(nalejToken.NewTokenMockup(), revocation.NewRevocationMockup(), NewBCryptPassword(), keyRing)
+		privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		err := keyRing.Add(token.NewECSigningKey("k2", privateKey), 0)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("signs new tokens with the promoted key", func() {
+		err := keyRing.Promote("k2", expirationPeriod)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gT, err := manager.Generate(claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		tk, _ := jwt.ParseWithClaims(gT.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return nil, nil
+		})
+		gomega.Expect(tk.Header[token.KeyIDHeader]).To(gomega.Equal("k2"))
+		gomega.Expect(tk.Method).To(gomega.Equal(jwt.SigningMethodES256))
+		gomega.Expect(keyRing.KeySet().Keys).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("accepts tokens signed with the previous key during the overlap", func() {
+		gT, err := manager.Generate(claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = keyRing.Promote("k2", expirationPeriod)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(gTNew).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("rejects tokens signed with a retired key", func() {
+		gT, err := manager.Generate(claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = keyRing.Promote("k2", -time.Minute)
+		gomega.Expect(err).To(gomega.Succeed())
+		gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(gTNew).To(gomega.BeNil())
+
+		err = keyRing.Retire("k1")
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = keyRing.VerificationKey("k1", jwt.SigningMethodHS256.Alg())
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("cannot retire the active key", func() {
+		err := keyRing.Retire("k1")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("rejects a kid with a different algorithm", func() {
+		_, err := keyRing.VerificationKey("k1", jwt.SigningMethodRS256.Alg())
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("KeyRingDescriptor", func() {
+	var dir string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "keyring")
+		gomega.Expect(err).To(gomega.Succeed())
+		err = ioutil.WriteFile(filepath.Join(dir, "k1.secret"), []byte("myLittleSecret112131"), 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+		privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, _ := x509.MarshalECPrivateKey(privateKey)
+		content := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		err = ioutil.WriteFile(filepath.Join(dir, "k2.pem"), content, 0600)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("can promote a key and build the ring", func() {
+		path := filepath.Join(dir, "keyring.json")
+		descriptor := &KeyRingDescriptor{}
+		descriptor.Promote(KeyDescriptor{KeyID: "k1", Path: filepath.Join(dir, "k1.secret"),
+			Algorithm: jwt.SigningMethodHS256.Alg()}, time.Hour)
+		descriptor.Promote(KeyDescriptor{KeyID: "k2", Path: filepath.Join(dir, "k2.pem")}, time.Hour)
+		gomega.Expect(descriptor.Save(path)).To(gomega.Succeed())
+
+		loaded, err := LoadKeyRingDescriptor(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(loaded.Active).To(gomega.Equal("k2"))
+		gomega.Expect(loaded.Keys).To(gomega.HaveLen(2))
+		gomega.Expect(loaded.Keys[0].RetireAt).NotTo(gomega.BeZero())
+
+		keyRing, err := loaded.Build()
+		gomega.Expect(err).To(gomega.Succeed())
+		active, err := keyRing.ActiveKey()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(active.KeyID).To(gomega.Equal("k2"))
+		_, err = keyRing.VerificationKey("k1", jwt.SigningMethodHS256.Alg())
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gomega.Expect(loaded.Retire("k1")).To(gomega.Succeed())
+		gomega.Expect(loaded.Retire("k2")).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("can load PKCS8 keys and rejects EC keys that are not P-256", func() {
+		privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+		content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		key, err := token.NewSigningKeyFromPEM("k3", content)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(key.Method).To(gomega.Equal(jwt.SigningMethodES256))
+
+		otherCurve, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		der, _ = x509.MarshalPKCS8PrivateKey(otherCurve)
+		content = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		_, err = token.NewSigningKeyFromPEM("k4", content)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+})
@@ -0,0 +1,280 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"github.com/stronker/authx/internal/app/authx/providers/lockout"
+	"strings"
+	"time"
+)
+
+// DefaultMaxUserFailures is the default number of failed logins of a username before it is locked.
+const DefaultMaxUserFailures = 5
+
+// DefaultMaxClientFailures is the default number of failed logins from a client IP before it is locked. It is higher
+// than the limit of a user as several users may share the same address.
+const DefaultMaxClientFailures = 50
+
+// maxUpdateRetries is the number of times the update of the attempts is retried when there are concurrent failures.
+const maxUpdateRetries = 10
+
+// DefaultLockoutDuration is the default time the logins are rejected after reaching the limit of failures.
+const DefaultLockoutDuration = 15 * time.Minute
+
+// LockoutPolicy defines how the failed logins are throttled.
+type LockoutPolicy struct {
+	// MaxUserFailures is the number of consecutive failures that locks a username.
+	MaxUserFailures int
+	// MaxClientFailures is the number of consecutive failures that locks a client IP. Zero disables the limit.
+	MaxClientFailures int
+	// LockoutDuration is the time a username or a client IP is locked.
+	LockoutDuration time.Duration
+	// BaseDelay is the time to wait after the second consecutive failure. The delay doubles with each new failure.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum time to wait between attempts.
+	MaxDelay time.Duration
+	// FailureWindow is the time after which the previous failures are forgotten.
+	FailureWindow time.Duration
+}
+
+// NewDefaultLockoutPolicy creates a policy with the default values.
+func NewDefaultLockoutPolicy() LockoutPolicy {
+	return LockoutPolicy{
+		MaxUserFailures:   DefaultMaxUserFailures,
+		MaxClientFailures: DefaultMaxClientFailures,
+		LockoutDuration:   DefaultLockoutDuration,
+		BaseDelay:         time.Second,
+		MaxDelay:          30 * time.Second,
+		FailureWindow:     DefaultLockoutDuration,
+	}
+}
+
+// delay returns the time to wait before the next attempt after a number of failures.
+func (lp *LockoutPolicy) delay(failures int) time.Duration {
+	if failures < 2 || lp.BaseDelay <= 0 {
+		return 0
+	}
+	delay := lp.BaseDelay
+	for i := 2; i < failures && delay < lp.MaxDelay; i++ {
+		delay = delay * 2
+	}
+	if delay > lp.MaxDelay {
+		return lp.MaxDelay
+	}
+	return delay
+}
+
+// Lockout is the interface that protects the basic credentials against brute-force attacks.
+type Lockout interface {
+	// Attempt reserves a login attempt of a username and a client. The attempt is counted as a failure until it is
+	// released, so concurrent attempts cannot exceed the limits. It returns an error if the username or the client
+	// are locked or must wait before trying again.
+	Attempt(username string, client *entities.ClientInfo) derrors.Error
+	// Release undoes a reserved attempt whose credentials were valid but that is not a complete login.
+	Release(username string, client *entities.ClientInfo) derrors.Error
+	// Success resets the failures of a username after a valid login and releases the attempt of the client.
+	Success(username string, client *entities.ClientInfo) derrors.Error
+	// Unlock removes the failures and the lock of a username.
+	Unlock(username string) derrors.Error
+	// Clean remove all the data from the providers.
+	Clean() derrors.Error
+}
+
+// ProviderLockout is an implementation of Lockout that stores the attempts in a provider so all the replicas share
+// the same counters.
+type ProviderLockout struct {
+	Provider lockout.Provider
+	Policy   LockoutPolicy
+}
+
+// NewProviderLockout creates a new instance of ProviderLockout.
+func NewProviderLockout(provider lockout.Provider, policy LockoutPolicy) *ProviderLockout {
+	return &ProviderLockout{Provider: provider, Policy: policy}
+}
+
+// NewLockoutMockup creates a new lockout with the default policy and an in-memory provider.
+func NewLockoutMockup() *ProviderLockout {
+	return NewProviderLockout(lockout.NewLockoutMockup(), NewDefaultLockoutPolicy())
+}
+
+// Attempt reserves an attempt of the username and the client, in that order. If the client is locked the attempt
+// of the username is released.
+func (l *ProviderLockout) Attempt(username string, client *entities.ClientInfo) derrors.Error {
+	now := time.Now()
+	keys := l.keys(username, client)
+	for i, key := range keys {
+		err := l.reserve(username, key, now)
+		if err != nil {
+			for _, reserved := range keys[:i] {
+				l.logRelease(reserved, l.release(reserved, now))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Release undoes the attempt reserved for the username and the client.
+func (l *ProviderLockout) Release(username string, client *entities.ClientInfo) derrors.Error {
+	now := time.Now()
+	for _, key := range l.keys(username, client) {
+		err := l.release(key, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Success resets the failures of a username. The previous failures of the client are kept so an attacker cannot
+// reset them with a valid account, only the attempt reserved for this login is released.
+func (l *ProviderLockout) Success(username string, client *entities.ClientInfo) derrors.Error {
+	err := l.Provider.Delete(entities.UserAttemptsKey(username))
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, key := range l.keys(username, client)[1:] {
+		err := l.release(key, now)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Unlock removes the failures and the lock of a username.
+func (l *ProviderLockout) Unlock(username string) derrors.Error {
+	return l.Provider.Delete(entities.UserAttemptsKey(username))
+}
+
+// Clean remove all the data from the provider.
+func (l *ProviderLockout) Clean() derrors.Error {
+	return l.Provider.Truncate()
+}
+
+// keys returns the keys whose attempts are counted for a login.
+func (l *ProviderLockout) keys(username string, client *entities.ClientInfo) []string {
+	keys := []string{entities.UserAttemptsKey(username)}
+	if client != nil && client.IP != "" && l.Policy.MaxClientFailures > 0 {
+		keys = append(keys, entities.ClientAttemptsKey(client.IP))
+	}
+	return keys
+}
+
+// reserve adds a failure to the attempts of a key if the key is not locked and it does not have to wait before
+// trying again, locking it if it reaches the limit. The attempts are updated only if nobody changed them since they
+// were read, retrying otherwise, so the concurrent attempts are all counted.
+func (l *ProviderLockout) reserve(username string, key string, now time.Time) derrors.Error {
+	for retry := 0; retry < maxUpdateRetries; retry++ {
+		previous, err := l.Provider.Get(key)
+		if err != nil && err.Type() != derrors.NotFound {
+			return err
+		}
+		attempts := entities.NewLoginAttemptsData(key)
+		if previous != nil && !l.expired(previous, now) {
+			*attempts = *previous
+			if attempts.LockedUntil > now.Unix() {
+				return derrors.NewResourceExhaustedError("too many failed login attempts, the credentials are temporarily locked").
+					WithParams(username)
+			}
+			retryAt := l.retryAt(attempts)
+			if retryAt.After(now) {
+				return derrors.NewResourceExhaustedError("too many failed login attempts, retry later").
+					WithParams(username, retryAt.Sub(now).String())
+			}
+		}
+		attempts.Failures++
+		attempts.LastFailure = now.Unix()
+		if attempts.Failures >= l.maxFailures(key) {
+			attempts.LockedUntil = now.Add(l.Policy.LockoutDuration).Unix()
+		}
+		updated, err := l.Provider.CompareAndSet(previous, attempts)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+	}
+	return derrors.NewUnavailableError("cannot register the login attempt due to concurrent updates").WithParams(key)
+}
+
+// release removes a reserved failure from the attempts of a key, and the lock if the failure caused it.
+func (l *ProviderLockout) release(key string, now time.Time) derrors.Error {
+	for retry := 0; retry < maxUpdateRetries; retry++ {
+		previous, err := l.Provider.Get(key)
+		if err != nil {
+			if err.Type() == derrors.NotFound {
+				return nil
+			}
+			return err
+		}
+		if l.expired(previous, now) || previous.Failures == 0 {
+			return nil
+		}
+		attempts := *previous
+		attempts.Failures--
+		if attempts.Failures < l.maxFailures(key) {
+			attempts.LockedUntil = 0
+		}
+		updated, err := l.Provider.CompareAndSet(previous, &attempts)
+		if err != nil {
+			return err
+		}
+		if updated {
+			return nil
+		}
+	}
+	return derrors.NewUnavailableError("cannot release the login attempt due to concurrent updates").WithParams(key)
+}
+
+// logRelease logs the error of a release that cannot be returned to the caller.
+func (l *ProviderLockout) logRelease(key string, err derrors.Error) {
+	if err != nil {
+		log.Warn().Str("trace", err.DebugReport()).Str("key", key).Msg("cannot release login attempt")
+	}
+}
+
+// retryAt returns the time after which a new attempt is allowed. The failures are stored with a precision of seconds,
+// so the delay starts at the end of the second of the last failure.
+func (l *ProviderLockout) retryAt(attempts *entities.LoginAttemptsData) time.Time {
+	delay := l.Policy.delay(attempts.Failures)
+	if delay == 0 {
+		return time.Time{}
+	}
+	return time.Unix(attempts.LastFailure+1, 0).Add(delay)
+}
+
+// expired checks if the previous failures no longer count, either because the lock is over or because they are
+// older than the failure window.
+func (l *ProviderLockout) expired(attempts *entities.LoginAttemptsData, now time.Time) bool {
+	if attempts.LockedUntil != 0 {
+		return attempts.LockedUntil <= now.Unix()
+	}
+	return time.Unix(attempts.LastFailure, 0).Add(l.Policy.FailureWindow).Before(now)
+}
+
+func (l *ProviderLockout) maxFailures(key string) int {
+	if strings.HasPrefix(key, entities.ClientAttemptsPrefix) {
+		return l.Policy.MaxClientFailures
+	}
+	return l.Policy.MaxUserFailures
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"fmt"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"github.com/stronker/authx/internal/app/authx/providers/lockout"
+	"sync"
+	"time"
+)
+
+var _ = ginkgo.Describe("Lockout", func() {
+	client := entities.NewClientInfo("10.0.0.1", "test-agent")
+
+	var policy LockoutPolicy
+	var manager *ProviderLockout
+
+	ginkgo.BeforeEach(func() {
+		policy = NewDefaultLockoutPolicy()
+		policy.MaxUserFailures = 3
+		policy.MaxClientFailures = 4
+		policy.BaseDelay = 0
+		manager = NewProviderLockout(lockout.NewLockoutMockup(), policy)
+	})
+
+	expectExhausted := func(err derrors.Error) {
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+	}
+
+	ginkgo.It("computes progressive delays", func() {
+		policy.BaseDelay = time.Second
+		policy.MaxDelay = 5 * time.Second
+		gomega.Expect(policy.delay(1)).To(gomega.BeZero())
+		gomega.Expect(policy.delay(2)).To(gomega.Equal(time.Second))
+		gomega.Expect(policy.delay(3)).To(gomega.Equal(2 * time.Second))
+		gomega.Expect(policy.delay(4)).To(gomega.Equal(4 * time.Second))
+		gomega.Expect(policy.delay(10)).To(gomega.Equal(5 * time.Second))
+	})
+
+	ginkgo.It("delays the attempts after consecutive failures", func() {
+		manager.Policy.BaseDelay = time.Hour
+		manager.Policy.MaxDelay = time.Hour
+		gomega.Expect(manager.Attempt("u1", client)).To(gomega.Succeed())
+		gomega.Expect(manager.Attempt("u1", client)).To(gomega.Succeed())
+		expectExhausted(manager.Attempt("u1", nil))
+	})
+
+	ginkgo.It("locks a user after the maximum number of failures", func() {
+		for i := 0; i < policy.MaxUserFailures; i++ {
+			gomega.Expect(manager.Attempt("u1", nil)).To(gomega.Succeed())
+		}
+		expectExhausted(manager.Attempt("u1", nil))
+		gomega.Expect(manager.Attempt("u2", nil)).To(gomega.Succeed())
+
+		err := manager.Unlock("u1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.Attempt("u1", nil)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("locks a client after the maximum number of failures", func() {
+		for i := 0; i < policy.MaxClientFailures; i++ {
+			gomega.Expect(manager.Attempt(fmt.Sprintf("u%d", i), client)).To(gomega.Succeed())
+		}
+		expectExhausted(manager.Attempt("other", client))
+		gomega.Expect(manager.Attempt("other", entities.NewClientInfo("10.0.0.2", ""))).To(gomega.Succeed())
+	})
+
+	ginkgo.It("releases the attempt of the user when the client is locked", func() {
+		for i := 0; i < policy.MaxClientFailures; i++ {
+			gomega.Expect(manager.Attempt(fmt.Sprintf("u%d", i), client)).To(gomega.Succeed())
+		}
+		expectExhausted(manager.Attempt("other", client))
+		attempts, err := manager.Provider.Get(entities.UserAttemptsKey("other"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(attempts.Failures).To(gomega.BeZero())
+	})
+
+	ginkgo.It("doesn't count the failures of a client without limit", func() {
+		manager.Policy.MaxClientFailures = 0
+		gomega.Expect(manager.Attempt("u1", client)).To(gomega.Succeed())
+		_, err := manager.Provider.Get(entities.ClientAttemptsKey(client.IP))
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+	})
+
+	ginkgo.It("doesn't allow more concurrent attempts than the limit", func() {
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		allowed := 0
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer ginkgo.GinkgoRecover()
+				err := manager.Attempt("u1", nil)
+				if err == nil {
+					mutex.Lock()
+					allowed++
+					mutex.Unlock()
+				} else {
+					gomega.Expect(err.Type()).To(gomega.Or(gomega.Equal(derrors.ResourceExhausted),
+						gomega.Equal(derrors.Unavailable)))
+				}
+			}()
+		}
+		wg.Wait()
+		gomega.Expect(allowed).To(gomega.BeNumerically("<=", policy.MaxUserFailures))
+		attempts, err := manager.Provider.Get(entities.UserAttemptsKey("u1"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(attempts.Failures).To(gomega.Equal(allowed))
+	})
+
+	ginkgo.It("resets the failures of a user after a valid login", func() {
+		for i := 0; i < policy.MaxUserFailures-1; i++ {
+			gomega.Expect(manager.Attempt("u1", client)).To(gomega.Succeed())
+		}
+		gomega.Expect(manager.Attempt("u1", client)).To(gomega.Succeed())
+		err := manager.Success("u1", client)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.Attempt("u1", nil)).To(gomega.Succeed())
+
+		attempts, err := manager.Provider.Get(entities.ClientAttemptsKey(client.IP))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(attempts.Failures).To(gomega.Equal(policy.MaxUserFailures - 1))
+	})
+
+	ginkgo.It("releases an attempt and the lock it caused", func() {
+		for i := 0; i < policy.MaxUserFailures; i++ {
+			gomega.Expect(manager.Attempt("u1", nil)).To(gomega.Succeed())
+		}
+		err := manager.Release("u1", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		attempts, err := manager.Provider.Get(entities.UserAttemptsKey("u1"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(attempts.Failures).To(gomega.Equal(policy.MaxUserFailures - 1))
+		gomega.Expect(attempts.LockedUntil).To(gomega.BeZero())
+	})
+
+	ginkgo.It("forgets the failures after the lockout", func() {
+		err := manager.Provider.Set(&entities.LoginAttemptsData{
+			AttemptKey:  entities.UserAttemptsKey("u1"),
+			Failures:    policy.MaxUserFailures,
+			LastFailure: time.Now().Add(-time.Hour).Unix(),
+			LockedUntil: time.Now().Add(-time.Minute).Unix(),
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.Attempt("u1", nil)).To(gomega.Succeed())
+
+		attempts, err := manager.Provider.Get(entities.UserAttemptsKey("u1"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(attempts.Failures).To(gomega.Equal(1))
+		gomega.Expect(attempts.LockedUntil).To(gomega.BeZero())
+	})
+})
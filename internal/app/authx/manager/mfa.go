@@ -0,0 +1,306 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/rs/zerolog/log"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"strings"
+	"time"
+)
+
+// MFAIssuer is the issuer shown by the authenticator applications.
+const MFAIssuer = "Nalej"
+
+// MFAChallengeExpiration is the time to complete the second step of a login with MFA.
+const MFAChallengeExpiration = 5 * time.Minute
+
+// MFAChallengeAudience identifies the tokens that are MFA challenges.
+const MFAChallengeAudience = "mfa_challenge"
+
+// RecoveryCodeAuthentication identifies the logins completed with a recovery code. It is not included in the amr
+// claim as RFC 8176 does not define a value for it.
+const RecoveryCodeAuthentication = "recovery_code"
+
+// NumRecoveryCodes is the number of recovery codes generated when MFA is enabled.
+const NumRecoveryCodes = 10
+
+// recoveryCodeLength is the number of characters of a recovery code, without the separator.
+const recoveryCodeLength = 10
+
+// recoveryCodeEncoding is the encoding of the recovery codes.
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// mfaChallenge returns the response of the first step of a login with MFA. The challenge is signed with the keys of
+// the user tokens, and its audience prevents it from being accepted as one.
+func (m *Authx) mfaChallenge(username string) (*pbAuthx.LoginResponse, derrors.Error) {
+	now := time.Now()
+	claims := jwt.StandardClaims{
+		Subject:   username,
+		Audience:  MFAChallengeAudience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(MFAChallengeExpiration).Unix(),
+	}
+	challenge, err := m.Token.SignClaims(claims, m.secret)
+	if err != nil {
+		return nil, err
+	}
+	return &pbAuthx.LoginResponse{MfaChallenge: challenge}, nil
+}
+
+// challengeUsername validates an MFA challenge and returns the user that has to complete it.
+func (m *Authx) challengeUsername(challenge string) (string, derrors.Error) {
+	claims := &jwt.StandardClaims{}
+	err := m.Token.ParseClaims(challenge, claims, m.secret)
+	if err != nil {
+		return "", derrors.NewUnauthenticatedError("invalid MFA challenge", err)
+	}
+	if !claims.VerifyAudience(MFAChallengeAudience, true) || claims.Subject == "" {
+		return "", derrors.NewUnauthenticatedError("invalid MFA challenge")
+	}
+	return claims.Subject, nil
+}
+
+// LoginWithMFA completes a login with MFA. The code may be a TOTP code or an unused recovery code. The failed
+// attempts are counted as failed logins.
+func (m *Authx) LoginWithMFA(challenge string, code string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	username, err := m.challengeUsername(challenge)
+	if err != nil {
+		return nil, err
+	}
+	err = m.Lockout.Attempt(username, client)
+	if err != nil {
+		return nil, err
+	}
+	credentials, err := m.CredentialsProvider.Get(username)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("invalid MFA challenge").WithParams(username)
+		}
+		m.releaseAttempt(username, client)
+		return nil, err
+	}
+	if !credentials.MFAEnabled {
+		return nil, derrors.NewUnauthenticatedError("invalid MFA challenge").WithParams(username)
+	}
+	method, err := m.verifyMFACode(credentials, code)
+	if err != nil {
+		m.releaseAttempt(username, client)
+		return nil, err
+	}
+	if method == "" {
+		return nil, m.loginFailure(username)
+	}
+	m.loginSuccess(username, client)
+	methods := []string{token.PasswordAuthentication, token.MultiFactorAuthentication}
+	if method == token.OTPAuthentication {
+		methods = []string{token.PasswordAuthentication, token.OTPAuthentication, token.MultiFactorAuthentication}
+	}
+	return m.loginResponse(credentials, methods, client)
+}
+
+// verifyMFACode checks a TOTP code or a recovery code. The accepted code is registered with a conditional update so
+// it cannot be used again, even by a concurrent request. It returns the authentication method of the code, or an
+// empty string if the code is not valid.
+func (m *Authx) verifyMFACode(credentials *entities.BasicCredentialsData, code string) (string, derrors.Error) {
+	code = strings.TrimSpace(code)
+	if counter, ok := ValidateTOTP(credentials.MFASecret, code, time.Now(), credentials.MFALastCounter); ok {
+		updated, err := m.CredentialsProvider.CompareAndSetMFALastCounter(credentials.Username,
+			credentials.MFALastCounter, counter)
+		if err != nil || !updated {
+			return "", err
+		}
+		return token.OTPAuthentication, nil
+	}
+	hashedCode := hashRecoveryCode(code)
+	for i, recoveryCode := range credentials.RecoveryCodes {
+		if hmac.Equal([]byte(recoveryCode), []byte(hashedCode)) {
+			remaining := make([]string, 0, len(credentials.RecoveryCodes)-1)
+			remaining = append(remaining, credentials.RecoveryCodes[:i]...)
+			remaining = append(remaining, credentials.RecoveryCodes[i+1:]...)
+			updated, err := m.CredentialsProvider.CompareAndSetRecoveryCodes(credentials.Username,
+				credentials.RecoveryCodes, remaining)
+			if err != nil || !updated {
+				return "", err
+			}
+			log.Info().Str("username", credentials.Username).Int("remaining", len(remaining)).Msg("recovery code used")
+			return RecoveryCodeAuthentication, nil
+		}
+	}
+	return "", nil
+}
+
+// EnrollMFA starts the enrolment of MFA generating a new TOTP secret. MFA is not enabled until a code of the secret
+// is verified with VerifyMFA. The failed password checks are counted by the lockout.
+func (m *Authx) EnrollMFA(username string, password string, client *entities.ClientInfo) (*entities.MFAEnrollment, derrors.Error) {
+	credentials, err := m.checkPassword(username, password, client)
+	if err != nil {
+		return nil, err
+	}
+	m.loginSuccess(username, client)
+	if credentials.MFAEnabled {
+		return nil, derrors.NewFailedPreconditionError("MFA is already enabled").WithParams(username)
+	}
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	edit := entities.NewEditBasicCredentialsData().WithMFASecret(secret).WithMFALastCounter(0)
+	err = m.CredentialsProvider.Edit(username, edit)
+	if err != nil {
+		return nil, err
+	}
+	return entities.NewMFAEnrollment(secret, TOTPURI(MFAIssuer, username, secret)), nil
+}
+
+// VerifyMFA completes the enrolment of MFA after checking the password and a code of the new secret. It returns the
+// recovery codes, which are only stored hashed and cannot be recovered later. The failed checks are counted by the
+// lockout.
+func (m *Authx) VerifyMFA(username string, password string, code string, client *entities.ClientInfo) ([]string, derrors.Error) {
+	credentials, err := m.checkPassword(username, password, client)
+	if err != nil {
+		return nil, err
+	}
+	if credentials.MFAEnabled {
+		m.releaseAttempt(username, client)
+		return nil, derrors.NewFailedPreconditionError("MFA is already enabled").WithParams(username)
+	}
+	if credentials.MFASecret == "" {
+		m.releaseAttempt(username, client)
+		return nil, derrors.NewFailedPreconditionError("MFA enrolment has not been started").WithParams(username)
+	}
+	counter, ok := ValidateTOTP(credentials.MFASecret, strings.TrimSpace(code), time.Now(), credentials.MFALastCounter)
+	if !ok {
+		return nil, m.loginFailure(username)
+	}
+	updated, err := m.CredentialsProvider.CompareAndSetMFALastCounter(username, credentials.MFALastCounter, counter)
+	if err != nil {
+		m.releaseAttempt(username, client)
+		return nil, err
+	}
+	if !updated {
+		return nil, m.loginFailure(username)
+	}
+	m.loginSuccess(username, client)
+	codes, hashedCodes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	edit := entities.NewEditBasicCredentialsData().WithMFAEnabled(true).WithRecoveryCodes(hashedCodes)
+	err = m.CredentialsProvider.Edit(username, edit)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA disables MFA after checking the password and a TOTP or recovery code. The secret and the recovery codes
+// are removed. The failed checks are counted by the lockout.
+func (m *Authx) DisableMFA(username string, password string, code string, client *entities.ClientInfo) derrors.Error {
+	credentials, err := m.checkPassword(username, password, client)
+	if err != nil {
+		return err
+	}
+	if !credentials.MFAEnabled {
+		m.releaseAttempt(username, client)
+		return derrors.NewFailedPreconditionError("MFA is not enabled").WithParams(username)
+	}
+	method, err := m.verifyMFACode(credentials, code)
+	if err != nil {
+		m.releaseAttempt(username, client)
+		return err
+	}
+	if method == "" {
+		// The invalid codes are counted as failures so they cannot be guessed with a known password.
+		return m.loginFailure(username)
+	}
+	m.loginSuccess(username, client)
+	edit := entities.NewEditBasicCredentialsData().WithMFAEnabled(false).WithMFASecret("").WithMFALastCounter(0).
+		WithRecoveryCodes([]string{})
+	return m.CredentialsProvider.Edit(username, edit)
+}
+
+// generateRecoveryCodes creates a new set of recovery codes and their hashes.
+func generateRecoveryCodes() ([]string, []string, derrors.Error) {
+	codes := make([]string, 0, NumRecoveryCodes)
+	hashedCodes := make([]string, 0, NumRecoveryCodes)
+	for i := 0; i < NumRecoveryCodes; i++ {
+		random := make([]byte, recoveryCodeLength)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, nil, derrors.AsError(err, "cannot generate recovery code")
+		}
+		encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(random))[:recoveryCodeLength]
+		code := encoded[:recoveryCodeLength/2] + "-" + encoded[recoveryCodeLength/2:]
+		codes = append(codes, code)
+		hashedCodes = append(hashedCodes, hashRecoveryCode(code))
+	}
+	return codes, hashedCodes, nil
+}
+
+// hashRecoveryCode returns the hash used to store a recovery code. The codes are case insensitive and the
+// separator is optional.
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	hash := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(hash[:])
+}
+
+// mfaRequired checks if the organization requires MFA for a user with a set of primitives.
+func (m *Authx) mfaRequired(organizationID string, primitives []string) (bool, derrors.Error) {
+	requirement, err := m.RoleProvider.GetMFARequirement(organizationID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return requirement.Required(primitives), nil
+}
+
+// SetMFARequirement defines which users of an organization must use MFA. The users that have not enrolled MFA only
+// receive tokens restricted to its enrolment, and the ones that have enrolled it must log in again with MFA.
+func (m *Authx) SetMFARequirement(requirement *entities.MFARequirementData) derrors.Error {
+	return m.RoleProvider.SetMFARequirement(requirement)
+}
+
+// GetMFARequirement returns the MFA requirement of an organization. An organization without requirement does not
+// require MFA to any user.
+func (m *Authx) GetMFARequirement(organizationID string) (*entities.MFARequirementData, derrors.Error) {
+	requirement, err := m.RoleProvider.GetMFARequirement(organizationID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return entities.NewMFARequirementData(organizationID, false, []string{}), nil
+		}
+		return nil, err
+	}
+	return requirement, nil
+}
+
+// RemoveMFARequirement removes the MFA requirement of an organization.
+func (m *Authx) RemoveMFARequirement(organizationID string) derrors.Error {
+	return m.RoleProvider.DeleteMFARequirement(organizationID)
+}
@@ -0,0 +1,341 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+// tokenClaim decodes the claim of a user token.
+func tokenClaim(tokenString string) *token.Claim {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	tk, jwtErr := parser.ParseWithClaims(tokenString, &token.Claim{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte(DefaultSecret), nil
+	})
+	gomega.Expect(jwtErr).To(gomega.Succeed())
+	return tk.Claims.(*token.Claim)
+}
+
+// totpCode returns the code of a secret for the time step after the current one, which has not been used yet.
+func totpCode(secret string) string {
+	code, err := TOTPCode(secret, TOTPCounter(time.Now())+1)
+	gomega.Expect(err).To(gomega.Succeed())
+	return code
+}
+
+var _ = ginkgo.Describe("Authx MFA", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should not enable MFA until a code is verified", func() {
+		enrollment, err := manager.EnrollMFA(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(enrollment.Secret).NotTo(gomega.BeEmpty())
+
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.Token).NotTo(gomega.BeEmpty())
+		gomega.Expect(response.MfaChallenge).To(gomega.BeEmpty())
+
+		_, err = manager.VerifyMFA(userName, pass, "000000", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		codes, err := manager.VerifyMFA(userName, pass, totpCode(enrollment.Secret), nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(codes).To(gomega.HaveLen(NumRecoveryCodes))
+	})
+
+	ginkgo.It("should not verify MFA with a wrong password", func() {
+		enrollment, err := manager.EnrollMFA(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.VerifyMFA(userName, pass+"wrong", totpCode(enrollment.Secret), nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should throttle the verifications after consecutive wrong codes", func() {
+		enrollment, err := manager.EnrollMFA(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		for i := 0; i < 2; i++ {
+			_, err = manager.VerifyMFA(userName, pass, "000000", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		}
+		_, err = manager.VerifyMFA(userName, pass, totpCode(enrollment.Secret), nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+	})
+
+	ginkgo.It("should not enroll with a wrong password", func() {
+		_, err := manager.EnrollMFA(userName, pass+"wrong", nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should throttle the enrolments after consecutive wrong passwords", func() {
+		for i := 0; i < 2; i++ {
+			_, err := manager.EnrollMFA(userName, pass+"wrong", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		}
+		_, err := manager.EnrollMFA(userName, pass, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+	})
+
+	ginkgo.Context("with MFA enabled", func() {
+		var secret string
+		var recoveryCodes []string
+
+		ginkgo.BeforeEach(func() {
+			enrollment, err := manager.EnrollMFA(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			secret = enrollment.Secret
+			code, err := TOTPCode(secret, TOTPCounter(time.Now()))
+			gomega.Expect(err).To(gomega.Succeed())
+			recoveryCodes, err = manager.VerifyMFA(userName, pass, code, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+
+		ginkgo.It("should require a second step to login", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(response.Token).To(gomega.BeEmpty())
+			gomega.Expect(response.RefreshToken).To(gomega.BeEmpty())
+			gomega.Expect(response.MfaChallenge).NotTo(gomega.BeEmpty())
+
+			code := totpCode(secret)
+			login, err := manager.LoginWithMFA(response.MfaChallenge, code, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(login.Token).NotTo(gomega.BeEmpty())
+			gomega.Expect(login.RefreshToken).NotTo(gomega.BeEmpty())
+
+			// a code cannot be reused
+			_, err = manager.LoginWithMFA(response.MfaChallenge, code, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+
+		ginkgo.It("should not accept the challenge as a token", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = manager.Token.GetTokenInfo(response.MfaChallenge, DefaultSecret)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			_, err = manager.LoginWithMFA("invalid", totpCode(secret), nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+
+		ginkgo.It("should accept a recovery code only once", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			login, err := manager.LoginWithMFA(response.MfaChallenge, recoveryCodes[0], nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(login.Token).NotTo(gomega.BeEmpty())
+
+			_, err = manager.LoginWithMFA(response.MfaChallenge, recoveryCodes[0], nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			credentials, err := manager.CredentialsProvider.Get(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(credentials.RecoveryCodes).To(gomega.HaveLen(NumRecoveryCodes - 1))
+		})
+
+		ginkgo.It("should lock the user after too many wrong codes", func() {
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			for i := 0; i < DefaultMaxUserFailures; i++ {
+				_, err = manager.LoginWithMFA(response.MfaChallenge, "000000", nil)
+				gomega.Expect(err).To(gomega.HaveOccurred())
+			}
+			_, err = manager.LoginWithMFA(response.MfaChallenge, totpCode(secret), nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+		})
+
+		ginkgo.It("should throttle the disabling after consecutive wrong codes", func() {
+			for i := 0; i < 2; i++ {
+				err := manager.DisableMFA(userName, pass, "000000", nil)
+				gomega.Expect(err).To(gomega.HaveOccurred())
+				gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			}
+			err := manager.DisableMFA(userName, pass, recoveryCodes[1], nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.ResourceExhausted))
+		})
+
+		ginkgo.It("should disable MFA with the password and a code", func() {
+			err := manager.DisableMFA(userName, pass, "000000", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			err = manager.DisableMFA(userName, pass, recoveryCodes[1], nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			credentials, err := manager.CredentialsProvider.Get(userName)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(credentials.MFAEnabled).To(gomega.BeFalse())
+			gomega.Expect(credentials.MFASecret).To(gomega.BeEmpty())
+			gomega.Expect(credentials.RecoveryCodes).To(gomega.BeEmpty())
+
+			response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(response.Token).NotTo(gomega.BeEmpty())
+		})
+
+		ginkgo.It("should not enroll again", func() {
+			_, err := manager.EnrollMFA(userName, pass, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.FailedPrecondition))
+		})
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("Authx MFA requirement", func() {
+	var manager = NewAuthxMockup()
+	userName := "u1"
+	adminName := "admin"
+	organizationID := "o1"
+	roleID := "r1"
+	adminRoleID := "r2"
+	pass := "MyLittlePassword"
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_PROFILE},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         adminRoleID,
+			Name:           "rName2",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG, pbAuthx.AccessPrimitive_ORG_MNGT},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.AddBasicCredentials(adminName, organizationID, adminRoleID, pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.SetMFARequirement(entities.NewMFARequirementData(organizationID, false,
+			[]string{pbAuthx.AccessPrimitive_ORG_MNGT.String()}))
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should include the authentication methods in the token", func() {
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.MfaEnrolmentRequired).To(gomega.BeFalse())
+		claim := tokenClaim(response.Token)
+		gomega.Expect(claim.AuthenticationMethods).To(gomega.Equal([]string{token.PasswordAuthentication}))
+		gomega.Expect(claim.Primitives).To(gomega.Equal([]string{pbAuthx.AccessPrimitive_PROFILE.String()}))
+	})
+
+	ginkgo.It("should return a restricted token to the users without MFA", func() {
+		response, err := manager.LoginWithBasicCredentials(adminName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.MfaEnrolmentRequired).To(gomega.BeTrue())
+		claim := tokenClaim(response.Token)
+		gomega.Expect(claim.IsMFAEnrolment()).To(gomega.BeTrue())
+		gomega.Expect(claim.Primitives).To(gomega.Equal([]string{token.MFAEnrolmentPrimitive}))
+
+		refreshed, err := manager.RefreshToken(response.Token, response.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(refreshed.Token).IsMFAEnrolment()).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should return the complete token after the MFA login", func() {
+		response, err := manager.LoginWithBasicCredentials(adminName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		enrollment, err := manager.EnrollMFA(adminName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		code, err := TOTPCode(enrollment.Secret, TOTPCounter(time.Now()))
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = manager.VerifyMFA(adminName, pass, code, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		// the restricted session cannot be upgraded without MFA
+		_, err = manager.RefreshToken(response.Token, response.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+
+		challenge, err := manager.LoginWithBasicCredentials(adminName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		login, err := manager.LoginWithMFA(challenge.MfaChallenge, totpCode(enrollment.Secret), nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(login.MfaEnrolmentRequired).To(gomega.BeFalse())
+		claim := tokenClaim(login.Token)
+		gomega.Expect(claim.IsMFAEnrolment()).To(gomega.BeFalse())
+		gomega.Expect(claim.HasAuthenticationMethod(token.MultiFactorAuthentication)).To(gomega.BeTrue())
+		gomega.Expect(claim.HasAuthenticationMethod(token.OTPAuthentication)).To(gomega.BeTrue())
+
+		refreshed, err := manager.RefreshToken(login.Token, login.RefreshToken, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenClaim(refreshed.Token).AuthenticationMethods).To(gomega.Equal(claim.AuthenticationMethods))
+	})
+
+	ginkgo.It("should require MFA to all the users of the organization", func() {
+		err := manager.SetMFARequirement(entities.NewMFARequirementData(organizationID, true, []string{}))
+		gomega.Expect(err).To(gomega.Succeed())
+		response, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.MfaEnrolmentRequired).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should remove the requirement", func() {
+		err := manager.RemoveMFARequirement(organizationID)
+		gomega.Expect(err).To(gomega.Succeed())
+		requirement, err := manager.GetMFARequirement(organizationID)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(requirement.AllUsers).To(gomega.BeFalse())
+		gomega.Expect(requirement.Primitives).To(gomega.BeEmpty())
+
+		response, err := manager.LoginWithBasicCredentials(adminName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response.MfaEnrolmentRequired).To(gomega.BeFalse())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
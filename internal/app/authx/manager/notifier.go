@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Notifier is the interface that delivers the password reset tokens to the users.
+type Notifier interface {
+	// NotifyPasswordReset sends a password reset token to a user.
+	NotifyPasswordReset(notification *PasswordResetNotification) derrors.Error
+}
+
+// PasswordResetNotification contains the information sent to a user that requested a password reset.
+type PasswordResetNotification struct {
+	Username       string `json:"username"`
+	Token          string `json:"token"`
+	ExpirationDate int64  `json:"expiration_date"`
+}
+
+// LogNotifier writes the notifications in the log. It must only be used in development environments as the
+// tokens are exposed to anyone with access to the logs.
+type LogNotifier struct {
+}
+
+// NewLogNotifier creates a new LogNotifier.
+func NewLogNotifier() Notifier {
+	return &LogNotifier{}
+}
+
+// NotifyPasswordReset writes the token in the log.
+func (n *LogNotifier) NotifyPasswordReset(notification *PasswordResetNotification) derrors.Error {
+	log.Info().Str("username", notification.Username).Str("token", notification.Token).
+		Str("expiration", time.Unix(notification.ExpirationDate, 0).String()).Msg("password reset requested")
+	return nil
+}
+
+// FileNotifier appends the notifications to a file, one JSON object per line.
+type FileNotifier struct {
+	sync.Mutex
+	path string
+}
+
+// NewFileNotifier creates a new FileNotifier that writes on the given path.
+func NewFileNotifier(path string) Notifier {
+	return &FileNotifier{path: path}
+}
+
+// NotifyPasswordReset appends the notification to the file.
+func (n *FileNotifier) NotifyPasswordReset(notification *PasswordResetNotification) derrors.Error {
+	content, err := json.Marshal(notification)
+	if err != nil {
+		return derrors.AsError(err, "cannot marshal password reset notification")
+	}
+	n.Lock()
+	defer n.Unlock()
+	file, err := os.OpenFile(n.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return derrors.AsError(err, "cannot open password reset notification file")
+	}
+	defer file.Close()
+	_, err = file.Write(append(content, '\n'))
+	if err != nil {
+		return derrors.AsError(err, "cannot write password reset notification")
+	}
+	return nil
+}
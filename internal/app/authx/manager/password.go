@@ -18,21 +18,74 @@
 package manager
 
 import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
 	"github.com/nalej/derrors"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
+// BCryptHashAlgorithm is the name of the BCrypt implementation.
+const BCryptHashAlgorithm = "bcrypt"
+
+// Argon2idHashAlgorithm is the name of the Argon2id implementation.
+const Argon2idHashAlgorithm = "argon2id"
+
+// argon2idPrefix is the prefix of the hashes generated with Argon2id.
+const argon2idPrefix = "$argon2id$"
+
 //Password is an interface to generate hash of passwords.
 type Password interface {
 	// GenerateHashedPassword generates a password with a random salt.
 	GenerateHashedPassword(password string) ([]byte, derrors.Error)
-	// CompareHashAndPassword compare a hashed password with a specif password.
+	// CompareHashAndPassword compare a hashed password with a specif password. The hashes generated by any of the
+	// supported algorithms are accepted.
 	CompareHashAndPassword(hashedPassword []byte, password string) derrors.Error
+	// NeedsRehash checks if a hash has been generated with a different algorithm or parameters.
+	NeedsRehash(hashedPassword []byte) bool
+}
+
+// NewPassword builds the implementation of the Password interface for a given algorithm.
+func NewPassword(algorithm string, bcryptCost int, argon2idParams Argon2idParams) (Password, derrors.Error) {
+	switch algorithm {
+	case BCryptHashAlgorithm:
+		if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+			return nil, derrors.NewInvalidArgumentError("invalid bcrypt cost").WithParams(bcryptCost)
+		}
+		return NewBCryptPasswordWithCost(bcryptCost), nil
+	case Argon2idHashAlgorithm:
+		if argon2idParams.Iterations == 0 || argon2idParams.Memory == 0 || argon2idParams.Parallelism == 0 {
+			return nil, derrors.NewInvalidArgumentError("invalid argon2id parameters")
+		}
+		return NewArgon2idPassword(argon2idParams), nil
+	}
+	return nil, derrors.NewInvalidArgumentError("unsupported password hash algorithm").WithParams(algorithm)
+}
+
+// compareHashAndPassword validates a password against a hash generated by any of the supported algorithms.
+func compareHashAndPassword(hashedPassword []byte, password string) derrors.Error {
+	if bytes.HasPrefix(hashedPassword, []byte(argon2idPrefix)) {
+		return compareArgon2id(hashedPassword, password)
+	}
+	err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err != nil {
+		return derrors.NewUnauthenticatedError("password is not valid", err)
+	}
+	return nil
 }
 
 // NewBCryptPassword build a object that uses BCrypt to implement the Password interface.
 func NewBCryptPassword() Password {
-	return &BCryptPassword{cost: bcrypt.DefaultCost}
+	return NewBCryptPasswordWithCost(bcrypt.DefaultCost)
+}
+
+// NewBCryptPasswordWithCost build a object that uses BCrypt with a given cost to implement the Password interface.
+func NewBCryptPasswordWithCost(cost int) Password {
+	return &BCryptPassword{cost: cost}
 }
 
 // BCryptPassword implementation of Password using BCrypt
@@ -42,7 +95,7 @@ type BCryptPassword struct {
 
 // GenerateHashedPassword generates a password with a random salt.
 func (m *BCryptPassword) GenerateHashedPassword(password string) ([]byte, derrors.Error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), m.cost)
 	if err != nil {
 		return nil, derrors.NewInternalError("error hashing the password", err)
 	}
@@ -51,9 +104,120 @@ func (m *BCryptPassword) GenerateHashedPassword(password string) ([]byte, derror
 
 // CompareHashAndPassword compare a hashed password with a specif password.
 func (m *BCryptPassword) CompareHashAndPassword(hashedPassword []byte, password string) derrors.Error {
-	err := bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	return compareHashAndPassword(hashedPassword, password)
+}
+
+// NeedsRehash checks if a hash is not a BCrypt hash with the current cost.
+func (m *BCryptPassword) NeedsRehash(hashedPassword []byte) bool {
+	cost, err := bcrypt.Cost(hashedPassword)
+	return err != nil || cost != m.cost
+}
+
+// Argon2idParams are the parameters of the Argon2id algorithm.
+type Argon2idParams struct {
+	// Memory used by the algorithm in KiB.
+	Memory uint32
+	// Iterations over the memory.
+	Iterations uint32
+	// Parallelism is the number of threads used by the algorithm.
+	Parallelism uint8
+	// SaltLength is the length of the random salt in bytes.
+	SaltLength uint32
+	// KeyLength is the length of the generated key in bytes.
+	KeyLength uint32
+}
+
+// NewDefaultArgon2idParams returns the parameters recommended by RFC 9106 for environments with constrained memory.
+func NewDefaultArgon2idParams() Argon2idParams {
+	return Argon2idParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Argon2idPassword implementation of Password using Argon2id. The hashes use the PHC string format, so they contain
+// the parameters used to generate them:
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<key>
+type Argon2idPassword struct {
+	params Argon2idParams
+}
+
+// NewArgon2idPassword build a object that uses Argon2id with the given parameters to implement the Password interface.
+func NewArgon2idPassword(params Argon2idParams) Password {
+	return &Argon2idPassword{params: params}
+}
+
+// GenerateHashedPassword generates a password with a random salt.
+func (m *Argon2idPassword) GenerateHashedPassword(password string) ([]byte, derrors.Error) {
+	salt := make([]byte, m.params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, derrors.NewInternalError("error generating the salt", err)
+	}
+	key := argon2.IDKey([]byte(password), salt, m.params.Iterations, m.params.Memory, m.params.Parallelism, m.params.KeyLength)
+	hashed := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		m.params.Memory, m.params.Iterations, m.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+	return []byte(hashed), nil
+}
+
+// CompareHashAndPassword compare a hashed password with a specif password.
+func (m *Argon2idPassword) CompareHashAndPassword(hashedPassword []byte, password string) derrors.Error {
+	return compareHashAndPassword(hashedPassword, password)
+}
+
+// NeedsRehash checks if a hash is not an Argon2id hash with the current parameters.
+func (m *Argon2idPassword) NeedsRehash(hashedPassword []byte) bool {
+	params, _, key, err := decodeArgon2id(hashedPassword)
+	if err != nil {
+		return true
+	}
+	return params.Memory != m.params.Memory || params.Iterations != m.params.Iterations ||
+		params.Parallelism != m.params.Parallelism || params.SaltLength != m.params.SaltLength ||
+		uint32(len(key)) != m.params.KeyLength
+}
+
+// decodeArgon2id extracts the parameters, the salt and the key of an Argon2id hash.
+func decodeArgon2id(hashedPassword []byte) (*Argon2idParams, []byte, []byte, derrors.Error) {
+	parts := strings.Split(string(hashedPassword), "$")
+	if len(parts) != 6 || parts[1] != Argon2idHashAlgorithm {
+		return nil, nil, nil, derrors.NewInvalidArgumentError("invalid argon2id hash")
+	}
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return nil, nil, nil, derrors.NewInvalidArgumentError("unsupported argon2id version")
+	}
+	params := &Argon2idParams{}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return nil, nil, nil, derrors.NewInvalidArgumentError("invalid argon2id parameters", err)
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, derrors.NewInvalidArgumentError("invalid argon2id salt", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, derrors.NewInvalidArgumentError("invalid argon2id key", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+// compareArgon2id validates a password against an Argon2id hash.
+func compareArgon2id(hashedPassword []byte, password string) derrors.Error {
+	params, salt, key, err := decodeArgon2id(hashedPassword)
 	if err != nil {
 		return derrors.NewUnauthenticatedError("password is not valid", err)
 	}
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return derrors.NewUnauthenticatedError("password is not valid")
+	}
 	return nil
 }
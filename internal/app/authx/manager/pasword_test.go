@@ -20,11 +20,79 @@ package manager
 import (
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
+// testArgon2idParams are cheap parameters to keep the tests fast.
+var testArgon2idParams = Argon2idParams{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
 var _ = ginkgo.Describe("BCryptPassword", func() {
 	var manager = NewBCryptPassword()
 	PasswordContexts(manager)
+
+	ginkgo.It("uses the configured cost", func() {
+		hashed, err := NewBCryptPasswordWithCost(bcrypt.MinCost).GenerateHashedPassword("123assSSda132131")
+		gomega.Expect(err).To(gomega.Succeed())
+		cost, bErr := bcrypt.Cost(hashed)
+		gomega.Expect(bErr).To(gomega.Succeed())
+		gomega.Expect(cost).To(gomega.Equal(bcrypt.MinCost))
+		gomega.Expect(manager.NeedsRehash(hashed)).To(gomega.BeTrue())
+	})
+})
+
+var _ = ginkgo.Describe("Argon2idPassword", func() {
+	var manager = NewArgon2idPassword(testArgon2idParams)
+	PasswordContexts(manager)
+
+	pass := "123assSSda132131"
+
+	ginkgo.It("generates self-describing hashes", func() {
+		hashed, err := manager.GenerateHashedPassword(pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(strings.HasPrefix(string(hashed), "$argon2id$v=19$m=1024,t=1,p=1$")).To(gomega.BeTrue())
+		gomega.Expect(manager.NeedsRehash(hashed)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("needs to rehash the hashes with other parameters", func() {
+		params := testArgon2idParams
+		params.Iterations = 2
+		hashed, err := NewArgon2idPassword(params).GenerateHashedPassword(pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.NeedsRehash(hashed)).To(gomega.BeTrue())
+		gomega.Expect(manager.CompareHashAndPassword(hashed, pass)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("validates and rehashes bcrypt hashes", func() {
+		hashed, err := NewBCryptPasswordWithCost(bcrypt.MinCost).GenerateHashedPassword(pass)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(manager.NeedsRehash(hashed)).To(gomega.BeTrue())
+		gomega.Expect(manager.CompareHashAndPassword(hashed, pass)).To(gomega.Succeed())
+		gomega.Expect(manager.CompareHashAndPassword(hashed, pass+"wrong")).NotTo(gomega.Succeed())
+	})
+
+	ginkgo.It("rejects malformed hashes", func() {
+		err := manager.CompareHashAndPassword([]byte("$argon2id$v=19$m=1024"), pass)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
+
+var _ = ginkgo.Describe("NewPassword", func() {
+	ginkgo.It("builds the supported algorithms", func() {
+		_, err := NewPassword(BCryptHashAlgorithm, bcrypt.DefaultCost, testArgon2idParams)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = NewPassword(Argon2idHashAlgorithm, bcrypt.DefaultCost, testArgon2idParams)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("rejects invalid configurations", func() {
+		_, err := NewPassword("md5", bcrypt.DefaultCost, testArgon2idParams)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, err = NewPassword(BCryptHashAlgorithm, bcrypt.MaxCost+1, testArgon2idParams)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, err = NewPassword(Argon2idHashAlgorithm, bcrypt.DefaultCost, Argon2idParams{})
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
 })
 
 func PasswordContexts(manager Password) {
@@ -0,0 +1,278 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Rules of the password policy.
+const (
+	MinLengthRule = "min_length"
+	MaxLengthRule = "max_length"
+	UppercaseRule = "uppercase"
+	LowercaseRule = "lowercase"
+	DigitRule     = "digit"
+	SymbolRule    = "symbol"
+	UsernameRule  = "username"
+	DenyListRule  = "deny_list"
+	HistoryRule   = "history"
+)
+
+// BCryptMaxPasswordLength is the number of bytes of a password used by bcrypt. The rest are silently ignored.
+const BCryptMaxPasswordLength = 72
+
+// DefaultMinPasswordLength is the minimum length of the default policy.
+const DefaultMinPasswordLength = 6
+
+// minUsernameCheckLength is the minimum length of a username to be searched in a password.
+const minUsernameCheckLength = 3
+
+// PasswordViolation is a rule of the policy that a password does not satisfy.
+type PasswordViolation struct {
+	Rule    string
+	Message string
+}
+
+// PasswordPolicy contains the rules a password must satisfy.
+type PasswordPolicy struct {
+	// MinLength is the minimum number of characters.
+	MinLength int `json:"min_length"`
+	// MaxLength is the maximum number of bytes. Zero means no limit.
+	MaxLength        int  `json:"max_length,omitempty"`
+	RequireUppercase bool `json:"require_uppercase,omitempty"`
+	RequireLowercase bool `json:"require_lowercase,omitempty"`
+	RequireDigit     bool `json:"require_digit,omitempty"`
+	RequireSymbol    bool `json:"require_symbol,omitempty"`
+	// RejectUsername rejects the passwords that contain the username.
+	RejectUsername bool `json:"reject_username,omitempty"`
+	// UseDenyList rejects the passwords included in the deny list.
+	UseDenyList bool `json:"use_deny_list,omitempty"`
+	// HistorySize is the number of previous passwords, including the current one, that cannot be reused. Zero
+	// allows to reuse any password.
+	HistorySize int `json:"history_size,omitempty"`
+	// MaxAgeDays is the number of days after which a password expires and must be changed. Zero means that
+	// passwords do not expire.
+	MaxAgeDays int `json:"max_age_days,omitempty"`
+}
+
+// NewDefaultPasswordPolicy creates the policy applied if no other policy is configured.
+func NewDefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      DefaultMinPasswordLength,
+		MaxLength:      BCryptMaxPasswordLength,
+		RejectUsername: true,
+		UseDenyList:    true,
+	}
+}
+
+// Validate returns the rules of the policy that a password does not satisfy.
+func (pp *PasswordPolicy) Validate(username string, password string, denyList map[string]bool) []PasswordViolation {
+	violations := make([]PasswordViolation, 0)
+	if len([]rune(password)) < pp.MinLength {
+		violations = append(violations, PasswordViolation{MinLengthRule,
+			fmt.Sprintf("password must be at least %d characters long", pp.MinLength)})
+	}
+	if pp.MaxLength > 0 && len(password) > pp.MaxLength {
+		violations = append(violations, PasswordViolation{MaxLengthRule,
+			fmt.Sprintf("password must not be longer than %d bytes", pp.MaxLength)})
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if pp.RequireUppercase && !upper {
+		violations = append(violations, PasswordViolation{UppercaseRule, "password must contain an uppercase letter"})
+	}
+	if pp.RequireLowercase && !lower {
+		violations = append(violations, PasswordViolation{LowercaseRule, "password must contain a lowercase letter"})
+	}
+	if pp.RequireDigit && !digit {
+		violations = append(violations, PasswordViolation{DigitRule, "password must contain a digit"})
+	}
+	if pp.RequireSymbol && !symbol {
+		violations = append(violations, PasswordViolation{SymbolRule, "password must contain a symbol"})
+	}
+	if pp.RejectUsername && containsUsername(username, password) {
+		violations = append(violations, PasswordViolation{UsernameRule, "password must not contain the username"})
+	}
+	if pp.UseDenyList && denyList[strings.ToLower(password)] {
+		violations = append(violations, PasswordViolation{DenyListRule, "password is too common"})
+	}
+	return violations
+}
+
+// IsExpired checks if a password changed at a given timestamp has exceeded the maximum age. The passwords without
+// a change timestamp, stored before it was recorded, never expire.
+func (pp *PasswordPolicy) IsExpired(passwordChangedAt int64) bool {
+	if pp.MaxAgeDays <= 0 || passwordChangedAt == 0 {
+		return false
+	}
+	maxAge := time.Duration(pp.MaxAgeDays) * 24 * time.Hour
+	return time.Now().After(time.Unix(passwordChangedAt, 0).Add(maxAge))
+}
+
+// containsUsername checks if a password contains the username or, for email addresses, its local part.
+func containsUsername(username string, password string) bool {
+	lowerPassword := strings.ToLower(password)
+	candidates := []string{strings.ToLower(username)}
+	if at := strings.Index(username, "@"); at > 0 {
+		candidates = append(candidates, strings.ToLower(username[:at]))
+	}
+	for _, candidate := range candidates {
+		if len(candidate) >= minUsernameCheckLength && strings.Contains(lowerPassword, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// PasswordPolicies contains the default policy, the policies of the organizations that override it and the deny list
+// of common or breached passwords.
+type PasswordPolicies struct {
+	Default       PasswordPolicy            `json:"default"`
+	Organizations map[string]PasswordPolicy `json:"organizations,omitempty"`
+	denyList      map[string]bool
+}
+
+// NewPasswordPolicies creates a set of policies with a default policy.
+func NewPasswordPolicies(defaultPolicy PasswordPolicy) *PasswordPolicies {
+	return &PasswordPolicies{
+		Default:       defaultPolicy,
+		Organizations: make(map[string]PasswordPolicy, 0),
+		denyList:      make(map[string]bool, 0),
+	}
+}
+
+// LoadPasswordPolicies reads the policies from a JSON file.
+func LoadPasswordPolicies(path string) (*PasswordPolicies, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible read password policy file", err).WithParams(path)
+	}
+	policies := NewPasswordPolicies(NewDefaultPasswordPolicy())
+	err = json.Unmarshal(content, policies)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible unmarshal password policy file", err).WithParams(path)
+	}
+	return policies, nil
+}
+
+// LoadDenyList reads a file with a password per line. Empty lines and lines starting with # are ignored.
+func (p *PasswordPolicies) LoadDenyList(path string) derrors.Error {
+	file, err := os.Open(path)
+	if err != nil {
+		return derrors.NewInvalidArgumentError("impossible read password deny list", err).WithParams(path)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.denyList[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return derrors.NewInvalidArgumentError("impossible read password deny list", err).WithParams(path)
+	}
+	return nil
+}
+
+// AddToDenyList adds passwords to the deny list.
+func (p *PasswordPolicies) AddToDenyList(passwords ...string) {
+	for _, password := range passwords {
+		p.denyList[strings.ToLower(password)] = true
+	}
+}
+
+// Policy returns the policy of an organization.
+func (p *PasswordPolicies) Policy(organizationID string) PasswordPolicy {
+	if policy, exists := p.Organizations[organizationID]; exists {
+		return policy
+	}
+	return p.Default
+}
+
+// Validate returns the rules of the policy of an organization that a password does not satisfy.
+func (p *PasswordPolicies) Validate(organizationID string, username string, password string) []PasswordViolation {
+	policy := p.Policy(organizationID)
+	return policy.Validate(username, password, p.denyList)
+}
+
+// NewPasswordReusedError creates the error returned when a new password matches one of the previous passwords.
+func NewPasswordReusedError(historySize int) *PasswordPolicyError {
+	return NewPasswordPolicyError([]PasswordViolation{{HistoryRule,
+		fmt.Sprintf("password must not match any of the last %d passwords", historySize)}})
+}
+
+// policyError is an alias that allows to embed a derrors.Error without hiding its Error method.
+type policyError = derrors.Error
+
+// PasswordPolicyError is the error returned when a password does not satisfy the policy. It contains the list of
+// violations so they can be reported to the client.
+type PasswordPolicyError struct {
+	policyError
+	Violations []PasswordViolation
+}
+
+// NewPasswordPolicyError creates the error for a list of violations.
+func NewPasswordPolicyError(violations []PasswordViolation) *PasswordPolicyError {
+	rules := make([]string, 0, len(violations))
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return &PasswordPolicyError{
+		policyError: derrors.NewInvalidArgumentError("password does not satisfy the policy").WithParams(rules),
+		Violations:  violations,
+	}
+}
+
+// PasswordExpiredMessage is the message of the error returned when a password has expired.
+const PasswordExpiredMessage = "password expired, must change"
+
+// PasswordExpiredError is the error returned on login when the password has exceeded the maximum age of the policy.
+// The user must change the password before being able to log in.
+type PasswordExpiredError struct {
+	policyError
+	Username string
+}
+
+// NewPasswordExpiredError creates the error for a user whose password has expired.
+func NewPasswordExpiredError(username string) *PasswordExpiredError {
+	return &PasswordExpiredError{
+		policyError: derrors.NewFailedPreconditionError(PasswordExpiredMessage).WithParams(username),
+		Username:    username,
+	}
+}
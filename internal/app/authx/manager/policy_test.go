@@ -0,0 +1,120 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// violatedRules returns the rules of a list of violations.
+func violatedRules(violations []PasswordViolation) []string {
+	rules := make([]string, 0, len(violations))
+	for _, v := range violations {
+		rules = append(rules, v.Rule)
+	}
+	return rules
+}
+
+var _ = ginkgo.Describe("PasswordPolicy", func() {
+
+	ginkgo.It("accepts a password that satisfies the default policy", func() {
+		policy := NewDefaultPasswordPolicy()
+		violations := policy.Validate("user@nalej.com", "MyLittlePassword", nil)
+		gomega.Expect(violations).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("checks the length of the password", func() {
+		policy := NewDefaultPasswordPolicy()
+		gomega.Expect(violatedRules(policy.Validate("u1", "short", nil))).To(gomega.ConsistOf(MinLengthRule))
+		long := strings.Repeat("a", BCryptMaxPasswordLength+1)
+		gomega.Expect(violatedRules(policy.Validate("u1", long, nil))).To(gomega.ConsistOf(MaxLengthRule))
+	})
+
+	ginkgo.It("checks the character classes", func() {
+		policy := PasswordPolicy{RequireUppercase: true, RequireLowercase: true, RequireDigit: true, RequireSymbol: true}
+		gomega.Expect(violatedRules(policy.Validate("u1", "password", nil))).To(
+			gomega.ConsistOf(UppercaseRule, DigitRule, SymbolRule))
+		gomega.Expect(policy.Validate("u1", "Passw0rd!", nil)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("rejects passwords that contain the username", func() {
+		policy := NewDefaultPasswordPolicy()
+		gomega.Expect(violatedRules(policy.Validate("john@nalej.com", "MyJohnPassword", nil))).To(
+			gomega.ConsistOf(UsernameRule))
+		gomega.Expect(violatedRules(policy.Validate("admin", "SuperAdmin2019", nil))).To(
+			gomega.ConsistOf(UsernameRule))
+	})
+
+	ginkgo.It("rejects passwords in the deny list", func() {
+		policy := NewDefaultPasswordPolicy()
+		denyList := map[string]bool{"password123": true}
+		gomega.Expect(violatedRules(policy.Validate("u1", "Password123", denyList))).To(gomega.ConsistOf(DenyListRule))
+	})
+
+	ginkgo.It("checks the age of the password", func() {
+		policy := PasswordPolicy{MaxAgeDays: 30}
+		gomega.Expect(policy.IsExpired(time.Now().Add(-29 * 24 * time.Hour).Unix())).To(gomega.BeFalse())
+		gomega.Expect(policy.IsExpired(time.Now().Add(-31 * 24 * time.Hour).Unix())).To(gomega.BeTrue())
+		gomega.Expect(policy.IsExpired(0)).To(gomega.BeFalse())
+		policy = NewDefaultPasswordPolicy()
+		gomega.Expect(policy.IsExpired(time.Now().Add(-365 * 24 * time.Hour).Unix())).To(gomega.BeFalse())
+	})
+})
+
+var _ = ginkgo.Describe("PasswordPolicies", func() {
+	var dir string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "policies")
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.AfterEach(func() {
+		_ = os.RemoveAll(dir)
+	})
+
+	ginkgo.It("loads the policies of the organizations", func() {
+		path := filepath.Join(dir, "policies.json")
+		content := `{"default": {"min_length": 8}, "organizations": {"o1": {"min_length": 12, "require_digit": true}}}`
+		gomega.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+
+		policies, err := LoadPasswordPolicies(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(policies.Validate("o2", "u1", "MyPassword")).To(gomega.BeEmpty())
+		gomega.Expect(violatedRules(policies.Validate("o1", "u1", "MyPassword"))).To(
+			gomega.ConsistOf(MinLengthRule, DigitRule))
+	})
+
+	ginkgo.It("loads the deny list", func() {
+		path := filepath.Join(dir, "denylist.txt")
+		content := "# common passwords\n123456789\n\nqwertyuiop\n"
+		gomega.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+
+		policies := NewPasswordPolicies(NewDefaultPasswordPolicy())
+		err := policies.LoadDenyList(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(violatedRules(policies.Validate("o1", "u1", "QwertyUIOP"))).To(gomega.ConsistOf(DenyListRule))
+		gomega.Expect(policies.Validate("o1", "u1", "MyPassword")).To(gomega.BeEmpty())
+	})
+})
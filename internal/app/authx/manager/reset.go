@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"github.com/stronker/authx/internal/app/authx/providers/reset_token"
+	"time"
+)
+
+// DefaultResetTokenExpiration is the default time a password reset token can be used.
+const DefaultResetTokenExpiration = time.Hour
+
+// resetTokenLength is the number of random bytes of a password reset token.
+const resetTokenLength = 32
+
+// PasswordReset issues and validates the single-use tokens that allow the users to reset a forgotten password.
+// Only the hash of the tokens is stored. The reset is disabled if there is no notifier.
+type PasswordReset struct {
+	Provider   reset_token.Provider
+	Notifier   Notifier
+	Expiration time.Duration
+}
+
+// NewPasswordReset creates a new PasswordReset.
+func NewPasswordReset(provider reset_token.Provider, notifier Notifier, expiration time.Duration) *PasswordReset {
+	return &PasswordReset{
+		Provider:   provider,
+		Notifier:   notifier,
+		Expiration: expiration,
+	}
+}
+
+// NewPasswordResetMockup creates a PasswordReset with in-memory storage that writes the tokens in the log.
+func NewPasswordResetMockup() *PasswordReset {
+	return NewPasswordReset(reset_token.NewResetTokenMockup(), NewLogNotifier(), DefaultResetTokenExpiration)
+}
+
+// hashSecretToken returns the hash used to store a secret token.
+func hashSecretToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// generateSecretToken returns a random token with a number of bytes encoded as base64url.
+func generateSecretToken(length int) (string, derrors.Error) {
+	random := make([]byte, length)
+	_, err := rand.Read(random)
+	if err != nil {
+		return "", derrors.AsError(err, "cannot generate secret token")
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// Enabled checks if there is a notifier to deliver the tokens.
+func (pr *PasswordReset) Enabled() bool {
+	return pr.Notifier != nil
+}
+
+// Issue generates a new token for a user and sends it with the notifier. The previous tokens of the user are removed
+// so only the last one can be used.
+func (pr *PasswordReset) Issue(username string) derrors.Error {
+	if !pr.Enabled() {
+		return derrors.NewFailedPreconditionError("password reset is disabled, there is no notifier")
+	}
+	token, err := generateSecretToken(resetTokenLength)
+	if err != nil {
+		return err
+	}
+	err = pr.Provider.DeleteByUsername(username)
+	if err != nil {
+		return err
+	}
+	expirationDate := time.Now().Add(pr.Expiration).Unix()
+	err = pr.Provider.Add(entities.NewPasswordResetTokenData(hashSecretToken(token), username, expirationDate))
+	if err != nil {
+		return err
+	}
+	return pr.Notifier.NotifyPasswordReset(&PasswordResetNotification{
+		Username:       username,
+		Token:          token,
+		ExpirationDate: expirationDate,
+	})
+}
+
+// Get returns the stored information of a valid token. Unknown and expired tokens return an Unauthenticated error.
+func (pr *PasswordReset) Get(token string) (*entities.PasswordResetTokenData, derrors.Error) {
+	data, err := pr.Provider.Get(hashSecretToken(token))
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("invalid reset token")
+		}
+		return nil, err
+	}
+	if time.Now().Unix() > data.ExpirationDate {
+		return nil, derrors.NewUnauthenticatedError("reset token expired")
+	}
+	return data, nil
+}
+
+// Consume removes a token so it cannot be used again. The provider deletes the token only if it still exists, so only
+// one of the concurrent uses of a token succeeds and the others get an Unauthenticated error.
+func (pr *PasswordReset) Consume(data *entities.PasswordResetTokenData) derrors.Error {
+	err := pr.Provider.Delete(data.TokenHash)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return derrors.NewUnauthenticatedError("invalid reset token")
+		}
+		return err
+	}
+	return nil
+}
+
+// Revoke removes all the tokens of a user.
+func (pr *PasswordReset) Revoke(username string) derrors.Error {
+	return pr.Provider.DeleteByUsername(username)
+}
+
+// Clean removes all the tokens.
+func (pr *PasswordReset) Clean() derrors.Error {
+	return pr.Provider.Truncate()
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"encoding/json"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/providers/reset_token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// notifications reads the notifications written by a FileNotifier.
+func notifications(path string) []PasswordResetNotification {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	gomega.Expect(err).To(gomega.Succeed())
+	result := make([]PasswordResetNotification, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+		var notification PasswordResetNotification
+		gomega.Expect(json.Unmarshal([]byte(line), &notification)).To(gomega.Succeed())
+		result = append(result, notification)
+	}
+	return result
+}
+
+var _ = ginkgo.Describe("Password reset", func() {
+	var manager = NewAuthxMockup()
+	var dir string
+	var path string
+	userName := "u1"
+	organizationID := "o1"
+	roleID := "r1"
+	pass := "MyLittlePassword"
+	newPass := "MyNewLittlePassword"
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "reset")
+		gomega.Expect(err).To(gomega.Succeed())
+		path = filepath.Join(dir, "notifications")
+		manager.PasswordReset = NewPasswordReset(reset_token.NewResetTokenMockup(), NewFileNotifier(path), time.Minute)
+
+		dErr := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_ORG},
+		})
+		gomega.Expect(dErr).To(gomega.Succeed())
+		dErr = manager.AddBasicCredentials(userName, organizationID, roleID, pass)
+		gomega.Expect(dErr).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reset the password with a notified token", func() {
+		session, err := manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		sent := notifications(path)
+		gomega.Expect(sent).To(gomega.HaveLen(1))
+		gomega.Expect(sent[0].Username).To(gomega.Equal(userName))
+
+		err = manager.ResetPassword(sent[0].Token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+		expectRevoked(manager, session)
+
+		_, err = manager.LoginWithBasicCredentials(userName, pass, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		response, err := manager.LoginWithBasicCredentials(userName, newPass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should not store the token", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		token := notifications(path)[0].Token
+
+		_, err = manager.PasswordReset.Provider.Get(token)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, err = manager.PasswordReset.Provider.Get(hashSecretToken(token))
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should use a token only once", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		token := notifications(path)[0].Token
+
+		err = manager.ResetPassword(token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.ResetPassword(token, newPass+"2")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should invalidate the previous tokens when a new one is issued", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		sent := notifications(path)
+		gomega.Expect(sent).To(gomega.HaveLen(2))
+
+		err = manager.ResetPassword(sent[0].Token, newPass)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		err = manager.ResetPassword(sent[1].Token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should invalidate the tokens when the password changes", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.ChangePassword(userName, pass, newPass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.ResetPassword(notifications(path)[0].Token, newPass+"2")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should be disabled without a notifier", func() {
+		manager.PasswordReset.Notifier = nil
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.FailedPrecondition))
+	})
+
+	ginkgo.It("should reject an expired token", func() {
+		manager.PasswordReset.Expiration = -time.Minute
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.ResetPassword(notifications(path)[0].Token, newPass)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should reject an invalid token", func() {
+		err := manager.ResetPassword("invalid", newPass)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+
+	ginkgo.It("should keep the token if the password does not satisfy the policy", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		token := notifications(path)[0].Token
+
+		err = manager.ResetPassword(token, "short")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, ok := err.(*PasswordPolicyError)
+		gomega.Expect(ok).To(gomega.BeTrue())
+
+		err = manager.ResetPassword(token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should keep the token if the password is in the history", func() {
+		manager.PasswordPolicies.Organizations[organizationID] = PasswordPolicy{MinLength: 8, HistorySize: 2}
+		defer delete(manager.PasswordPolicies.Organizations, organizationID)
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		token := notifications(path)[0].Token
+
+		err = manager.ResetPassword(token, pass)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, ok := err.(*PasswordPolicyError)
+		gomega.Expect(ok).To(gomega.BeTrue())
+
+		err = manager.ResetPassword(token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should accept only one of the concurrent uses of a token", func() {
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		token := notifications(path)[0].Token
+
+		var wg sync.WaitGroup
+		var mutex sync.Mutex
+		succeeded := 0
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if manager.ResetPassword(token, newPass) == nil {
+					mutex.Lock()
+					succeeded++
+					mutex.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		gomega.Expect(succeeded).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should unlock the credentials", func() {
+		for i := 0; i < DefaultMaxUserFailures; i++ {
+			_, _ = manager.LoginWithBasicCredentials(userName, pass+"wrong", nil)
+		}
+		err := manager.RequestPasswordReset(userName)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.ResetPassword(notifications(path)[0].Token, newPass)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		response, err := manager.LoginWithBasicCredentials(userName, newPass, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(response).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should not notify unknown users", func() {
+		err := manager.RequestPasswordReset(userName + "unknown")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(notifications(path)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(os.RemoveAll(dir)).To(gomega.Succeed())
+	})
+})
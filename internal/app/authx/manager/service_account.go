@@ -0,0 +1,202 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/subtle"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+// ServiceAccountSecretPrefix is the prefix of the client secrets of the service accounts.
+const ServiceAccountSecretPrefix = "sas_"
+
+// ClientAssertionMaxLifetime is the maximum time between the creation and the expiration of a client assertion.
+const ClientAssertionMaxLifetime = 5 * time.Minute
+
+// serviceAccountSecretLength is the number of random bytes of a client secret.
+const serviceAccountSecretLength = 32
+
+// parseServiceAccountKey parses a PEM encoded RSA or EC public key.
+func parseServiceAccountKey(publicKey string) (interface{}, derrors.Error) {
+	rsaKey, err := jwt.ParseRSAPublicKeyFromPEM([]byte(publicKey))
+	if err == nil {
+		return rsaKey, nil
+	}
+	ecKey, ecErr := jwt.ParseECPublicKeyFromPEM([]byte(publicKey))
+	if ecErr != nil {
+		return nil, derrors.NewInvalidArgumentError("cannot parse public key", err)
+	}
+	return ecKey, nil
+}
+
+// AddServiceAccount creates a service account of an organization bound to a role. If a PEM encoded public key is
+// given, the service account authenticates with client assertions signed with its private key. Otherwise, a client
+// secret is generated and returned, only its hash is stored.
+func (m *Authx) AddServiceAccount(organizationID string, name string, roleID string, publicKey string) (*entities.ServiceAccountData, string, derrors.Error) {
+	_, err := m.RoleProvider.Get(organizationID, roleID)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := ""
+	secretHash := ""
+	if publicKey != "" {
+		_, err = parseServiceAccountKey(publicKey)
+		if err != nil {
+			return nil, "", err
+		}
+	} else {
+		random, err := generateSecretToken(serviceAccountSecretLength)
+		if err != nil {
+			return nil, "", err
+		}
+		secret = ServiceAccountSecretPrefix + random
+		secretHash = hashSecretToken(secret)
+	}
+	account := entities.NewServiceAccountData(organizationID, token.GenerateUUID(), name, roleID, secretHash, publicKey,
+		time.Now().Unix())
+	err = m.ServiceAccountProvider.Add(account)
+	if err != nil {
+		return nil, "", err
+	}
+	return account, secret, nil
+}
+
+// GetServiceAccount returns a service account of an organization.
+func (m *Authx) GetServiceAccount(organizationID string, serviceAccountID string) (*entities.ServiceAccountData, derrors.Error) {
+	return m.ServiceAccountProvider.Get(organizationID, serviceAccountID)
+}
+
+// ListServiceAccounts returns the service accounts of an organization.
+func (m *Authx) ListServiceAccounts(organizationID string) ([]entities.ServiceAccountData, derrors.Error) {
+	return m.ServiceAccountProvider.List(organizationID)
+}
+
+// UpdateServiceAccount enables, disables or changes the role of a service account. The tokens of the service account
+// are revoked.
+func (m *Authx) UpdateServiceAccount(organizationID string, serviceAccountID string, edit *entities.EditServiceAccountData) derrors.Error {
+	account, err := m.ServiceAccountProvider.Get(organizationID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+	if edit.RoleID != nil {
+		_, err = m.RoleProvider.Get(organizationID, *edit.RoleID)
+		if err != nil {
+			return err
+		}
+		account.RoleID = *edit.RoleID
+	}
+	if edit.Enabled != nil {
+		account.Enabled = *edit.Enabled
+	}
+	err = m.ServiceAccountProvider.Update(account)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeAll(serviceAccountID)
+}
+
+// RemoveServiceAccount deletes a service account and revokes its tokens.
+func (m *Authx) RemoveServiceAccount(organizationID string, serviceAccountID string) derrors.Error {
+	err := m.ServiceAccountProvider.Delete(organizationID, serviceAccountID)
+	if err != nil {
+		return err
+	}
+	return m.Token.RevokeAll(serviceAccountID)
+}
+
+// LoginWithClientCredentials authenticates a service account with its client secret or a client assertion and
+// returns a token of a service principal. The token cannot be refreshed, the service account must authenticate again
+// before it expires.
+func (m *Authx) LoginWithClientCredentials(organizationID string, clientID string, clientSecret string,
+	clientAssertion string, client *entities.ClientInfo) (*pbAuthx.LoginResponse, derrors.Error) {
+	account, err := m.ServiceAccountProvider.Get(organizationID, clientID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil, derrors.NewUnauthenticatedError("invalid client credentials").WithParams(organizationID, clientID)
+		}
+		return nil, err
+	}
+	if account.IsKeyPair() {
+		err = verifyClientAssertion(account, clientAssertion)
+	} else {
+		err = verifyClientSecret(account, clientSecret)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !account.Enabled {
+		return nil, derrors.NewPermissionDeniedError("the service account is disabled").WithParams(organizationID, clientID)
+	}
+	role, err := m.RoleProvider.Get(account.OrganizationID, account.RoleID)
+	if err != nil {
+		return nil, err
+	}
+	personalClaim := token.NewServicePersonalClaim(account.ServiceAccountID, role.Name, role.Primitives, account.OrganizationID)
+	gToken, err := m.Token.Generate(personalClaim, m.expirationDuration, m.secret, client)
+	if err != nil {
+		return nil, err
+	}
+	return &pbAuthx.LoginResponse{Token: gToken.Token}, nil
+}
+
+// verifyClientSecret checks the client secret of a service account.
+func verifyClientSecret(account *entities.ServiceAccountData, clientSecret string) derrors.Error {
+	if clientSecret == "" ||
+		subtle.ConstantTimeCompare([]byte(hashSecretToken(clientSecret)), []byte(account.SecretHash)) != 1 {
+		return derrors.NewUnauthenticatedError("invalid client credentials").
+			WithParams(account.OrganizationID, account.ServiceAccountID)
+	}
+	return nil
+}
+
+// verifyClientAssertion checks a client assertion of a service account. The assertion is a JWT signed with the
+// private key of the service account whose issuer and subject are the service account, whose audience is authx and
+// that expires in less than ClientAssertionMaxLifetime.
+func verifyClientAssertion(account *entities.ServiceAccountData, clientAssertion string) derrors.Error {
+	invalid := derrors.NewUnauthenticatedError("invalid client assertion").
+		WithParams(account.OrganizationID, account.ServiceAccountID)
+	if clientAssertion == "" {
+		return invalid
+	}
+	publicKey, err := parseServiceAccountKey(account.PublicKey)
+	if err != nil {
+		return err
+	}
+	claims := &jwt.StandardClaims{}
+	_, jwtErr := jwt.ParseWithClaims(clientAssertion, claims, func(t *jwt.Token) (interface{}, error) {
+		switch t.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA:
+			return publicKey, nil
+		}
+		return nil, derrors.NewUnauthenticatedError("unexpected signing method").WithParams(t.Method.Alg())
+	})
+	if jwtErr != nil {
+		return derrors.NewUnauthenticatedError("invalid client assertion", jwtErr).
+			WithParams(account.OrganizationID, account.ServiceAccountID)
+	}
+	if claims.Issuer != account.ServiceAccountID || claims.Subject != account.ServiceAccountID ||
+		!claims.VerifyAudience(Issuer, true) || claims.ExpiresAt == 0 ||
+		time.Unix(claims.ExpiresAt, 0).Sub(time.Now()) > ClientAssertionMaxLifetime {
+		return invalid
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+// clientAssertion signs a client assertion with the given claims.
+func clientAssertion(key *ecdsa.PrivateKey, claims jwt.StandardClaims) string {
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(key)
+	gomega.Expect(err).To(gomega.Succeed())
+	return signed
+}
+
+var _ = ginkgo.Describe("Service accounts", func() {
+	var manager = NewAuthxMockup()
+	organizationID := "o1"
+	roleID := "r1"
+
+	ginkgo.BeforeEach(func() {
+		err := manager.AddRole(&pbAuthx.Role{
+			OrganizationId: organizationID,
+			RoleId:         roleID,
+			Name:           "rName1",
+			Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_APPS},
+		})
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.Context("with a client secret", func() {
+		var account *entities.ServiceAccountData
+		var secret string
+
+		ginkgo.BeforeEach(func() {
+			var err derrors.Error
+			account, secret, err = manager.AddServiceAccount(organizationID, "deployer", roleID, "")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(secret).NotTo(gomega.BeEmpty())
+			gomega.Expect(account.SecretHash).NotTo(gomega.Equal(secret))
+		})
+
+		ginkgo.It("should issue a token of a service principal", func() {
+			response, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(response.RefreshToken).To(gomega.BeEmpty())
+			claim := tokenClaim(response.Token)
+			gomega.Expect(claim.IsServicePrincipal()).To(gomega.BeTrue())
+			gomega.Expect(claim.UserID).To(gomega.Equal(account.ServiceAccountID))
+			gomega.Expect(claim.OrganizationID).To(gomega.Equal(organizationID))
+			gomega.Expect(claim.Primitives).To(gomega.Equal([]string{pbAuthx.AccessPrimitive_APPS.String()}))
+		})
+
+		ginkgo.It("should reject invalid secrets", func() {
+			_, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret+"x", "", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			_, err = manager.LoginWithClientCredentials("o2", account.ServiceAccountID, secret, "", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+
+		ginkgo.It("should reject disabled service accounts", func() {
+			err := manager.UpdateServiceAccount(organizationID, account.ServiceAccountID,
+				entities.NewEditServiceAccountData().WithEnabled(false))
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.PermissionDenied))
+		})
+
+		ginkgo.It("should bind the service account to another role", func() {
+			err := manager.AddRole(&pbAuthx.Role{
+				OrganizationId: organizationID,
+				RoleId:         "r2",
+				Name:           "rName2",
+				Primitives:     []pbAuthx.AccessPrimitive{pbAuthx.AccessPrimitive_RESOURCES},
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			err = manager.UpdateServiceAccount(organizationID, account.ServiceAccountID,
+				entities.NewEditServiceAccountData().WithRoleID("r2"))
+			gomega.Expect(err).To(gomega.Succeed())
+			response, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(tokenClaim(response.Token).Primitives).To(gomega.Equal([]string{pbAuthx.AccessPrimitive_RESOURCES.String()}))
+
+			err = manager.UpdateServiceAccount(organizationID, account.ServiceAccountID,
+				entities.NewEditServiceAccountData().WithRoleID("r3"))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+
+		ginkgo.It("should list and remove the service accounts", func() {
+			list, err := manager.ListServiceAccounts(organizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.HaveLen(1))
+			err = manager.RemoveServiceAccount(organizationID, account.ServiceAccountID)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, secret, "", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+	})
+
+	ginkgo.Context("with a key pair", func() {
+		var account *entities.ServiceAccountData
+		var key *ecdsa.PrivateKey
+
+		ginkgo.BeforeEach(func() {
+			var err error
+			key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			gomega.Expect(err).To(gomega.Succeed())
+			der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+			var secret string
+			var dErr derrors.Error
+			account, secret, dErr = manager.AddServiceAccount(organizationID, "monitor", roleID, publicKey)
+			gomega.Expect(dErr).To(gomega.Succeed())
+			gomega.Expect(secret).To(gomega.BeEmpty())
+			gomega.Expect(account.IsKeyPair()).To(gomega.BeTrue())
+		})
+
+		validClaims := func() jwt.StandardClaims {
+			return jwt.StandardClaims{
+				Issuer:    account.ServiceAccountID,
+				Subject:   account.ServiceAccountID,
+				Audience:  Issuer,
+				IssuedAt:  time.Now().Unix(),
+				ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			}
+		}
+
+		ginkgo.It("should issue a token with a valid assertion", func() {
+			response, err := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, "",
+				clientAssertion(key, validClaims()), nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			claim := tokenClaim(response.Token)
+			gomega.Expect(claim.GetPrincipalType()).To(gomega.Equal(token.ServicePrincipal))
+
+			_, err = manager.RefreshToken(response.Token, "refresh", nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+
+		ginkgo.It("should reject invalid assertions", func() {
+			otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			gomega.Expect(err).To(gomega.Succeed())
+			wrongAudience := validClaims()
+			wrongAudience.Audience = "other"
+			wrongSubject := validClaims()
+			wrongSubject.Subject = "sa2"
+			tooLong := validClaims()
+			tooLong.ExpiresAt = time.Now().Add(time.Hour).Unix()
+			expired := validClaims()
+			expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+
+			assertions := []string{"", clientAssertion(otherKey, validClaims()), clientAssertion(key, wrongAudience),
+				clientAssertion(key, wrongSubject), clientAssertion(key, tooLong), clientAssertion(key, expired)}
+			for _, assertion := range assertions {
+				_, dErr := manager.LoginWithClientCredentials(organizationID, account.ServiceAccountID, "", assertion, nil)
+				gomega.Expect(dErr).To(gomega.HaveOccurred())
+				gomega.Expect(dErr.Type()).To(gomega.Equal(derrors.Unauthenticated))
+			}
+		})
+	})
+
+	ginkgo.It("should reject invalid public keys", func() {
+		_, _, err := manager.AddServiceAccount(organizationID, "monitor", roleID, "invalid")
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.InvalidArgument))
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
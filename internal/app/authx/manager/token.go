@@ -18,11 +18,14 @@
 package manager
 
 import (
+	"bytes"
+	"crypto/subtle"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nalej/authx/pkg/token"
 	"github.com/nalej/derrors"
 	"github.com/rs/zerolog/log"
 	"github.com/stronker/authx/internal/app/authx/entities"
+	"github.com/stronker/authx/internal/app/authx/providers/revocation"
 	nalejToken "github.com/stronker/authx/internal/app/authx/providers/token"
 	"time"
 )
@@ -45,50 +48,124 @@ func NewGeneratedToken(token string, refreshToken string) *GeneratedToken {
 
 // Token is a interface manages the business logic of tokens.
 type Token interface {
-	// Generate a new token with the personal claim.
+	// Generate a new token with the personal claim. The token starts a new session of the client.
 	Generate(personalClaim *token.PersonalClaim, expirationPeriod time.Duration,
-		secret string) (*GeneratedToken, derrors.Error)
-	// Refresh renew an old token.
-	Refresh(oldToken string, refreshToken string,
-		expirationPeriod time.Duration, secret string) (*GeneratedToken, derrors.Error)
+		secret string, client *entities.ClientInfo) (*GeneratedToken, derrors.Error)
+	// Refresh renew an old token. The new token is generated with the personal claim that contains the current
+	// information of the user.
+	Refresh(oldToken string, refreshToken string, personalClaim *token.PersonalClaim,
+		expirationPeriod time.Duration, secret string, client *entities.ClientInfo) (*GeneratedToken, derrors.Error)
+	// Sign generates a token with a claim without starting a session, so the token cannot be refreshed.
+	Sign(claim *token.Claim, secret string) (string, derrors.Error)
+	// SignInSession generates a token with a claim that belongs to the session of another token of the same user.
+	// The token is revoked with the session, but it cannot be refreshed.
+	SignInSession(claim *token.Claim, sessionTokenID string, secret string) (string, derrors.Error)
+	// SignClaims generates a token with a set of claims that is not a user token, such as an MFA challenge.
+	SignClaims(claims jwt.Claims, secret string) (string, derrors.Error)
+	// ParseClaims validates a token generated with SignClaims and fills its claims.
+	ParseClaims(tokenString string, claims jwt.Claims, secret string) derrors.Error
+	// GetTokenInfo returns the claim of a valid token.
+	GetTokenInfo(tokenInfo string, secret string) (*token.Claim, derrors.Error)
+	// Revoke invalidates a token and its refresh token before the token expires.
+	Revoke(token string, secret string) derrors.Error
+	// IsRevoked checks if a token has been revoked.
+	IsRevoked(tokenID string) (bool, derrors.Error)
+	// RevokeAll invalidates all the tokens and refresh tokens of a user.
+	RevokeAll(username string) derrors.Error
+	// RevokeBySource invalidates the tokens and refresh tokens of a user obtained with a credential such as a
+	// personal access token.
+	RevokeBySource(username string, tokenSourceID string) derrors.Error
+	// ListSessions returns the sessions of a user that are still valid.
+	ListSessions(username string) ([]entities.Session, derrors.Error)
+	// TerminateSession invalidates the session a token belongs to.
+	TerminateSession(username string, tokenID string) derrors.Error
 	// Clean remove all the data from the providers.
 	Clean() derrors.Error
 }
 
 // JWTToken is an implementation of token using JWT.
 type JWTToken struct {
-	TokenProvider nalejToken.Token
-	Password      Password
+	TokenProvider      nalejToken.Token
+	RevocationProvider revocation.Provider
+	Password           Password
+	// Events receives the security events such as the reuse of a refresh token.
+	Events SecurityEventListener
+	// KeyRing contains the keys used to sign and verify the tokens. If it is not set, the tokens are signed using
+	// the shared secret.
+	KeyRing *KeyRing
+	// Audience is included in the tokens so they are only accepted by the services of an environment. If it is
+	// empty, the tokens have no audience.
+	Audience string
 }
 
 // NewJWTToken create a new instance of JWTToken
-func NewJWTToken(tokenProvider nalejToken.Token, password Password) Token {
-	return &JWTToken{TokenProvider: tokenProvider, Password: password}
+func NewJWTToken(tokenProvider nalejToken.Token, revocationProvider revocation.Provider, password Password) Token {
+	return &JWTToken{TokenProvider: tokenProvider, RevocationProvider: revocationProvider, Password: password,
+		Events: NewLogSecurityEventListener()}
 	
 }
 
+// NewJWTTokenWithSigningKey create a new instance of JWTToken that signs the tokens with a given key.
+func NewJWTTokenWithSigningKey(tokenProvider nalejToken.Token, revocationProvider revocation.Provider,
+	password Password, signingKey *token.SigningKey) Token {
+	return NewJWTTokenWithKeyRing(tokenProvider, revocationProvider, password, NewKeyRingWithKey(signingKey))
+}
+
+// NewJWTTokenWithKeyRing create a new instance of JWTToken that signs the tokens with the active key of a key ring.
+func NewJWTTokenWithKeyRing(tokenProvider nalejToken.Token, revocationProvider revocation.Provider,
+	password Password, keyRing *KeyRing) Token {
+	return NewJWTTokenWithAudience(tokenProvider, revocationProvider, password, keyRing, "")
+}
+
+// NewJWTTokenWithAudience create a new instance of JWTToken that signs the tokens with the active key of a key ring
+// and limits them to an audience.
+func NewJWTTokenWithAudience(tokenProvider nalejToken.Token, revocationProvider revocation.Provider,
+	password Password, keyRing *KeyRing, audience string) Token {
+	return &JWTToken{TokenProvider: tokenProvider, RevocationProvider: revocationProvider, Password: password,
+		KeyRing: keyRing, Audience: audience, Events: NewLogSecurityEventListener()}
+}
+
 // NewJWTTokenMockup create a new mockup of JWTToken
 func NewJWTTokenMockup() Token {
-	return NewJWTToken(nalejToken.NewTokenMockup(), NewBCryptPassword())
+	return NewJWTToken(nalejToken.NewTokenMockup(), revocation.NewRevocationMockup(), NewBCryptPassword())
 }
 
-// Generate a new JWT token with the personal claim.
+// Generate a new JWT token with the personal claim. The refresh token starts a new family.
 func (m *JWTToken) Generate(personalClaim *token.PersonalClaim, expirationPeriod time.Duration,
-	secret string) (*GeneratedToken, derrors.Error) {
+	secret string, client *entities.ClientInfo) (*GeneratedToken, derrors.Error) {
+	return m.generate(personalClaim, expirationPeriod, secret, nil, client)
+}
+
+// generate a new JWT token. If there is a previous token, the new one continues its session and refresh token
+// family.
+func (m *JWTToken) generate(personalClaim *token.PersonalClaim, expirationPeriod time.Duration,
+	secret string, previous *entities.TokenData, client *entities.ClientInfo) (*GeneratedToken, derrors.Error) {
 	
-	claim := token.NewClaim(*personalClaim, Issuer, time.Now(), expirationPeriod)
-	t := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	tokenString, err := t.SignedString([]byte(secret))
+	claim := token.NewClaimWithAudience(*personalClaim, Issuer, m.Audience, time.Now(), expirationPeriod)
+	key, err := m.signingKey(secret)
+	if err != nil {
+		return nil, err
+	}
+	tokenString, err := key.Sign(claim)
 	if err != nil {
 		return nil, derrors.NewInternalError("impossible generate JWT token", err)
 	}
 	
 	refreshToken := token.GenerateUUID()
-	hashedRefreshToken, err := m.Password.GenerateHashedPassword(refreshToken)
-	if err != nil {
-		return nil, derrors.NewInternalError("impossible generate RefreshToken", err)
+	hashedRefreshToken := hashRefreshToken(refreshToken)
+	var tokenData *entities.TokenData
+	if previous == nil {
+		tokenData = entities.NewTokenDataInFamily(claim.UserID, claim.Id, token.GenerateUUID(), hashedRefreshToken, claim.ExpiresAt)
+		tokenData.IssuedAt = claim.IssuedAt
+	} else {
+		tokenData = entities.NewTokenDataInFamily(claim.UserID, claim.Id, previous.FamilyID, hashedRefreshToken, claim.ExpiresAt)
+		tokenData.IssuedAt = previous.IssuedAt
+		tokenData.LastRefresh = claim.IssuedAt
+		tokenData.ClientIP = previous.ClientIP
+		tokenData.UserAgent = previous.UserAgent
 	}
-	tokenData := entities.NewTokenData(claim.UserID, claim.Id, hashedRefreshToken, claim.ExpiresAt)
+	tokenData.SetClient(client)
+	tokenData.TokenSourceID = personalClaim.TokenSourceID
 	err = m.TokenProvider.Add(tokenData)
 	
 	if err != nil {
@@ -98,24 +175,29 @@ func (m *JWTToken) Generate(personalClaim *token.PersonalClaim, expirationPeriod
 	return gToken, nil
 }
 
-// Refresh renew an old token.
-func (m *JWTToken) Refresh(oldToken string, refreshToken string,
-	expirationPeriod time.Duration, secret string) (*GeneratedToken, derrors.Error) {
+// Refresh renew an old token. The refresh token is consumed and the new one belongs to the same family. If a
+// consumed refresh token is presented again, the whole family is revoked.
+func (m *JWTToken) Refresh(oldToken string, refreshToken string, personalClaim *token.PersonalClaim,
+	expirationPeriod time.Duration, secret string, client *entities.ClientInfo) (*GeneratedToken, derrors.Error) {
 	
-	tk, jwtErr := jwt.ParseWithClaims(oldToken, &token.Claim{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-	if jwtErr != nil {
-		return nil, derrors.NewUnauthenticatedError("impossible recover RefreshToken", jwtErr)
+	cl, err := m.GetTokenInfo(oldToken, secret)
+	if err != nil {
+		return nil, err
 	}
-	
-	cl, ok := tk.Claims.(*token.Claim)
-	if !ok {
-		return nil, derrors.NewUnauthenticatedError("impossible recover token")
+	if personalClaim.UserID != cl.UserID {
+		return nil, derrors.NewUnauthenticatedError("the token belongs to a different user").WithParams(cl.UserID)
 	}
 	username := cl.UserID
 	tokenID := cl.Id
 	
+	revoked, err := m.IsRevoked(tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, derrors.NewUnauthenticatedError("the token has been revoked")
+	}
+	
 	tokenData, err := m.TokenProvider.Get(username, tokenID)
 	if err != nil {
 		return nil, derrors.NewUnauthenticatedError("impossible recover RefreshToken", err)
@@ -125,25 +207,320 @@ func (m *JWTToken) Refresh(oldToken string, refreshToken string,
 		return nil, derrors.NewUnauthenticatedError("the refresh token is expired")
 	}
 	
-	err = m.Password.CompareHashAndPassword(tokenData.RefreshToken, refreshToken)
+	err = m.compareRefreshToken(tokenData.RefreshToken, refreshToken)
 	if err != nil {
-		return nil, derrors.NewUnauthenticatedError("the refresh token is not valid", err)
+		return nil, err
 	}
 	
-	gt, err := m.Generate(&cl.PersonalClaim, expirationPeriod, secret)
+	// The token is consumed with a conditional update, so a token presented concurrently twice is detected as reused.
+	consumed, err := m.TokenProvider.Consume(username, tokenID)
 	if err != nil {
-		return nil, derrors.NewInternalError("impossible create new token", err)
+		return nil, derrors.NewInternalError("impossible consume refresh token", err)
+	}
+	if !consumed {
+		m.Events.Notify(NewSecurityEvent(RefreshTokenReuse, username, tokenData.FamilyID, tokenID))
+		err = m.revokeFamily(username, tokenData.FamilyID)
+		if err != nil {
+			log.Warn().Str("trace", err.DebugReport()).Msg("impossible revoke refresh token family")
+		}
+		return nil, derrors.NewUnauthenticatedError("the refresh token has already been used")
 	}
 	
-	err = m.TokenProvider.Delete(username, tokenID)
+	gt, err := m.generate(personalClaim, expirationPeriod, secret, tokenData, client)
 	if err != nil {
-		log.Warn().Err(err).Msg("impossible delete refresh token")
+		return nil, derrors.NewInternalError("impossible create new token", err)
 	}
 	
 	return gt, nil
 }
 
+// hashRefreshToken returns the hash used to store a refresh token. The refresh tokens are random, so a fast hash is
+// enough and the refresh requests cannot be used to exhaust the resources of the service.
+func hashRefreshToken(refreshToken string) []byte {
+	return []byte(hashSecretToken(refreshToken))
+}
+
+// compareRefreshToken checks a refresh token against its stored hash. The hashes generated with the password manager
+// by previous versions are still accepted until they expire.
+func (m *JWTToken) compareRefreshToken(hashedRefreshToken []byte, refreshToken string) derrors.Error {
+	if bytes.HasPrefix(hashedRefreshToken, []byte("$")) {
+		err := m.Password.CompareHashAndPassword(hashedRefreshToken, refreshToken)
+		if err != nil {
+			return derrors.NewUnauthenticatedError("the refresh token is not valid", err)
+		}
+		return nil
+	}
+	if subtle.ConstantTimeCompare(hashedRefreshToken, hashRefreshToken(refreshToken)) != 1 {
+		return derrors.NewUnauthenticatedError("the refresh token is not valid")
+	}
+	return nil
+}
+
+// Sign generates a JWT token with a claim. No refresh token is stored for the token. The claims without audience
+// are limited to the audience of the environment.
+func (m *JWTToken) Sign(claim *token.Claim, secret string) (string, derrors.Error) {
+	if claim.Audience == "" {
+		claim.Audience = m.Audience
+	}
+	key, err := m.signingKey(secret)
+	if err != nil {
+		return "", err
+	}
+	tokenString, err := key.Sign(claim)
+	if err != nil {
+		return "", derrors.NewInternalError("impossible generate JWT token", err)
+	}
+	return tokenString, nil
+}
+
+// SignInSession generates a JWT token with a claim in the session of another token. The token is stored without a
+// refresh token in the refresh token family of the session, so it is revoked when the session is terminated, the user
+// logs out or all the tokens of the user are revoked.
+func (m *JWTToken) SignInSession(claim *token.Claim, sessionTokenID string, secret string) (string, derrors.Error) {
+	session, err := m.TokenProvider.Get(claim.UserID, sessionTokenID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return "", derrors.NewUnauthenticatedError("the session has finished").WithParams(claim.UserID, sessionTokenID)
+		}
+		return "", derrors.NewInternalError("impossible recover session", err)
+	}
+	tokenString, err := m.Sign(claim, secret)
+	if err != nil {
+		return "", err
+	}
+	tokenData := entities.NewTokenDataInFamily(claim.UserID, claim.Id, session.FamilyID, nil, claim.ExpiresAt)
+	// The token is never listed as a session nor refreshed.
+	tokenData.Consumed = true
+	tokenData.IssuedAt = session.IssuedAt
+	tokenData.LastRefresh = claim.IssuedAt
+	tokenData.ClientIP = session.ClientIP
+	tokenData.UserAgent = session.UserAgent
+	tokenData.TokenSourceID = session.TokenSourceID
+	err = m.TokenProvider.Add(tokenData)
+	if err != nil {
+		return "", derrors.NewInternalError("impossible store token", err)
+	}
+	return tokenString, nil
+}
+// SignClaims generates a token with a set of claims signed with the active key.
+func (m *JWTToken) SignClaims(claims jwt.Claims, secret string) (string, derrors.Error) {
+	key, err := m.signingKey(secret)
+	if err != nil {
+		return "", err
+	}
+	return key.Sign(claims)
+}
+
+// ParseClaims validates the signature and the standard claims of a token and fills its claims.
+func (m *JWTToken) ParseClaims(tokenString string, claims jwt.Claims, secret string) derrors.Error {
+	_, jwtErr := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
+		return m.verificationKey(t, secret)
+	})
+	if jwtErr != nil {
+		return derrors.NewUnauthenticatedError("impossible recover token", jwtErr)
+	}
+	return nil
+}
+
+// GetTokenInfo returns the claim of a valid token. The MFA challenges are signed with the same keys, but they are not
+// user tokens and are rejected.
+func (m *JWTToken) GetTokenInfo(tokenInfo string, secret string) (*token.Claim, derrors.Error) {
+	tk, jwtErr := jwt.ParseWithClaims(tokenInfo, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+		return m.verificationKey(t, secret)
+	})
+	if jwtErr != nil {
+		return nil, derrors.NewUnauthenticatedError("impossible recover token", jwtErr)
+	}
+	
+	cl, ok := tk.Claims.(*token.Claim)
+	if !ok || cl.Audience == MFAChallengeAudience {
+		return nil, derrors.NewUnauthenticatedError("impossible recover token")
+	}
+	return cl, nil
+}
+
+// revokeFamily invalidates all the tokens of a refresh token family.
+func (m *JWTToken) revokeFamily(username string, familyID string) derrors.Error {
+	tokens, err := m.TokenProvider.List(username)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.FamilyID != familyID {
+			continue
+		}
+		err = m.revokeTokenData(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revokeTokenData adds a token to the revocation list and removes its refresh token.
+func (m *JWTToken) revokeTokenData(t entities.TokenData) derrors.Error {
+	err := m.RevocationProvider.Add(entities.NewRevokedTokenData(t.TokenID, t.Username, t.ExpirationDate))
+	if err != nil {
+		return derrors.NewInternalError("impossible revoke token", err)
+	}
+	err = m.TokenProvider.Delete(t.Username, t.TokenID)
+	if err != nil && err.Type() != derrors.NotFound {
+		return derrors.NewInternalError("impossible delete refresh token", err)
+	}
+	return nil
+}
+
+// Revoke invalidates a token and its refresh token family. The token identifiers are kept in the revocation list
+// until the tokens expire.
+func (m *JWTToken) Revoke(oldToken string, secret string) derrors.Error {
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	tk, jwtErr := parser.ParseWithClaims(oldToken, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+		return m.verificationKey(t, secret)
+	})
+	if jwtErr != nil {
+		return derrors.NewUnauthenticatedError("impossible recover token", jwtErr)
+	}
+	cl, ok := tk.Claims.(*token.Claim)
+	if !ok {
+		return derrors.NewUnauthenticatedError("impossible recover token")
+	}
+	if cl.ExpiresAt < time.Now().Unix() {
+		// An expired token is already rejected.
+		return nil
+	}
+	
+	err := m.RevocationProvider.Add(entities.NewRevokedTokenData(cl.Id, cl.UserID, cl.ExpiresAt))
+	if err != nil {
+		return derrors.NewInternalError("impossible revoke token", err)
+	}
+	tokenData, err := m.TokenProvider.Get(cl.UserID, cl.Id)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return nil
+		}
+		return derrors.NewInternalError("impossible recover refresh token", err)
+	}
+	if tokenData.FamilyID != "" {
+		// The rest of tokens of the session are revoked too.
+		return m.revokeFamily(cl.UserID, tokenData.FamilyID)
+	}
+	err = m.TokenProvider.Delete(cl.UserID, cl.Id)
+	if err != nil && err.Type() != derrors.NotFound {
+		return derrors.NewInternalError("impossible delete refresh token", err)
+	}
+	return nil
+}
+
+// RevokeAll invalidates all the tokens of a user. The refresh token entries identify the tokens that have been issued
+// and are not expired yet.
+func (m *JWTToken) RevokeAll(username string) derrors.Error {
+	tokens, err := m.TokenProvider.List(username)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, t := range tokens {
+		if t.ExpirationDate < now {
+			continue
+		}
+		err = m.RevocationProvider.Add(entities.NewRevokedTokenData(t.TokenID, username, t.ExpirationDate))
+		if err != nil {
+			return derrors.NewInternalError("impossible revoke token", err)
+		}
+	}
+	return m.TokenProvider.DeleteByUsername(username)
+}
+
+// RevokeBySource invalidates the tokens of a user whose session was started with a credential.
+func (m *JWTToken) RevokeBySource(username string, tokenSourceID string) derrors.Error {
+	tokens, err := m.TokenProvider.List(username)
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.TokenSourceID != tokenSourceID {
+			continue
+		}
+		err = m.revokeTokenData(t)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListSessions returns the sessions of a user. Each session is represented by the token of its refresh token family
+// that has not been consumed yet.
+func (m *JWTToken) ListSessions(username string) ([]entities.Session, derrors.Error) {
+	tokens, err := m.TokenProvider.List(username)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+	sessions := make([]entities.Session, 0, len(tokens))
+	for _, t := range tokens {
+		if t.Consumed || t.ExpirationDate < now {
+			continue
+		}
+		sessions = append(sessions, *entities.NewSessionFromTokenData(t))
+	}
+	return sessions, nil
+}
+
+// TerminateSession invalidates the tokens of the session a token belongs to.
+func (m *JWTToken) TerminateSession(username string, tokenID string) derrors.Error {
+	tokenData, err := m.TokenProvider.Get(username, tokenID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return derrors.NewNotFoundError("session").WithParams(username, tokenID)
+		}
+		return err
+	}
+	if tokenData.FamilyID != "" {
+		return m.revokeFamily(username, tokenData.FamilyID)
+	}
+	return m.revokeTokenData(*tokenData)
+}
+
+// IsRevoked checks if a token has been revoked.
+func (m *JWTToken) IsRevoked(tokenID string) (bool, derrors.Error) {
+	revoked, err := m.RevocationProvider.Exist(tokenID)
+	if err != nil {
+		return false, err
+	}
+	return *revoked, nil
+}
+
+// signingKey returns the key used to sign the tokens.
+func (m *JWTToken) signingKey(secret string) (*token.SigningKey, derrors.Error) {
+	if m.KeyRing != nil {
+		return m.KeyRing.ActiveKey()
+	}
+	return token.NewHMACSigningKey("", secret), nil
+}
+
+// verificationKey returns the key that verifies a token. The key is selected by the kid header so the tokens signed
+// with a key that is being rotated are still accepted.
+func (m *JWTToken) verificationKey(t *jwt.Token, secret string) (interface{}, error) {
+	if m.KeyRing != nil {
+		kid, _ := t.Header[token.KeyIDHeader].(string)
+		key, err := m.KeyRing.VerificationKey(kid, t.Method.Alg())
+		if err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if t.Method.Alg() != jwt.SigningMethodHS256.Alg() {
+		return nil, derrors.NewUnauthenticatedError("unexpected signing method").WithParams(t.Method.Alg())
+	}
+	return []byte(secret), nil
+}
+
 // Clean remove all the data from the providers.
 func (m *JWTToken) Clean() derrors.Error {
-	return m.TokenProvider.Truncate()
+	err := m.TokenProvider.Truncate()
+	if err != nil {
+		return err
+	}
+	return m.RevocationProvider.Truncate()
 }
@@ -18,10 +18,15 @@
 package manager
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nalej/authx/pkg/token"
 	"github.com/onsi/ginkgo"
 	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/providers/revocation"
+	nalejToken "github.com/stronker/authx/internal/app/authx/providers/token"
+	"sync"
 	"time"
 )
 
@@ -30,31 +35,259 @@ var _ = ginkgo.Describe("BCryptPassword", func() {
 	TokenContexts(manager)
 })
 
+var _ = ginkgo.Describe("JWTToken with a RSA signing key", func() {
+	privateKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	signingKey := token.NewRSASigningKey("k1", privateKey)
+	var manager = NewJWTTokenWithSigningKey(nalejToken.NewTokenMockup(), revocation.NewRevocationMockup(), NewBCryptPassword(), signingKey)
+	claim := token.NewPersonalClaim("u1", "r1", []string{"p1", "p2"}, "o1")
+	expirationPeriod, _ := time.ParseDuration("10m")
+
+	ginkgo.It("can generate a token verifiable with the public key", func() {
+		gT, err := manager.Generate(claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		tk, jwtErr := jwt.ParseWithClaims(gT.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return &privateKey.PublicKey, nil
+		})
+		gomega.Expect(jwtErr).To(gomega.Succeed())
+		gomega.Expect(tk.Method).To(gomega.Equal(jwt.SigningMethodRS256))
+		gomega.Expect(tk.Header[token.KeyIDHeader]).To(gomega.Equal("k1"))
+	})
+
+	ginkgo.It("can refresh a token", func() {
+		gT, err := manager.Generate(claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, "", nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(gTNew).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("must reject a token signed with a shared secret", func() {
+		secret := "myLittleSecret112131"
+		gT, err := NewJWTTokenMockup().Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(gTNew).To(gomega.BeNil())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("JWTToken with an audience", func() {
+	var manager = NewJWTTokenMockup()
+	manager.(*JWTToken).Audience = "production"
+	claim := token.NewPersonalClaim("u1", "r1", []string{"p1", "p2"}, "o1")
+	expirationPeriod, _ := time.ParseDuration("10m")
+	secret := "myLittleSecret112131"
+
+	ginkgo.It("should include the audience in the generated tokens", func() {
+		gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		info, err := manager.GetTokenInfo(gT.Token, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(info.Audience).To(gomega.Equal("production"))
+
+		gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		info, err = manager.GetTokenInfo(gTNew.Token, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(info.Audience).To(gomega.Equal("production"))
+	})
+
+	ginkgo.It("should keep the audience of a signed claim", func() {
+		signed, err := manager.Sign(token.NewClaim(*claim, Issuer, time.Now(), expirationPeriod), secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		info, err := manager.GetTokenInfo(signed, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(info.Audience).To(gomega.Equal("production"))
+
+		limited := token.NewClaimWithAudience(*claim, Issuer, "backend", time.Now(), expirationPeriod)
+		signed, err = manager.Sign(limited, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		info, err = manager.GetTokenInfo(signed, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(info.Audience).To(gomega.Equal("backend"))
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+// securityEventsMockup stores the received events.
+type securityEventsMockup struct {
+	sync.Mutex
+	events []*SecurityEvent
+}
+
+func (s *securityEventsMockup) Notify(event *SecurityEvent) {
+	s.Lock()
+	defer s.Unlock()
+	s.events = append(s.events, event)
+}
+
+var _ = ginkgo.Describe("JWTToken refresh token families", func() {
+	var manager = NewJWTTokenMockup()
+	var events *securityEventsMockup
+	claim := token.NewPersonalClaim("u1", "r1", []string{"p1", "p2"}, "o1")
+	expirationPeriod, _ := time.ParseDuration("10m")
+	secret := "myLittleSecret112131"
+
+	ginkgo.BeforeEach(func() {
+		events = &securityEventsMockup{}
+		manager.(*JWTToken).Events = events
+	})
+
+	ginkgo.It("revokes the family when a refresh token is reused", func() {
+		first, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		other, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		second, err := manager.Refresh(first.Token, first.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		replay, err := manager.Refresh(first.Token, first.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(replay).To(gomega.BeNil())
+		gomega.Expect(events.events).To(gomega.HaveLen(1))
+		gomega.Expect(events.events[0].Type).To(gomega.Equal(RefreshTokenReuse))
+		gomega.Expect(events.events[0].Username).To(gomega.Equal("u1"))
+
+		third, err := manager.Refresh(second.Token, second.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(third).To(gomega.BeNil())
+
+		tk, jwtErr := jwt.ParseWithClaims(second.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		gomega.Expect(jwtErr).To(gomega.Succeed())
+		revoked, err := manager.IsRevoked(tk.Claims.(*token.Claim).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeTrue())
+
+		refreshed, err := manager.Refresh(other.Token, other.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(refreshed).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("accepts only one of the concurrent refreshes", func() {
+		first, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		var wg sync.WaitGroup
+		results := make(chan *GeneratedToken, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				refreshed, _ := manager.Refresh(first.Token, first.RefreshToken, claim, expirationPeriod, secret, nil)
+				results <- refreshed
+			}()
+		}
+		wg.Wait()
+		close(results)
+
+		succeeded := 0
+		for refreshed := range results {
+			if refreshed != nil {
+				succeeded++
+			}
+		}
+		gomega.Expect(succeeded).To(gomega.Equal(1))
+		gomega.Expect(events.events).NotTo(gomega.BeEmpty())
+	})
+
+	ginkgo.It("stores the refresh tokens hashed with sha256", func() {
+		generated, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		tk, jwtErr := jwt.ParseWithClaims(generated.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		gomega.Expect(jwtErr).To(gomega.Succeed())
+		tokenData, err := manager.(*JWTToken).TokenProvider.Get("u1", tk.Claims.(*token.Claim).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(tokenData.RefreshToken).To(gomega.Equal(hashRefreshToken(generated.RefreshToken)))
+	})
+
+	ginkgo.It("accepts the refresh tokens hashed with the password manager", func() {
+		generated, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		tk, jwtErr := jwt.ParseWithClaims(generated.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		gomega.Expect(jwtErr).To(gomega.Succeed())
+		tokenData, err := manager.(*JWTToken).TokenProvider.Get("u1", tk.Claims.(*token.Claim).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		tokenData.RefreshToken, err = manager.(*JWTToken).Password.GenerateHashedPassword(generated.RefreshToken)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.(*JWTToken).TokenProvider.Delete("u1", tokenData.TokenID)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = manager.(*JWTToken).TokenProvider.Add(tokenData)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		refreshed, err := manager.Refresh(generated.Token, generated.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(refreshed).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("revokes the family on logout", func() {
+		first, err := manager.Generate(claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		second, err := manager.Refresh(first.Token, first.RefreshToken, claim, expirationPeriod, secret, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		err = manager.Revoke(second.Token, secret)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		tk, jwtErr := jwt.ParseWithClaims(first.Token, &token.Claim{}, func(t *jwt.Token) (interface{}, error) {
+			return []byte(secret), nil
+		})
+		gomega.Expect(jwtErr).To(gomega.Succeed())
+		revoked, err := manager.IsRevoked(tk.Claims.(*token.Claim).Id)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeTrue())
+		gomega.Expect(events.events).To(gomega.BeEmpty())
+	})
+
+	ginkgo.AfterEach(func() {
+		err := manager.Clean()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
 func TokenContexts(manager Token) {
 	ginkgo.Context("with a basic parameters", func() {
 		claim := token.NewPersonalClaim("u1", "r1", []string{"p1", "p2"}, "o1")
 		expirationPeriod, _ := time.ParseDuration("10m")
 		secret := "myLittleSecret112131"
 		ginkgo.It("can generate a token", func() {
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
 		})
 
 		ginkgo.It("can generated two tokens for the same user", func() {
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Generate(claim, expirationPeriod, secret)
+			gTNew, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gTNew).NotTo(gomega.BeNil())
 			gomega.Expect(gTNew).NotTo(gomega.Equal(gT))
 		})
 
 		ginkgo.It("can refresh a token", func() {
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
@@ -67,17 +300,40 @@ func TokenContexts(manager Token) {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, expirationPeriod, secret)
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gTNew).NotTo(gomega.BeNil())
 			gomega.Expect(gTNew).NotTo(gomega.Equal(gT))
 		})
 
+		ginkgo.It("must reject a refresh with the claim of other user", func() {
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			other := token.NewPersonalClaim("u2", "r1", []string{"p1", "p2"}, "o1")
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, other, expirationPeriod, secret, nil)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(gTNew).To(gomega.BeNil())
+		})
+
+		ginkgo.It("must generate the new token with the given claim", func() {
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			updated := token.NewPersonalClaim("u1", "r2", []string{"p1"}, "o1")
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, updated, expirationPeriod, secret, nil)
+			gomega.Expect(err).To(gomega.Succeed())
+			cl, err := manager.GetTokenInfo(gTNew.Token, secret)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(cl.RoleName).To(gomega.Equal("r2"))
+			gomega.Expect(cl.Primitives).To(gomega.Equal([]string{"p1"}))
+		})
+
 		ginkgo.It("must be able to reject an expired refresh token", func() {
 
 			d, _ := time.ParseDuration("-1s")
 
-			gT, err := manager.Generate(claim, d, secret)
+			gT, err := manager.Generate(claim, d, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
@@ -91,7 +347,7 @@ func TokenContexts(manager Token) {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, expirationPeriod, secret)
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(gTNew).To(gomega.BeNil())
 
@@ -99,7 +355,7 @@ func TokenContexts(manager Token) {
 
 		ginkgo.It("must be able to reject the refresh token is incorrect", func() {
 
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
@@ -113,7 +369,7 @@ func TokenContexts(manager Token) {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken+"wrong", expirationPeriod, secret)
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken+"wrong", claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(gTNew).To(gomega.BeNil())
 
@@ -121,7 +377,7 @@ func TokenContexts(manager Token) {
 
 		ginkgo.It("must be able to reject the token is incorrect", func() {
 
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
@@ -135,7 +391,7 @@ func TokenContexts(manager Token) {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Refresh(gT.Token+"wrong", gT.RefreshToken, expirationPeriod, secret)
+			gTNew, err := manager.Refresh(gT.Token+"wrong", gT.RefreshToken, claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(gTNew).To(gomega.BeNil())
 
@@ -143,7 +399,7 @@ func TokenContexts(manager Token) {
 
 		ginkgo.It("can't use two times the same refresh token", func() {
 
-			gT, err := manager.Generate(claim, expirationPeriod, secret)
+			gT, err := manager.Generate(claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gT).NotTo(gomega.BeNil())
 
@@ -156,12 +412,12 @@ func TokenContexts(manager Token) {
 			gomega.Expect(ok).To(gomega.BeTrue())
 			gomega.Expect(cl).NotTo(gomega.BeNil())
 
-			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, expirationPeriod, secret)
+			gTNew, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(gTNew).NotTo(gomega.BeNil())
 			gomega.Expect(gTNew).NotTo(gomega.Equal(gT))
 
-			gTWrong, err := manager.Refresh(gT.Token, gT.RefreshToken, expirationPeriod, secret)
+			gTWrong, err := manager.Refresh(gT.Token, gT.RefreshToken, claim, expirationPeriod, secret, nil)
 			gomega.Expect(err).To(gomega.HaveOccurred())
 			gomega.Expect(gTWrong).To(gomega.BeNil())
 
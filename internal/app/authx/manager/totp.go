@@ -0,0 +1,113 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/nalej/derrors"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTPPeriod is the time step of the TOTP codes.
+const TOTPPeriod = 30 * time.Second
+
+// TOTPDigits is the number of digits of the TOTP codes.
+const TOTPDigits = 6
+
+// TOTPSkew is the number of time steps before and after the current one in which a code is accepted. It allows small
+// differences between the clocks of the server and the device of the user.
+const TOTPSkew = 1
+
+// totpSecretLength is the number of random bytes of a TOTP secret, as recommended by RFC 4226.
+const totpSecretLength = 20
+
+// totpEncoding is the encoding of the secrets used by the authenticator applications.
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret creates a new random secret encoded in base32.
+func GenerateTOTPSecret() (string, derrors.Error) {
+	secret := make([]byte, totpSecretLength)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", derrors.AsError(err, "cannot generate TOTP secret")
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPURI returns the otpauth URI used by the authenticator applications to register a secret, usually shown as a
+// QR code.
+func TOTPURI(issuer string, username string, secret string) string {
+	label := url.PathEscape(issuer + ":" + username)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	params.Set("period", fmt.Sprintf("%d", int(TOTPPeriod.Seconds())))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// TOTPCounter returns the time step of an instant.
+func TOTPCounter(t time.Time) int64 {
+	return t.Unix() / int64(TOTPPeriod.Seconds())
+}
+
+// TOTPCode computes the code of a secret for a time step as defined in RFC 6238 with HMAC-SHA1.
+func TOTPCode(secret string, counter int64) (string, derrors.Error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", derrors.NewInvalidArgumentError("invalid TOTP secret", err)
+	}
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	// dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+// ValidateTOTP checks a code against the time steps around an instant. Only the time steps after the last accepted
+// one are checked so a code cannot be reused. It returns the time step of the code.
+func ValidateTOTP(secret string, code string, t time.Time, lastCounter int64) (int64, bool) {
+	current := TOTPCounter(t)
+	for counter := current - TOTPSkew; counter <= current+TOTPSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		expected, err := TOTPCode(secret, counter)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return counter, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package manager
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+// rfc6238Secret is the base32 encoding of the SHA1 secret of the test vectors of RFC 6238.
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+var _ = ginkgo.Describe("TOTP", func() {
+
+	ginkgo.It("computes the codes of the RFC 6238 test vectors", func() {
+		vectors := map[int64]string{
+			59:         "287082",
+			1111111109: "081804",
+			1234567890: "005924",
+			2000000000: "279037",
+		}
+		for seconds, expected := range vectors {
+			code, err := TOTPCode(rfc6238Secret, TOTPCounter(time.Unix(seconds, 0)))
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(code).To(gomega.Equal(expected))
+		}
+	})
+
+	ginkgo.It("accepts codes of the adjacent time steps only once", func() {
+		now := time.Unix(1234567890, 0)
+		counter := TOTPCounter(now)
+		previous, err := TOTPCode(rfc6238Secret, counter-1)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		accepted, ok := ValidateTOTP(rfc6238Secret, previous, now, 0)
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(accepted).To(gomega.Equal(counter - 1))
+
+		_, ok = ValidateTOTP(rfc6238Secret, previous, now, accepted)
+		gomega.Expect(ok).To(gomega.BeFalse())
+
+		old, err := TOTPCode(rfc6238Secret, counter-2)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, ok = ValidateTOTP(rfc6238Secret, old, now, 0)
+		gomega.Expect(ok).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("generates secrets usable by authenticator applications", func() {
+		secret, err := GenerateTOTPSecret()
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(secret).To(gomega.HaveLen(32))
+		_, err = TOTPCode(secret, 1)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		uri := TOTPURI(MFAIssuer, "user@nalej.com", secret)
+		gomega.Expect(strings.HasPrefix(uri, "otpauth://totp/")).To(gomega.BeTrue())
+		gomega.Expect(uri).To(gomega.ContainSubstring("secret=" + secret))
+	})
+})
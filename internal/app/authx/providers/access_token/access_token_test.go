@@ -0,0 +1,128 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package access_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+func AccessTokenContexts(provider Provider) {
+	
+	ginkgo.Context("with a personal access token", func() {
+		now := time.Now()
+		token := entities.NewPersonalAccessTokenData("u1", "t1", "ci", "h1", []string{"APPS"},
+			now.Unix(), now.Add(time.Hour).Unix())
+		ginkgo.BeforeEach(func() {
+			err := provider.Add(token)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("cannot add the token twice", func() {
+			err := provider.Add(token)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.AlreadyExists))
+		})
+		
+		ginkgo.It("must get the token", func() {
+			retrieved, err := provider.Get(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*token))
+		})
+		
+		ginkgo.It("must get the token by its hash", func() {
+			retrieved, err := provider.GetByHash(token.TokenHash)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*token))
+		})
+		
+		ginkgo.It("must list the tokens of the user", func() {
+			other := entities.NewPersonalAccessTokenData("u1", "t2", "deploy", "h2", []string{"APPS"},
+				now.Unix(), now.Add(time.Hour).Unix())
+			err := provider.Add(other)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			list, err := provider.List(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.ConsistOf(*token, *other))
+			
+			list, err = provider.List("u2")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.BeEmpty())
+		})
+		
+		ginkgo.It("must update the last use", func() {
+			err := provider.UpdateLastUsed(token.Username, token.TokenID, now.Unix())
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			retrieved, err := provider.Get(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(retrieved.LastUsed).To(gomega.Equal(now.Unix()))
+		})
+		
+		ginkgo.It("must delete the token", func() {
+			err := provider.Delete(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			_, err = provider.GetByHash(token.TokenHash)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("must delete the tokens of the user", func() {
+			err := provider.DeleteByUsername(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			list, err := provider.List(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.BeEmpty())
+		})
+		
+	})
+	ginkgo.Context("empty data store", func() {
+		
+		ginkgo.It("doesn't have the token", func() {
+			_, err := provider.Get("u1", "t1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+			
+			_, err = provider.GetByHash("h1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("cannot update a token that does not exist", func() {
+			err := provider.UpdateLastUsed("u1", "t1", time.Now().Unix())
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("cannot delete a token that does not exist", func() {
+			err := provider.Delete("u1", "t1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+	})
+	ginkgo.AfterEach(func() {
+		err := provider.Truncate()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+}
@@ -0,0 +1,127 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package access_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+)
+
+// AccessTokenMockup is an in-memory mockup.
+type AccessTokenMockup struct {
+	sync.Mutex
+	// data contains the tokens of each user indexed by tokenID.
+	data map[string]map[string]entities.PersonalAccessTokenData
+}
+
+// NewAccessTokenMockup create a new instance of AccessTokenMockup.
+func NewAccessTokenMockup() Provider {
+	return &AccessTokenMockup{data: make(map[string]map[string]entities.PersonalAccessTokenData, 0)}
+}
+
+// Add a new personal access token.
+func (p *AccessTokenMockup) Add(token *entities.PersonalAccessTokenData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	userTokens, ok := p.data[token.Username]
+	if !ok {
+		userTokens = make(map[string]entities.PersonalAccessTokenData, 0)
+		p.data[token.Username] = userTokens
+	}
+	if _, exists := userTokens[token.TokenID]; exists {
+		return derrors.NewAlreadyExistsError("personal access token").WithParams(token.Username, token.TokenID)
+	}
+	userTokens[token.TokenID] = *token
+	return nil
+}
+
+// Get a personal access token of a user.
+func (p *AccessTokenMockup) Get(username string, tokenID string) (*entities.PersonalAccessTokenData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	token, ok := p.data[username][tokenID]
+	if !ok {
+		return nil, derrors.NewNotFoundError("personal access token").WithParams(username, tokenID)
+	}
+	return &token, nil
+}
+
+// GetByHash returns the personal access token with a given hash.
+func (p *AccessTokenMockup) GetByHash(tokenHash string) (*entities.PersonalAccessTokenData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	for _, userTokens := range p.data {
+		for _, token := range userTokens {
+			if token.TokenHash == tokenHash {
+				return &token, nil
+			}
+		}
+	}
+	return nil, derrors.NewNotFoundError("personal access token")
+}
+
+// List the personal access tokens of a user.
+func (p *AccessTokenMockup) List(username string) ([]entities.PersonalAccessTokenData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	result := make([]entities.PersonalAccessTokenData, 0, len(p.data[username]))
+	for _, token := range p.data[username] {
+		result = append(result, token)
+	}
+	return result, nil
+}
+
+// UpdateLastUsed sets the last time a personal access token was used.
+func (p *AccessTokenMockup) UpdateLastUsed(username string, tokenID string, lastUsed int64) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	token, ok := p.data[username][tokenID]
+	if !ok {
+		return derrors.NewNotFoundError("personal access token").WithParams(username, tokenID)
+	}
+	token.LastUsed = lastUsed
+	p.data[username][tokenID] = token
+	return nil
+}
+
+// Delete a personal access token.
+func (p *AccessTokenMockup) Delete(username string, tokenID string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.data[username][tokenID]; !ok {
+		return derrors.NewNotFoundError("personal access token").WithParams(username, tokenID)
+	}
+	delete(p.data[username], tokenID)
+	return nil
+}
+
+// DeleteByUsername removes all the personal access tokens of a user.
+func (p *AccessTokenMockup) DeleteByUsername(username string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.data, username)
+	return nil
+}
+
+// Truncate cleans all data.
+func (p *AccessTokenMockup) Truncate() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data = make(map[string]map[string]entities.PersonalAccessTokenData, 0)
+	return nil
+}
@@ -0,0 +1,42 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package access_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Provider is the interface to store the personal access tokens.
+type Provider interface {
+	// Add a new personal access token.
+	Add(token *entities.PersonalAccessTokenData) derrors.Error
+	// Get a personal access token of a user.
+	Get(username string, tokenID string) (*entities.PersonalAccessTokenData, derrors.Error)
+	// GetByHash returns the personal access token with a given hash.
+	GetByHash(tokenHash string) (*entities.PersonalAccessTokenData, derrors.Error)
+	// List the personal access tokens of a user.
+	List(username string) ([]entities.PersonalAccessTokenData, derrors.Error)
+	// UpdateLastUsed sets the last time a personal access token was used.
+	UpdateLastUsed(username string, tokenID string, lastUsed int64) derrors.Error
+	// Delete a personal access token. It returns a NotFound error if the token does not exist.
+	Delete(username string, tokenID string) derrors.Error
+	// DeleteByUsername removes all the personal access tokens of a user.
+	DeleteByUsername(username string) derrors.Error
+	// Truncate cleans all data.
+	Truncate() derrors.Error
+}
@@ -0,0 +1,309 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package access_token
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+	"time"
+)
+
+const table = "personalAccessTokens"
+const tablePK_1 = "username"
+const tablePK_2 = "token_id"
+
+const rowNotFound = "not found"
+
+type ScyllaAccessTokenProvider struct {
+	Address  string
+	Port     int
+	KeySpace string
+	sync.Mutex
+	Session *gocql.Session
+}
+
+func NewScyllaAccessTokenProvider(address string, port int, keyspace string) *ScyllaAccessTokenProvider {
+	provider := ScyllaAccessTokenProvider{Address: address, Port: port, KeySpace: keyspace}
+	provider.connect()
+	return &provider
+}
+
+func (sp *ScyllaAccessTokenProvider) connect() derrors.Error {
+	
+	// connect to the cluster
+	conf := gocql.NewCluster(sp.Address)
+	conf.Keyspace = sp.KeySpace
+	conf.Port = sp.Port
+	
+	session, err := conf.CreateSession()
+	if err != nil {
+		log.Error().Str("provider", "ScyllaAccessTokenProvider").Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
+		return derrors.AsError(err, "cannot connect")
+	}
+	
+	sp.Session = session
+	return nil
+}
+
+func (sp *ScyllaAccessTokenProvider) Disconnect() {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if sp.Session != nil {
+		sp.Session.Close()
+		sp.Session = nil
+	}
+	
+}
+
+func (sp *ScyllaAccessTokenProvider) checkConnectionAndConnect() derrors.Error {
+	
+	if sp.Session != nil {
+		return nil
+	}
+	log.Info().Str("provider", "ScyllaAccessTokenProvider").Msg("session not connected, trying to connect it!")
+	err := sp.connect()
+	if err != nil {
+		return err
+	}
+	
+	return nil
+}
+
+// ttl returns the time a token must be kept in the database.
+func ttl(token *entities.PersonalAccessTokenData) time.Duration {
+	ttl := time.Unix(token.ExpirationDate, 0).Sub(time.Now())
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	return ttl
+}
+
+func (sp *ScyllaAccessTokenProvider) unsafeGet(username string, tokenID string) (*entities.PersonalAccessTokenData, derrors.Error) {
+	
+	var token entities.PersonalAccessTokenData
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK_1: username,
+		tablePK_2: tokenID})
+	
+	err := q.GetRelease(&token)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("personal access token").WithParams(username, tokenID)
+		} else {
+			return nil, derrors.AsError(err, "cannot get personal access token")
+		}
+	}
+	
+	return &token, nil
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// Add a new personal access token. The token is stored until its expiration date.
+func (sp *ScyllaAccessTokenProvider) Add(token *entities.PersonalAccessTokenData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	_, err := sp.unsafeGet(token.Username, token.TokenID)
+	if err == nil {
+		return derrors.NewAlreadyExistsError("personal access token").WithParams(token.Username, token.TokenID)
+	}
+	if err.Type() != derrors.NotFound {
+		return err
+	}
+	
+	stmt, names := qb.Insert(table).Columns("username", "token_id", "name", "token_hash", "primitives",
+		"creation_date", "expiration_date", "last_used").TTL(ttl(token)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot add personal access token")
+	}
+	
+	return nil
+}
+
+// Get a personal access token of a user.
+func (sp *ScyllaAccessTokenProvider) Get(username string, tokenID string) (*entities.PersonalAccessTokenData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	return sp.unsafeGet(username, tokenID)
+}
+
+// GetByHash returns the personal access token with a given hash.
+func (sp *ScyllaAccessTokenProvider) GetByHash(tokenHash string) (*entities.PersonalAccessTokenData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	var token entities.PersonalAccessTokenData
+	stmt, names := qb.Select(table).Where(qb.Eq("token_hash")).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		"token_hash": tokenHash})
+	
+	err := q.GetRelease(&token)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("personal access token")
+		} else {
+			return nil, derrors.AsError(err, "cannot get personal access token")
+		}
+	}
+	
+	return &token, nil
+}
+
+// List the personal access tokens of a user.
+func (sp *ScyllaAccessTokenProvider) List(username string) ([]entities.PersonalAccessTokenData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	result := make([]entities.PersonalAccessTokenData, 0)
+	
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK_1)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK_1: username,
+	})
+	
+	cqlErr := gocqlx.Select(&result, q.Query)
+	
+	if cqlErr != nil {
+		return nil, derrors.AsError(cqlErr, "cannot list personal access tokens")
+	}
+	
+	return result, nil
+}
+
+// UpdateLastUsed sets the last time a personal access token was used. The column keeps the expiration of the token.
+func (sp *ScyllaAccessTokenProvider) UpdateLastUsed(username string, tokenID string, lastUsed int64) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	token, err := sp.unsafeGet(username, tokenID)
+	if err != nil {
+		return err
+	}
+	token.LastUsed = lastUsed
+	
+	stmt, names := qb.Update(table).Set("last_used").
+		Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).TTL(ttl(token)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot update personal access token")
+	}
+	
+	return nil
+}
+
+// Delete a personal access token.
+func (sp *ScyllaAccessTokenProvider) Delete(username string, tokenID string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).Existing().ToCql()
+	applied, cqlErr := sp.Session.Query(stmt, username, tokenID).ScanCAS()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete personal access token")
+	}
+	if !applied {
+		return derrors.NewNotFoundError("personal access token").WithParams(username, tokenID)
+	}
+	
+	return nil
+}
+
+// DeleteByUsername removes all the personal access tokens of a user.
+func (sp *ScyllaAccessTokenProvider) DeleteByUsername(username string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK_1)).ToCql()
+	cqlErr := sp.Session.Query(stmt, username).Exec()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete personal access tokens")
+	}
+	
+	return nil
+}
+
+// Truncate cleans all data.
+func (sp *ScyllaAccessTokenProvider) Truncate() derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	err := sp.Session.Query("TRUNCATE TABLE personalAccessTokens").Exec()
+	if err != nil {
+		log.Info().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("failed to truncate the table")
+		return derrors.AsError(err, "cannot truncate personal access token table")
+	}
+	
+	return nil
+}
@@ -50,6 +50,67 @@ func CredentialsContexts(provider BasicCredentials) {
 			gomega.Expect(err).To(gomega.Succeed())
 			gomega.Expect(c).NotTo(gomega.BeNil())
 			gomega.Expect(c.Password).To(gomega.Equal([]byte("pNew")))
+			gomega.Expect(c.PasswordChangedAt).To(gomega.Equal(credentials.PasswordChangedAt))
+			
+		})
+		
+		ginkgo.It("can be changed the password keeping the history", func() {
+			history := [][]byte{credentials.Password}
+			edit := entities.NewEditBasicCredentialsData().WithPasswordChange([]byte("pNew"), history)
+			err := provider.Edit(credentials.Username, edit)
+			gomega.Expect(err).To(gomega.Succeed())
+			c, err := provider.Get(credentials.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(c).NotTo(gomega.BeNil())
+			gomega.Expect(c.Password).To(gomega.Equal([]byte("pNew")))
+			gomega.Expect(c.PasswordHistory).To(gomega.Equal(history))
+			gomega.Expect(c.PasswordChangedAt).To(gomega.Equal(*edit.PasswordChangedAt))
+			
+		})
+		
+		ginkgo.It("can be edited the MFA settings", func() {
+			edit := entities.NewEditBasicCredentialsData().WithMFASecret("SECRET").WithMFAEnabled(true).
+				WithMFALastCounter(10).WithRecoveryCodes([]string{"c1", "c2"})
+			err := provider.Edit(credentials.Username, edit)
+			gomega.Expect(err).To(gomega.Succeed())
+			c, err := provider.Get(credentials.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(c).NotTo(gomega.BeNil())
+			gomega.Expect(c.MFASecret).To(gomega.Equal("SECRET"))
+			gomega.Expect(c.MFAEnabled).To(gomega.BeTrue())
+			gomega.Expect(c.MFALastCounter).To(gomega.Equal(int64(10)))
+			gomega.Expect(c.RecoveryCodes).To(gomega.Equal([]string{"c1", "c2"}))
+			gomega.Expect(c.Password).To(gomega.Equal(credentials.Password))
+			
+		})
+		
+		ginkgo.It("must update the MFA counter only if it has not changed", func() {
+			err := provider.Edit(credentials.Username, entities.NewEditBasicCredentialsData().WithMFALastCounter(10))
+			gomega.Expect(err).To(gomega.Succeed())
+			updated, err := provider.CompareAndSetMFALastCounter(credentials.Username, 10, 11)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(updated).To(gomega.BeTrue())
+			updated, err = provider.CompareAndSetMFALastCounter(credentials.Username, 10, 12)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(updated).To(gomega.BeFalse())
+			c, err := provider.Get(credentials.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(c.MFALastCounter).To(gomega.Equal(int64(11)))
+			
+		})
+		
+		ginkgo.It("must update the recovery codes only if they have not changed", func() {
+			err := provider.Edit(credentials.Username, entities.NewEditBasicCredentialsData().WithRecoveryCodes([]string{"c1", "c2"}))
+			gomega.Expect(err).To(gomega.Succeed())
+			updated, err := provider.CompareAndSetRecoveryCodes(credentials.Username, []string{"c1", "c2"}, []string{"c2"})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(updated).To(gomega.BeTrue())
+			updated, err = provider.CompareAndSetRecoveryCodes(credentials.Username, []string{"c1", "c2"}, []string{"c1"})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(updated).To(gomega.BeFalse())
+			c, err := provider.Get(credentials.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(c.RecoveryCodes).To(gomega.Equal([]string{"c2"}))
 			
 		})
 		
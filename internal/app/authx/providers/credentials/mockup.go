@@ -99,11 +99,69 @@ func (p *BasicCredentialsMockup) Edit(username string, edit *entities.EditBasicC
 	if edit.Password != nil {
 		data.Password = *edit.Password
 	}
+	if edit.PasswordChangedAt != nil {
+		data.PasswordChangedAt = *edit.PasswordChangedAt
+	}
+	if edit.PasswordHistory != nil {
+		data.PasswordHistory = *edit.PasswordHistory
+	}
+	if edit.MFASecret != nil {
+		data.MFASecret = *edit.MFASecret
+	}
+	if edit.MFAEnabled != nil {
+		data.MFAEnabled = *edit.MFAEnabled
+	}
+	if edit.MFALastCounter != nil {
+		data.MFALastCounter = *edit.MFALastCounter
+	}
+	if edit.RecoveryCodes != nil {
+		data.RecoveryCodes = *edit.RecoveryCodes
+	}
 	
 	p.data[username] = *data
 	return nil
 }
 
+// CompareAndSetMFALastCounter sets the time step of the last accepted TOTP code only if the stored one is equal to
+// previous.
+func (p *BasicCredentialsMockup) CompareAndSetMFALastCounter(username string, previous int64, counter int64) (bool, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	
+	data, err := p.unsafeGet(username)
+	if err != nil {
+		return false, err
+	}
+	if data.MFALastCounter != previous {
+		return false, nil
+	}
+	data.MFALastCounter = counter
+	p.data[username] = *data
+	return true, nil
+}
+
+// CompareAndSetRecoveryCodes replaces the recovery codes only if the stored ones are equal to previous.
+func (p *BasicCredentialsMockup) CompareAndSetRecoveryCodes(username string, previous []string, codes []string) (bool, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	
+	data, err := p.unsafeGet(username)
+	if err != nil {
+		return false, err
+	}
+	if len(data.RecoveryCodes) != len(previous) {
+		return false, nil
+	}
+	for i, code := range data.RecoveryCodes {
+		if code != previous[i] {
+			return false, nil
+		}
+	}
+	data.RecoveryCodes = codes
+	p.data[username] = *data
+	return true, nil
+}
+
 // Truncate removes all credentials.
 func (p *BasicCredentialsMockup) Truncate() derrors.Error {
 	p.Lock()
@@ -32,6 +32,12 @@ type BasicCredentials interface {
 	Get(username string) (*entities.BasicCredentialsData, derrors.Error)
 	// Edit update a specific user credentials.
 	Edit(username string, edit *entities.EditBasicCredentialsData) derrors.Error
+	// CompareAndSetMFALastCounter sets the time step of the last accepted TOTP code only if the stored one is equal
+	// to previous, so a code cannot be accepted twice by concurrent requests.
+	CompareAndSetMFALastCounter(username string, previous int64, counter int64) (bool, derrors.Error)
+	// CompareAndSetRecoveryCodes replaces the recovery codes only if the stored ones are equal to previous, so a
+	// recovery code cannot be used twice by concurrent requests.
+	CompareAndSetRecoveryCodes(username string, previous []string, codes []string) (bool, derrors.Error)
 	// Exist check if exists a specific credentials.
 	Exist(username string) (*bool, derrors.Error)
 	// Truncate removes all credentials.
@@ -18,6 +18,7 @@
 package credentials
 
 import (
+	"fmt"
 	"github.com/gocql/gocql"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-utils/pkg/conversions"
@@ -185,7 +186,8 @@ func (sp *ScyllaCredentialsProvider) Add(credentials *entities.BasicCredentialsD
 	}
 	
 	// add new basic credential
-	stmt, names := qb.Insert(table).Columns("username", "password", "role_id", "organization_id").ToCql()
+	stmt, names := qb.Insert(table).Columns("username", "password", "role_id", "organization_id", "password_changed_at", "password_history",
+		"mfa_secret", "mfa_enabled", "mfa_last_counter", "recovery_codes").ToCql()
 	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(credentials)
 	cqlErr := q.ExecRelease()
 	
@@ -245,8 +247,27 @@ func (sp *ScyllaCredentialsProvider) Edit(username string, edit *entities.EditBa
 	if edit.Password != nil {
 		data.Password = *edit.Password
 	}
+	if edit.PasswordChangedAt != nil {
+		data.PasswordChangedAt = *edit.PasswordChangedAt
+	}
+	if edit.PasswordHistory != nil {
+		data.PasswordHistory = *edit.PasswordHistory
+	}
+	if edit.MFASecret != nil {
+		data.MFASecret = *edit.MFASecret
+	}
+	if edit.MFAEnabled != nil {
+		data.MFAEnabled = *edit.MFAEnabled
+	}
+	if edit.MFALastCounter != nil {
+		data.MFALastCounter = *edit.MFALastCounter
+	}
+	if edit.RecoveryCodes != nil {
+		data.RecoveryCodes = *edit.RecoveryCodes
+	}
 	// update
-	stmt, names := qb.Update(table).Set("password", "role_id", "organization_id").Where(qb.Eq(tablePK)).ToCql()
+	stmt, names := qb.Update(table).Set("password", "role_id", "organization_id", "password_changed_at", "password_history",
+		"mfa_secret", "mfa_enabled", "mfa_last_counter", "recovery_codes").Where(qb.Eq(tablePK)).ToCql()
 	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(data)
 	cqlErr := q.ExecRelease()
 	
@@ -258,6 +279,48 @@ func (sp *ScyllaCredentialsProvider) Edit(username string, edit *entities.EditBa
 	
 }
 
+// CompareAndSetMFALastCounter sets the time step of the last accepted TOTP code with a conditional update.
+func (sp *ScyllaCredentialsProvider) CompareAndSetMFALastCounter(username string, previous int64, counter int64) (bool, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return false, err
+	}
+	
+	stmt := fmt.Sprintf("UPDATE %s SET mfa_last_counter = ? WHERE %s = ? IF mfa_last_counter = ?", table, tablePK)
+	current := make(map[string]interface{})
+	applied, cqlErr := sp.Session.Query(stmt, counter, username, previous).MapScanCAS(current)
+	
+	if cqlErr != nil {
+		return false, derrors.AsError(cqlErr, "cannot update MFA last counter")
+	}
+	
+	return applied, nil
+}
+
+// CompareAndSetRecoveryCodes replaces the recovery codes with a conditional update.
+func (sp *ScyllaCredentialsProvider) CompareAndSetRecoveryCodes(username string, previous []string, codes []string) (bool, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return false, err
+	}
+	
+	stmt := fmt.Sprintf("UPDATE %s SET recovery_codes = ? WHERE %s = ? IF recovery_codes = ?", table, tablePK)
+	current := make(map[string]interface{})
+	applied, cqlErr := sp.Session.Query(stmt, codes, username, previous).MapScanCAS(current)
+	
+	if cqlErr != nil {
+		return false, derrors.AsError(cqlErr, "cannot update recovery codes")
+	}
+	
+	return applied, nil
+}
+
 // Exist check if exists a specific credentials.
 func (sp *ScyllaCredentialsProvider) Exist(username string) (*bool, derrors.Error) {
 	
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package lockout
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+func LockoutContexts(provider Provider) {
+	
+	ginkgo.Context("with failed attempts", func() {
+		attempts := &entities.LoginAttemptsData{
+			AttemptKey:  entities.UserAttemptsKey("u1"),
+			Failures:    2,
+			LastFailure: time.Now().Unix(),
+		}
+		ginkgo.BeforeEach(func() {
+			err := provider.Set(attempts)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("must get the attempts", func() {
+			retrieved, err := provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*attempts))
+		})
+		
+		ginkgo.It("must replace the attempts", func() {
+			updated := *attempts
+			updated.Failures = 3
+			updated.LockedUntil = time.Now().Add(time.Minute).Unix()
+			err := provider.Set(&updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			retrieved, err := provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(updated))
+		})
+		
+		ginkgo.It("must update the attempts if they didn't change", func() {
+			updated := *attempts
+			updated.Failures = 3
+			applied, err := provider.CompareAndSet(attempts, &updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(applied).To(gomega.BeTrue())
+			
+			retrieved, err := provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(updated))
+		})
+		
+		ginkgo.It("must not update the attempts if they changed", func() {
+			stale := *attempts
+			stale.Failures = 1
+			updated := *attempts
+			updated.Failures = 3
+			applied, err := provider.CompareAndSet(&stale, &updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(applied).To(gomega.BeFalse())
+			
+			applied, err = provider.CompareAndSet(nil, &updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(applied).To(gomega.BeFalse())
+			
+			retrieved, err := provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*attempts))
+		})
+		
+		ginkgo.It("must delete the attempts", func() {
+			err := provider.Delete(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			_, err = provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+		
+	})
+	ginkgo.Context("empty data store", func() {
+		
+		ginkgo.It("doesn't have attempts", func() {
+			_, err := provider.Get(entities.UserAttemptsKey("u1"))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("can add the first attempts", func() {
+			attempts := &entities.LoginAttemptsData{
+				AttemptKey:  entities.UserAttemptsKey("u1"),
+				Failures:    1,
+				LastFailure: time.Now().Unix(),
+			}
+			applied, err := provider.CompareAndSet(nil, attempts)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(applied).To(gomega.BeTrue())
+			
+			retrieved, err := provider.Get(attempts.AttemptKey)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*attempts))
+		})
+		
+		ginkgo.It("can delete a key without attempts", func() {
+			err := provider.Delete(entities.UserAttemptsKey("u1"))
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+	})
+	ginkgo.AfterEach(func() {
+		err := provider.Truncate()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+}
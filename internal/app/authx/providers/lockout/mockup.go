@@ -0,0 +1,85 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package lockout
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+)
+
+// LockoutMockup is an in-memory mockup.
+type LockoutMockup struct {
+	sync.Mutex
+	data map[string]entities.LoginAttemptsData
+}
+
+// NewLockoutMockup create a new instance of LockoutMockup.
+func NewLockoutMockup() Provider {
+	return &LockoutMockup{data: make(map[string]entities.LoginAttemptsData, 0)}
+}
+
+// Get the attempts of a key.
+func (p *LockoutMockup) Get(attemptKey string) (*entities.LoginAttemptsData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	attempts, ok := p.data[attemptKey]
+	if !ok {
+		return nil, derrors.NewNotFoundError("login attempts").WithParams(attemptKey)
+	}
+	return &attempts, nil
+}
+
+// Set stores the attempts of a key, replacing the previous ones.
+func (p *LockoutMockup) Set(attempts *entities.LoginAttemptsData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data[attempts.AttemptKey] = *attempts
+	return nil
+}
+
+// CompareAndSet stores the attempts of a key only if the stored ones are equal to previous.
+func (p *LockoutMockup) CompareAndSet(previous *entities.LoginAttemptsData, attempts *entities.LoginAttemptsData) (bool, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	current, ok := p.data[attempts.AttemptKey]
+	if previous == nil {
+		if ok {
+			return false, nil
+		}
+	} else if !ok || current != *previous {
+		return false, nil
+	}
+	p.data[attempts.AttemptKey] = *attempts
+	return true, nil
+}
+
+// Delete the attempts of a key.
+func (p *LockoutMockup) Delete(attemptKey string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.data, attemptKey)
+	return nil
+}
+
+// Truncate cleans all data.
+func (p *LockoutMockup) Truncate() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data = make(map[string]entities.LoginAttemptsData, 0)
+	return nil
+}
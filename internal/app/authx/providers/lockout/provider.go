@@ -0,0 +1,38 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package lockout
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Provider is the interface to store the failed login attempts. The attempts are shared by all the replicas of the
+// service.
+type Provider interface {
+	// Get the attempts of a key.
+	Get(attemptKey string) (*entities.LoginAttemptsData, derrors.Error)
+	// Set stores the attempts of a key, replacing the previous ones.
+	Set(attempts *entities.LoginAttemptsData) derrors.Error
+	// CompareAndSet stores the attempts of a key only if the stored ones are equal to previous. A nil previous means
+	// the key must not have attempts. It returns false if the stored attempts were changed by another request.
+	CompareAndSet(previous *entities.LoginAttemptsData, attempts *entities.LoginAttemptsData) (bool, derrors.Error)
+	// Delete the attempts of a key. Deleting a key without attempts is not an error.
+	Delete(attemptKey string) derrors.Error
+	// Truncate cleans all data.
+	Truncate() derrors.Error
+}
@@ -0,0 +1,216 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package lockout
+
+import (
+	"fmt"
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+	"time"
+)
+
+const table = "loginAttempts"
+const tablePK = "attempt_key"
+
+const rowNotFound = "not found"
+
+// MaxLockoutDuration is the time the attempts are stored. A lockout cannot last longer.
+const MaxLockoutDuration = time.Duration(24) * time.Hour
+
+type ScyllaLockoutProvider struct {
+	Address  string
+	Port     int
+	KeySpace string
+	sync.Mutex
+	Session *gocql.Session
+}
+
+func NewScyllaLockoutProvider(address string, port int, keyspace string) *ScyllaLockoutProvider {
+	provider := ScyllaLockoutProvider{Address: address, Port: port, KeySpace: keyspace}
+	provider.connect()
+	return &provider
+}
+
+func (sp *ScyllaLockoutProvider) connect() derrors.Error {
+	
+	// connect to the cluster
+	conf := gocql.NewCluster(sp.Address)
+	conf.Keyspace = sp.KeySpace
+	conf.Port = sp.Port
+	
+	session, err := conf.CreateSession()
+	if err != nil {
+		log.Error().Str("provider", "ScyllaLockoutProvider").Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
+		return derrors.AsError(err, "cannot connect")
+	}
+	
+	sp.Session = session
+	return nil
+}
+
+func (sp *ScyllaLockoutProvider) Disconnect() {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if sp.Session != nil {
+		sp.Session.Close()
+		sp.Session = nil
+	}
+	
+}
+
+func (sp *ScyllaLockoutProvider) checkConnectionAndConnect() derrors.Error {
+	
+	if sp.Session != nil {
+		return nil
+	}
+	log.Info().Str("provider", "ScyllaLockoutProvider").Msg("session not connected, trying to connect it!")
+	err := sp.connect()
+	if err != nil {
+		return err
+	}
+	
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// Get the attempts of a key.
+func (sp *ScyllaLockoutProvider) Get(attemptKey string) (*entities.LoginAttemptsData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	var attempts entities.LoginAttemptsData
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK: attemptKey})
+	
+	err := q.GetRelease(&attempts)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("login attempts").WithParams(attemptKey)
+		} else {
+			return nil, derrors.AsError(err, "cannot get login attempts")
+		}
+	}
+	
+	return &attempts, nil
+}
+
+// Set stores the attempts of a key, replacing the previous ones.
+func (sp *ScyllaLockoutProvider) Set(attempts *entities.LoginAttemptsData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, names := qb.Insert(table).Columns("attempt_key", "failures", "last_failure", "locked_until").TTL(MaxLockoutDuration).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(attempts)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot set login attempts")
+	}
+	
+	return nil
+}
+
+// CompareAndSet stores the attempts of a key only if the stored ones are equal to previous. The condition is checked
+// with a lightweight transaction so the concurrent failures of the replicas are not lost.
+func (sp *ScyllaLockoutProvider) CompareAndSet(previous *entities.LoginAttemptsData, attempts *entities.LoginAttemptsData) (bool, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return false, err
+	}
+	
+	var q *gocql.Query
+	if previous == nil {
+		stmt := fmt.Sprintf("INSERT INTO %s (%s, failures, last_failure, locked_until) VALUES (?, ?, ?, ?) IF NOT EXISTS USING TTL %d",
+			table, tablePK, int(MaxLockoutDuration.Seconds()))
+		q = sp.Session.Query(stmt, attempts.AttemptKey, attempts.Failures, attempts.LastFailure, attempts.LockedUntil)
+	} else {
+		stmt := fmt.Sprintf("UPDATE %s USING TTL %d SET failures = ?, last_failure = ?, locked_until = ? WHERE %s = ? IF failures = ? AND last_failure = ? AND locked_until = ?",
+			table, int(MaxLockoutDuration.Seconds()), tablePK)
+		q = sp.Session.Query(stmt, attempts.Failures, attempts.LastFailure, attempts.LockedUntil, attempts.AttemptKey,
+			previous.Failures, previous.LastFailure, previous.LockedUntil)
+	}
+	current := make(map[string]interface{})
+	applied, cqlErr := q.MapScanCAS(current)
+	
+	if cqlErr != nil {
+		return false, derrors.AsError(cqlErr, "cannot set login attempts")
+	}
+	
+	return applied, nil
+}
+
+// Delete the attempts of a key.
+func (sp *ScyllaLockoutProvider) Delete(attemptKey string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK)).ToCql()
+	cqlErr := sp.Session.Query(stmt, attemptKey).Exec()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete login attempts")
+	}
+	
+	return nil
+}
+
+// Truncate cleans all data.
+func (sp *ScyllaLockoutProvider) Truncate() derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	err := sp.Session.Query("TRUNCATE TABLE loginAttempts").Exec()
+	if err != nil {
+		log.Info().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("failed to truncate the table")
+		return derrors.AsError(err, "cannot truncate login attempts table")
+	}
+	
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package reset_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+)
+
+// ResetTokenMockup is an in-memory mockup.
+type ResetTokenMockup struct {
+	sync.Mutex
+	data map[string]entities.PasswordResetTokenData
+}
+
+// NewResetTokenMockup create a new instance of ResetTokenMockup.
+func NewResetTokenMockup() Provider {
+	return &ResetTokenMockup{data: make(map[string]entities.PasswordResetTokenData, 0)}
+}
+
+// Add a new reset token.
+func (p *ResetTokenMockup) Add(token *entities.PasswordResetTokenData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	if _, exists := p.data[token.TokenHash]; exists {
+		return derrors.NewAlreadyExistsError("reset token").WithParams(token.Username)
+	}
+	p.data[token.TokenHash] = *token
+	return nil
+}
+
+// Get a reset token by its hash.
+func (p *ResetTokenMockup) Get(tokenHash string) (*entities.PasswordResetTokenData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	token, ok := p.data[tokenHash]
+	if !ok {
+		return nil, derrors.NewNotFoundError("reset token")
+	}
+	return &token, nil
+}
+
+// Delete a reset token.
+func (p *ResetTokenMockup) Delete(tokenHash string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.data[tokenHash]; !ok {
+		return derrors.NewNotFoundError("reset token")
+	}
+	delete(p.data, tokenHash)
+	return nil
+}
+
+// DeleteByUsername removes all the reset tokens of a user.
+func (p *ResetTokenMockup) DeleteByUsername(username string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	for tokenHash, token := range p.data {
+		if token.Username == username {
+			delete(p.data, tokenHash)
+		}
+	}
+	return nil
+}
+
+// Truncate cleans all data.
+func (p *ResetTokenMockup) Truncate() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data = make(map[string]entities.PasswordResetTokenData, 0)
+	return nil
+}
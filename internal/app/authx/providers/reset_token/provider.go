@@ -0,0 +1,37 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package reset_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Provider is the interface to store the password reset tokens.
+type Provider interface {
+	// Add a new reset token.
+	Add(token *entities.PasswordResetTokenData) derrors.Error
+	// Get a reset token by its hash.
+	Get(tokenHash string) (*entities.PasswordResetTokenData, derrors.Error)
+	// Delete a reset token. It returns a NotFound error if the token does not exist, so only one of the concurrent
+	// deletions of the same token succeeds.
+	Delete(tokenHash string) derrors.Error
+	// DeleteByUsername removes all the reset tokens of a user.
+	DeleteByUsername(username string) derrors.Error
+	// Truncate cleans all data.
+	Truncate() derrors.Error
+}
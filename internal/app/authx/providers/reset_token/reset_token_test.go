@@ -0,0 +1,88 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package reset_token
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+func ResetTokenContexts(provider Provider) {
+	
+	ginkgo.Context("with a reset token", func() {
+		token := entities.NewPasswordResetTokenData("h1", "u1", time.Now().Add(time.Hour).Unix())
+		ginkgo.BeforeEach(func() {
+			err := provider.Add(token)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("must get the token", func() {
+			retrieved, err := provider.Get(token.TokenHash)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*token))
+		})
+		
+		ginkgo.It("must delete the token only once", func() {
+			err := provider.Delete(token.TokenHash)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			_, err = provider.Get(token.TokenHash)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			
+			err = provider.Delete(token.TokenHash)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("must delete the tokens of a user", func() {
+			other := entities.NewPasswordResetTokenData("h2", "u2", time.Now().Add(time.Hour).Unix())
+			err := provider.Add(other)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			err = provider.DeleteByUsername(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			_, err = provider.Get(token.TokenHash)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			_, err = provider.Get(other.TokenHash)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+	})
+	ginkgo.Context("empty data store", func() {
+		
+		ginkgo.It("doesn't have the token", func() {
+			_, err := provider.Get("h1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("cannot delete a token that does not exist", func() {
+			err := provider.Delete("h1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+	})
+	ginkgo.AfterEach(func() {
+		err := provider.Truncate()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package reset_token
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+	"time"
+)
+
+const table = "passwordResetTokens"
+const tablePK = "token_hash"
+
+const rowNotFound = "not found"
+
+type ScyllaResetTokenProvider struct {
+	Address  string
+	Port     int
+	KeySpace string
+	sync.Mutex
+	Session *gocql.Session
+}
+
+func NewScyllaResetTokenProvider(address string, port int, keyspace string) *ScyllaResetTokenProvider {
+	provider := ScyllaResetTokenProvider{Address: address, Port: port, KeySpace: keyspace}
+	provider.connect()
+	return &provider
+}
+
+func (sp *ScyllaResetTokenProvider) connect() derrors.Error {
+	
+	// connect to the cluster
+	conf := gocql.NewCluster(sp.Address)
+	conf.Keyspace = sp.KeySpace
+	conf.Port = sp.Port
+	
+	session, err := conf.CreateSession()
+	if err != nil {
+		log.Error().Str("provider", "ScyllaResetTokenProvider").Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
+		return derrors.AsError(err, "cannot connect")
+	}
+	
+	sp.Session = session
+	return nil
+}
+
+func (sp *ScyllaResetTokenProvider) Disconnect() {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if sp.Session != nil {
+		sp.Session.Close()
+		sp.Session = nil
+	}
+	
+}
+
+func (sp *ScyllaResetTokenProvider) checkConnectionAndConnect() derrors.Error {
+	
+	if sp.Session != nil {
+		return nil
+	}
+	log.Info().Str("provider", "ScyllaResetTokenProvider").Msg("session not connected, trying to connect it!")
+	err := sp.connect()
+	if err != nil {
+		return err
+	}
+	
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// Add a new reset token. The token is stored until its expiration date.
+func (sp *ScyllaResetTokenProvider) Add(token *entities.PasswordResetTokenData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	ttl := time.Unix(token.ExpirationDate, 0).Sub(time.Now())
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	
+	stmt, names := qb.Insert(table).Columns("token_hash", "username", "expiration_date").TTL(ttl).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot add reset token")
+	}
+	
+	return nil
+}
+
+// Get a reset token by its hash.
+func (sp *ScyllaResetTokenProvider) Get(tokenHash string) (*entities.PasswordResetTokenData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	var token entities.PasswordResetTokenData
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK: tokenHash})
+	
+	err := q.GetRelease(&token)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("reset token")
+		} else {
+			return nil, derrors.AsError(err, "cannot get reset token")
+		}
+	}
+	
+	return &token, nil
+}
+
+// Delete a reset token. The deletion is conditional so only one of the concurrent deletions succeeds.
+func (sp *ScyllaResetTokenProvider) Delete(tokenHash string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK)).Existing().ToCql()
+	applied, cqlErr := sp.Session.Query(stmt, tokenHash).ScanCAS()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete reset token")
+	}
+	if !applied {
+		return derrors.NewNotFoundError("reset token")
+	}
+	
+	return nil
+}
+
+// DeleteByUsername removes all the reset tokens of a user. The tokens are found with the index on the username.
+func (sp *ScyllaResetTokenProvider) DeleteByUsername(username string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	tokens := make([]entities.PasswordResetTokenData, 0)
+	stmt, names := qb.Select(table).Where(qb.Eq("username")).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		"username": username,
+	})
+	cqlErr := gocqlx.Select(&tokens, q.Query)
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot list reset tokens")
+	}
+	
+	stmt, _ = qb.Delete(table).Where(qb.Eq(tablePK)).ToCql()
+	for _, token := range tokens {
+		cqlErr = sp.Session.Query(stmt, token.TokenHash).Exec()
+		if cqlErr != nil {
+			return derrors.AsError(cqlErr, "cannot delete reset token")
+		}
+	}
+	
+	return nil
+}
+
+// Truncate cleans all data.
+func (sp *ScyllaResetTokenProvider) Truncate() derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	err := sp.Session.Query("TRUNCATE TABLE passwordResetTokens").Exec()
+	if err != nil {
+		log.Info().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("failed to truncate the table")
+		return derrors.AsError(err, "cannot truncate reset token table")
+	}
+	
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package revocation
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+	"time"
+)
+
+// RevocationMockup is an in-memory mockup.
+type RevocationMockup struct {
+	sync.Mutex
+	data map[string]entities.RevokedTokenData
+}
+
+// NewRevocationMockup create a new instance of RevocationMockup.
+func NewRevocationMockup() Provider {
+	return &RevocationMockup{data: make(map[string]entities.RevokedTokenData, 0)}
+}
+
+// Add a revoked token.
+func (p *RevocationMockup) Add(token *entities.RevokedTokenData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data[token.TokenID] = *token
+	return nil
+}
+
+// Exist checks if the token was revoked.
+func (p *RevocationMockup) Exist(tokenID string) (*bool, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	_, ok := p.data[tokenID]
+	return &ok, nil
+}
+
+// Truncate cleans all data.
+func (p *RevocationMockup) Truncate() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data = make(map[string]entities.RevokedTokenData, 0)
+	return nil
+}
+
+// DeleteExpiredTokens removes the tokens that are already expired.
+func (p *RevocationMockup) DeleteExpiredTokens() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	now := time.Now().Unix()
+	for id, token := range p.data {
+		if token.ExpirationDate < now {
+			delete(p.data, id)
+		}
+	}
+	return nil
+}
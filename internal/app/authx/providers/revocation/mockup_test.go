@@ -0,0 +1,42 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package revocation
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+var _ = ginkgo.Describe("RevocationMockup", func() {
+	
+	var provider = NewRevocationMockup()
+	
+	RevocationContexts(provider)
+	
+	ginkgo.It("removes the expired tokens", func() {
+		err := provider.Add(entities.NewRevokedTokenData("t1", "u1", time.Now().Add(-time.Second).Unix()))
+		gomega.Expect(err).To(gomega.Succeed())
+		
+		err = provider.DeleteExpiredTokens()
+		gomega.Expect(err).To(gomega.Succeed())
+		exist, err := provider.Exist("t1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*exist).To(gomega.BeFalse())
+	})
+})
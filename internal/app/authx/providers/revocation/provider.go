@@ -0,0 +1,34 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package revocation
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Provider is the interface to store the list of revoked tokens. A token is kept in the list until it expires.
+type Provider interface {
+	// Add a revoked token. Adding a token that is already revoked is not an error.
+	Add(token *entities.RevokedTokenData) derrors.Error
+	// Exist checks if the token was revoked.
+	Exist(tokenID string) (*bool, derrors.Error)
+	// Truncate cleans all data.
+	Truncate() derrors.Error
+	// DeleteExpiredTokens removes the tokens that are already expired.
+	DeleteExpiredTokens() derrors.Error
+}
@@ -0,0 +1,60 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package revocation
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+func RevocationContexts(provider Provider) {
+	
+	ginkgo.Context("with a revoked token", func() {
+		token := entities.NewRevokedTokenData("t1", "u1", time.Now().Add(time.Minute).Unix())
+		ginkgo.BeforeEach(func() {
+			err := provider.Add(token)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("must exist", func() {
+			exist, err := provider.Exist(token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*exist).To(gomega.BeTrue())
+		})
+		
+		ginkgo.It("can be revoked twice", func() {
+			err := provider.Add(token)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+	})
+	ginkgo.Context("empty data store", func() {
+		
+		ginkgo.It("doesn't exist", func() {
+			exist, err := provider.Exist("t1")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*exist).To(gomega.BeFalse())
+		})
+		
+	})
+	ginkgo.AfterEach(func() {
+		err := provider.Truncate()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+}
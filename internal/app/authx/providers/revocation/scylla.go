@@ -0,0 +1,170 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package revocation
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+	"time"
+)
+
+const table = "revokedTokens"
+const tablePK = "token_id"
+
+const rowNotFound = "not found"
+
+// The tokens cannot last more than 3 hours so a revoked token does not need to be stored longer.
+const ttlExpired = time.Duration(3) * time.Hour
+
+type ScyllaRevocationProvider struct {
+	Address  string
+	Port     int
+	KeySpace string
+	sync.Mutex
+	Session *gocql.Session
+}
+
+func NewScyllaRevocationProvider(address string, port int, keyspace string) *ScyllaRevocationProvider {
+	provider := ScyllaRevocationProvider{Address: address, Port: port, KeySpace: keyspace}
+	provider.connect()
+	return &provider
+}
+
+func (sp *ScyllaRevocationProvider) connect() derrors.Error {
+	
+	// connect to the cluster
+	conf := gocql.NewCluster(sp.Address)
+	conf.Keyspace = sp.KeySpace
+	conf.Port = sp.Port
+	
+	session, err := conf.CreateSession()
+	if err != nil {
+		log.Error().Str("provider", "ScyllaRevocationProvider").Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
+		return derrors.AsError(err, "cannot connect")
+	}
+	
+	sp.Session = session
+	return nil
+}
+
+func (sp *ScyllaRevocationProvider) Disconnect() {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if sp.Session != nil {
+		sp.Session.Close()
+		sp.Session = nil
+	}
+	
+}
+
+func (sp *ScyllaRevocationProvider) checkConnectionAndConnect() derrors.Error {
+	
+	if sp.Session != nil {
+		return nil
+	}
+	log.Info().Str("provider", "ScyllaRevocationProvider").Msg("session not connected, trying to connect it!")
+	err := sp.connect()
+	if err != nil {
+		return err
+	}
+	
+	return nil
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// Add a revoked token.
+func (sp *ScyllaRevocationProvider) Add(token *entities.RevokedTokenData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, names := qb.Insert(table).Columns("token_id", "username", "expiration_date").TTL(ttlExpired).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot add revoked token")
+	}
+	
+	return nil
+}
+
+// Exist checks if the token was revoked.
+func (sp *ScyllaRevocationProvider) Exist(tokenID string) (*bool, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	ok := false
+	var returnedId string
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return &ok, err
+	}
+	
+	stmt, names := qb.Select(table).Columns(tablePK).Where(qb.Eq(tablePK)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK: tokenID})
+	
+	err := q.GetRelease(&returnedId)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return &ok, nil
+		} else {
+			return &ok, derrors.AsError(err, "cannot determinate if token is revoked")
+		}
+	}
+	ok = true
+	return &ok, nil
+}
+
+// Truncate cleans all data.
+func (sp *ScyllaRevocationProvider) Truncate() derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	err := sp.Session.Query("TRUNCATE TABLE revokedTokens").Exec()
+	if err != nil {
+		log.Info().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("failed to truncate the table")
+		return derrors.AsError(err, "cannot truncate revoked tokens table")
+	}
+	
+	return nil
+}
+
+func (sp *ScyllaRevocationProvider) DeleteExpiredTokens() derrors.Error {
+	// nothing to do, ttl uses to delete expired tokens
+	return nil
+}
@@ -27,11 +27,16 @@ import (
 type RoleMockup struct {
 	sync.Mutex
 	data map[string]entities.RoleData
+	// mfaRequirements indexed by organization
+	mfaRequirements map[string]entities.MFARequirementData
 }
 
 // NewRoleMockup create a new instance of the RoleMockup structure.
 func NewRoleMockup() Role {
-	return &RoleMockup{data: make(map[string]entities.RoleData, 0)}
+	return &RoleMockup{
+		data:            make(map[string]entities.RoleData, 0),
+		mfaRequirements: make(map[string]entities.MFARequirementData, 0),
+	}
 }
 
 func (p *RoleMockup) unsafeGet(organizationID string, roleID string) (*entities.RoleData, derrors.Error) {
@@ -123,10 +128,42 @@ func (p *RoleMockup) List(organizationID string) ([]entities.RoleData, derrors.E
 	return result, nil
 }
 
+// SetMFARequirement stores the MFA requirement of an organization, replacing the previous one.
+func (p *RoleMockup) SetMFARequirement(requirement *entities.MFARequirementData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.mfaRequirements[requirement.OrganizationID] = *requirement
+	return nil
+}
+
+// GetMFARequirement recovers the MFA requirement of an organization.
+func (p *RoleMockup) GetMFARequirement(organizationID string) (*entities.MFARequirementData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	requirement, ok := p.mfaRequirements[organizationID]
+	if !ok {
+		return nil, derrors.NewNotFoundError("MFA requirement").WithParams(organizationID)
+	}
+	return &requirement, nil
+}
+
+// DeleteMFARequirement removes the MFA requirement of an organization.
+func (p *RoleMockup) DeleteMFARequirement(organizationID string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	_, ok := p.mfaRequirements[organizationID]
+	if !ok {
+		return derrors.NewNotFoundError("MFA requirement").WithParams(organizationID)
+	}
+	delete(p.mfaRequirements, organizationID)
+	return nil
+}
+
 // Truncate clears the provider.
 func (p *RoleMockup) Truncate() derrors.Error {
 	p.Lock()
 	defer p.Unlock()
 	p.data = make(map[string]entities.RoleData, 0)
+	p.mfaRequirements = make(map[string]entities.MFARequirementData, 0)
 	return nil
 }
@@ -36,6 +36,12 @@ type Role interface {
 	Exist(organizationID string, roleID string) (*bool, derrors.Error)
 	// List the roles associated with an organization.
 	List(organizationID string) ([]entities.RoleData, derrors.Error)
+	// SetMFARequirement stores the MFA requirement of an organization, replacing the previous one.
+	SetMFARequirement(requirement *entities.MFARequirementData) derrors.Error
+	// GetMFARequirement recovers the MFA requirement of an organization.
+	GetMFARequirement(organizationID string) (*entities.MFARequirementData, derrors.Error)
+	// DeleteMFARequirement removes the MFA requirement of an organization.
+	DeleteMFARequirement(organizationID string) derrors.Error
 	// Truncate clears the provider.
 	Truncate() derrors.Error
 }
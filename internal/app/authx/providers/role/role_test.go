@@ -110,5 +110,47 @@ func RoleContexts(provider Role) {
 			gomega.Expect(err).To(gomega.HaveOccurred())
 		})
 		
+		ginkgo.It("doesn't have MFA requirements", func() {
+			r, err := provider.GetMFARequirement("o1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(r).To(gomega.BeNil())
+			err = provider.DeleteMFARequirement("o1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+		
+	})
+	ginkgo.Context("with an MFA requirement", func() {
+		requirement := entities.NewMFARequirementData("o1", false, []string{"p1"})
+		ginkgo.BeforeEach(func() {
+			err := provider.SetMFARequirement(requirement)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("must get the requirement", func() {
+			r, err := provider.GetMFARequirement(requirement.OrganizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*r).To(gomega.Equal(*requirement))
+		})
+		
+		ginkgo.It("must replace the requirement", func() {
+			updated := entities.NewMFARequirementData("o1", true, []string{})
+			err := provider.SetMFARequirement(updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			r, err := provider.GetMFARequirement(requirement.OrganizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(r.AllUsers).To(gomega.BeTrue())
+		})
+		
+		ginkgo.It("must delete the requirement", func() {
+			err := provider.DeleteMFARequirement(requirement.OrganizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = provider.GetMFARequirement(requirement.OrganizationID)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+		
+		ginkgo.AfterEach(func() {
+			err := provider.Truncate()
+			gomega.Expect(err).To(gomega.BeNil())
+		})
 	})
 }
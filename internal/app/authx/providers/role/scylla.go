@@ -32,6 +32,9 @@ const table = "roles"
 const tablePK_1 = "organization_id"
 const tablePK_2 = "role_id"
 
+const mfaTable = "mfaRequirements"
+const mfaTablePK = "organization_id"
+
 const rowNotFound = "not found"
 
 type ScyllaRoleProvider struct {
@@ -310,6 +313,77 @@ func (sp *ScyllaRoleProvider) List(organizationID string) ([]entities.RoleData,
 	return result, nil
 }
 
+// SetMFARequirement stores the MFA requirement of an organization, replacing the previous one.
+func (sp *ScyllaRoleProvider) SetMFARequirement(requirement *entities.MFARequirementData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, names := qb.Insert(mfaTable).Columns("organization_id", "all_users", "primitives").ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(requirement)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot set MFA requirement")
+	}
+	
+	return nil
+}
+
+// GetMFARequirement recovers the MFA requirement of an organization.
+func (sp *ScyllaRoleProvider) GetMFARequirement(organizationID string) (*entities.MFARequirementData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	var requirement entities.MFARequirementData
+	stmt, names := qb.Select(mfaTable).Where(qb.Eq(mfaTablePK)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		mfaTablePK: organizationID})
+	
+	err := q.GetRelease(&requirement)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("MFA requirement").WithParams(organizationID)
+		} else {
+			return nil, derrors.AsError(err, "cannot get MFA requirement")
+		}
+	}
+	
+	return &requirement, nil
+}
+
+// DeleteMFARequirement removes the MFA requirement of an organization.
+func (sp *ScyllaRoleProvider) DeleteMFARequirement(organizationID string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(mfaTable).Where(qb.Eq(mfaTablePK)).Existing().ToCql()
+	applied, cqlErr := sp.Session.Query(stmt, organizationID).ScanCAS()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete MFA requirement")
+	}
+	if !applied {
+		return derrors.NewNotFoundError("MFA requirement").WithParams(organizationID)
+	}
+	
+	return nil
+}
+
 // Truncate clears the provider.
 func (sp *ScyllaRoleProvider) Truncate() derrors.Error {
 	sp.Lock()
@@ -326,5 +400,12 @@ func (sp *ScyllaRoleProvider) Truncate() derrors.Error {
 		return dErr
 	}
 	
+	err = sp.Session.Query("TRUNCATE TABLE mfaRequirements").Exec()
+	if err != nil {
+		dErr := derrors.AsError(err, "cannot truncate MFA requirements table")
+		log.Error().Str("trace", dErr.DebugReport()).Msg("failed to truncate the table")
+		return dErr
+	}
+	
 	return nil
 }
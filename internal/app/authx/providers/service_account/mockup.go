@@ -0,0 +1,103 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package service_account
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+)
+
+// ServiceAccountMockup is an in-memory mockup.
+type ServiceAccountMockup struct {
+	sync.Mutex
+	// data contains the service accounts of each organization indexed by serviceAccountID.
+	data map[string]map[string]entities.ServiceAccountData
+}
+
+// NewServiceAccountMockup create a new instance of ServiceAccountMockup.
+func NewServiceAccountMockup() Provider {
+	return &ServiceAccountMockup{data: make(map[string]map[string]entities.ServiceAccountData, 0)}
+}
+
+// Add a new service account.
+func (p *ServiceAccountMockup) Add(account *entities.ServiceAccountData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	accounts, ok := p.data[account.OrganizationID]
+	if !ok {
+		accounts = make(map[string]entities.ServiceAccountData, 0)
+		p.data[account.OrganizationID] = accounts
+	}
+	if _, exists := accounts[account.ServiceAccountID]; exists {
+		return derrors.NewAlreadyExistsError("service account").WithParams(account.OrganizationID, account.ServiceAccountID)
+	}
+	accounts[account.ServiceAccountID] = *account
+	return nil
+}
+
+// Get a service account of an organization.
+func (p *ServiceAccountMockup) Get(organizationID string, serviceAccountID string) (*entities.ServiceAccountData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	account, ok := p.data[organizationID][serviceAccountID]
+	if !ok {
+		return nil, derrors.NewNotFoundError("service account").WithParams(organizationID, serviceAccountID)
+	}
+	return &account, nil
+}
+
+// List the service accounts of an organization.
+func (p *ServiceAccountMockup) List(organizationID string) ([]entities.ServiceAccountData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	result := make([]entities.ServiceAccountData, 0, len(p.data[organizationID]))
+	for _, account := range p.data[organizationID] {
+		result = append(result, account)
+	}
+	return result, nil
+}
+
+// Update an existing service account.
+func (p *ServiceAccountMockup) Update(account *entities.ServiceAccountData) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.data[account.OrganizationID][account.ServiceAccountID]; !ok {
+		return derrors.NewNotFoundError("service account").WithParams(account.OrganizationID, account.ServiceAccountID)
+	}
+	p.data[account.OrganizationID][account.ServiceAccountID] = *account
+	return nil
+}
+
+// Delete a service account.
+func (p *ServiceAccountMockup) Delete(organizationID string, serviceAccountID string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	if _, ok := p.data[organizationID][serviceAccountID]; !ok {
+		return derrors.NewNotFoundError("service account").WithParams(organizationID, serviceAccountID)
+	}
+	delete(p.data[organizationID], serviceAccountID)
+	return nil
+}
+
+// Truncate cleans all data.
+func (p *ServiceAccountMockup) Truncate() derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	p.data = make(map[string]map[string]entities.ServiceAccountData, 0)
+	return nil
+}
@@ -0,0 +1,38 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package service_account
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/stronker/authx/internal/app/authx/entities"
+)
+
+// Provider is the interface to store the service accounts.
+type Provider interface {
+	// Add a new service account.
+	Add(account *entities.ServiceAccountData) derrors.Error
+	// Get a service account of an organization.
+	Get(organizationID string, serviceAccountID string) (*entities.ServiceAccountData, derrors.Error)
+	// List the service accounts of an organization.
+	List(organizationID string) ([]entities.ServiceAccountData, derrors.Error)
+	// Update an existing service account.
+	Update(account *entities.ServiceAccountData) derrors.Error
+	// Delete a service account.
+	Delete(organizationID string, serviceAccountID string) derrors.Error
+	// Truncate cleans all data.
+	Truncate() derrors.Error
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package service_account
+
+import (
+	"github.com/gocql/gocql"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"github.com/scylladb/gocqlx"
+	"github.com/scylladb/gocqlx/qb"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"sync"
+)
+
+const table = "serviceAccounts"
+const tablePK_1 = "organization_id"
+const tablePK_2 = "service_account_id"
+
+const rowNotFound = "not found"
+
+type ScyllaServiceAccountProvider struct {
+	Address  string
+	Port     int
+	KeySpace string
+	sync.Mutex
+	Session *gocql.Session
+}
+
+func NewScyllaServiceAccountProvider(address string, port int, keyspace string) *ScyllaServiceAccountProvider {
+	provider := ScyllaServiceAccountProvider{Address: address, Port: port, KeySpace: keyspace}
+	provider.connect()
+	return &provider
+}
+
+func (sp *ScyllaServiceAccountProvider) connect() derrors.Error {
+	
+	// connect to the cluster
+	conf := gocql.NewCluster(sp.Address)
+	conf.Keyspace = sp.KeySpace
+	conf.Port = sp.Port
+	
+	session, err := conf.CreateSession()
+	if err != nil {
+		log.Error().Str("provider", "ScyllaServiceAccountProvider").Str("trace", conversions.ToDerror(err).DebugReport()).Msg("unable to connect")
+		return derrors.AsError(err, "cannot connect")
+	}
+	
+	sp.Session = session
+	return nil
+}
+
+func (sp *ScyllaServiceAccountProvider) Disconnect() {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if sp.Session != nil {
+		sp.Session.Close()
+		sp.Session = nil
+	}
+	
+}
+
+func (sp *ScyllaServiceAccountProvider) checkConnectionAndConnect() derrors.Error {
+	
+	if sp.Session != nil {
+		return nil
+	}
+	log.Info().Str("provider", "ScyllaServiceAccountProvider").Msg("session not connected, trying to connect it!")
+	err := sp.connect()
+	if err != nil {
+		return err
+	}
+	
+	return nil
+}
+
+func (sp *ScyllaServiceAccountProvider) unsafeGet(organizationID string, serviceAccountID string) (*entities.ServiceAccountData, derrors.Error) {
+	
+	var account entities.ServiceAccountData
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK_1: organizationID,
+		tablePK_2: serviceAccountID})
+	
+	err := q.GetRelease(&account)
+	if err != nil {
+		if err.Error() == rowNotFound {
+			return nil, derrors.NewNotFoundError("service account").WithParams(organizationID, serviceAccountID)
+		} else {
+			return nil, derrors.AsError(err, "cannot get service account")
+		}
+	}
+	
+	return &account, nil
+}
+
+// --------------------------------------------------------------------------------------------------------------------
+
+// Add a new service account.
+func (sp *ScyllaServiceAccountProvider) Add(account *entities.ServiceAccountData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	_, err := sp.unsafeGet(account.OrganizationID, account.ServiceAccountID)
+	if err == nil {
+		return derrors.NewAlreadyExistsError("service account").WithParams(account.OrganizationID, account.ServiceAccountID)
+	}
+	if err.Type() != derrors.NotFound {
+		return err
+	}
+	
+	stmt, names := qb.Insert(table).Columns("organization_id", "service_account_id", "name", "role_id",
+		"secret_hash", "public_key", "enabled", "creation_date").ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(account)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot add service account")
+	}
+	
+	return nil
+}
+
+// Get a service account of an organization.
+func (sp *ScyllaServiceAccountProvider) Get(organizationID string, serviceAccountID string) (*entities.ServiceAccountData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	return sp.unsafeGet(organizationID, serviceAccountID)
+}
+
+// List the service accounts of an organization.
+func (sp *ScyllaServiceAccountProvider) List(organizationID string) ([]entities.ServiceAccountData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	result := make([]entities.ServiceAccountData, 0)
+	
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK_1)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK_1: organizationID,
+	})
+	
+	cqlErr := gocqlx.Select(&result, q.Query)
+	
+	if cqlErr != nil {
+		return nil, derrors.AsError(cqlErr, "cannot list service accounts")
+	}
+	
+	return result, nil
+}
+
+// Update an existing service account.
+func (sp *ScyllaServiceAccountProvider) Update(account *entities.ServiceAccountData) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	_, err := sp.unsafeGet(account.OrganizationID, account.ServiceAccountID)
+	if err != nil {
+		return err
+	}
+	
+	stmt, names := qb.Update(table).Set("name", "role_id", "secret_hash", "public_key", "enabled").
+		Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(account)
+	cqlErr := q.ExecRelease()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot update service account")
+	}
+	
+	return nil
+}
+
+// Delete a service account.
+func (sp *ScyllaServiceAccountProvider) Delete(organizationID string, serviceAccountID string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).Existing().ToCql()
+	applied, cqlErr := sp.Session.Query(stmt, organizationID, serviceAccountID).ScanCAS()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete service account")
+	}
+	if !applied {
+		return derrors.NewNotFoundError("service account").WithParams(organizationID, serviceAccountID)
+	}
+	
+	return nil
+}
+
+// Truncate cleans all data.
+func (sp *ScyllaServiceAccountProvider) Truncate() derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	err := sp.Session.Query("TRUNCATE TABLE serviceAccounts").Exec()
+	if err != nil {
+		log.Info().Str("trace", conversions.ToDerror(err).DebugReport()).Msg("failed to truncate the table")
+		return derrors.AsError(err, "cannot truncate service account table")
+	}
+	
+	return nil
+}
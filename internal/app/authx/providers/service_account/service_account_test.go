@@ -0,0 +1,109 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package service_account
+
+import (
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"github.com/stronker/authx/internal/app/authx/entities"
+	"time"
+)
+
+func ServiceAccountContexts(provider Provider) {
+	
+	ginkgo.Context("with a service account", func() {
+		account := entities.NewServiceAccountData("o1", "sa1", "deployer", "r1", "h1", "", time.Now().Unix())
+		ginkgo.BeforeEach(func() {
+			err := provider.Add(account)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("cannot add the service account twice", func() {
+			err := provider.Add(account)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.AlreadyExists))
+		})
+		
+		ginkgo.It("must get the service account", func() {
+			retrieved, err := provider.Get(account.OrganizationID, account.ServiceAccountID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(*account))
+		})
+		
+		ginkgo.It("must list the service accounts of the organization", func() {
+			other := entities.NewServiceAccountData("o1", "sa2", "monitor", "r1", "", "key", time.Now().Unix())
+			err := provider.Add(other)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			list, err := provider.List(account.OrganizationID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.ConsistOf(*account, *other))
+			
+			list, err = provider.List("o2")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.BeEmpty())
+		})
+		
+		ginkgo.It("must update the service account", func() {
+			updated := *account
+			updated.Enabled = false
+			updated.RoleID = "r2"
+			err := provider.Update(&updated)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			retrieved, err := provider.Get(account.OrganizationID, account.ServiceAccountID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*retrieved).To(gomega.Equal(updated))
+		})
+		
+		ginkgo.It("must delete the service account", func() {
+			err := provider.Delete(account.OrganizationID, account.ServiceAccountID)
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			_, err = provider.Get(account.OrganizationID, account.ServiceAccountID)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+	})
+	ginkgo.Context("empty data store", func() {
+		
+		ginkgo.It("doesn't have the service account", func() {
+			_, err := provider.Get("o1", "sa1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("cannot update a service account that does not exist", func() {
+			err := provider.Update(entities.NewServiceAccountData("o1", "sa1", "deployer", "r1", "h1", "", 0))
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+		ginkgo.It("cannot delete a service account that does not exist", func() {
+			err := provider.Delete("o1", "sa1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		})
+		
+	})
+	ginkgo.AfterEach(func() {
+		err := provider.Truncate()
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+}
@@ -108,6 +108,48 @@ func (p *TokenMockup) Update(token *entities.TokenData) derrors.Error {
 	return nil
 }
 
+// Consume marks a refresh token as used. It returns false if the token had already been consumed.
+func (p *TokenMockup) Consume(username string, tokenID string) (bool, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	
+	data, err := p.unsafeGet(username, tokenID)
+	if err != nil {
+		return false, err
+	}
+	if data.Consumed {
+		return false, nil
+	}
+	data.Consumed = true
+	p.data[p.generateID(tokenID, username)] = *data
+	return true, nil
+}
+
+// List the tokens of a user.
+func (p *TokenMockup) List(username string) ([]entities.TokenData, derrors.Error) {
+	p.Lock()
+	defer p.Unlock()
+	result := make([]entities.TokenData, 0)
+	for _, token := range p.data {
+		if token.Username == username {
+			result = append(result, token)
+		}
+	}
+	return result, nil
+}
+
+// DeleteByUsername removes all the tokens of a user.
+func (p *TokenMockup) DeleteByUsername(username string) derrors.Error {
+	p.Lock()
+	defer p.Unlock()
+	for id, token := range p.data {
+		if token.Username == username {
+			delete(p.data, id)
+		}
+	}
+	return nil
+}
+
 // Truncate cleans all data.
 func (p *TokenMockup) Truncate() derrors.Error {
 	p.Lock()
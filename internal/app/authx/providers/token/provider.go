@@ -34,6 +34,13 @@ type Token interface {
 	Exist(username string, tokenID string) (*bool, derrors.Error)
 	// Update an existing token
 	Update(token *entities.TokenData) derrors.Error
+	// Consume marks a refresh token as used. It returns false if the token had already been consumed, so only one
+	// of the concurrent consumptions succeeds.
+	Consume(username string, tokenID string) (bool, derrors.Error)
+	// List the tokens of a user.
+	List(username string) ([]entities.TokenData, derrors.Error)
+	// DeleteByUsername removes all the tokens of a user.
+	DeleteByUsername(username string) derrors.Error
 	// Truncate cleans all data.
 	Truncate() derrors.Error
 	
@@ -18,6 +18,7 @@
 package token
 
 import (
+	"fmt"
 	"github.com/gocql/gocql"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-utils/pkg/conversions"
@@ -197,7 +198,8 @@ func (sp *ScyllaTokenProvider) Add(token *entities.TokenData) derrors.Error {
 	}
 	
 	// add new basic credential
-	stmt, names := qb.Insert(table).Columns("username", "token_id", "refresh_token", "expiration_date").TTL(ttlExpired).ToCql()
+	stmt, names := qb.Insert(table).Columns("username", "token_id", "refresh_token", "expiration_date", "family_id", "consumed",
+		"issued_at", "last_refresh", "client_ip", "user_agent", "token_source_id").TTL(ttlExpired).ToCql()
 	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
 	cqlErr := q.ExecRelease()
 	
@@ -283,7 +285,8 @@ func (sp *ScyllaTokenProvider) Update(token *entities.TokenData) derrors.Error {
 	}
 	
 	// add new basic credential
-	stmt, names := qb.Update(table).Set("expiration_date", "refresh_token").
+	stmt, names := qb.Update(table).Set("expiration_date", "refresh_token", "family_id", "consumed", "issued_at", "last_refresh",
+		"client_ip", "user_agent", "token_source_id").
 		Where(qb.Eq(tablePK_1)).Where(qb.Eq(tablePK_2)).TTL(ttlExpired).
 		ToCql()
 	q := gocqlx.Query(sp.Session.Query(stmt), names).BindStruct(token)
@@ -296,6 +299,80 @@ func (sp *ScyllaTokenProvider) Update(token *entities.TokenData) derrors.Error {
 	return nil
 }
 
+// Consume marks a refresh token as used. The update is conditional so only one of the concurrent consumptions is
+// applied.
+func (sp *ScyllaTokenProvider) Consume(username string, tokenID string) (bool, derrors.Error) {
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return false, err
+	}
+	
+	stmt := fmt.Sprintf("UPDATE %s USING TTL %d SET consumed = true WHERE %s = ? AND %s = ? IF consumed != true",
+		table, int(ttlExpired.Seconds()), tablePK_1, tablePK_2)
+	previous := make(map[string]interface{})
+	applied, cqlErr := sp.Session.Query(stmt, username, tokenID).MapScanCAS(previous)
+	
+	if cqlErr != nil {
+		return false, derrors.AsError(cqlErr, "cannot consume token")
+	}
+	if !applied {
+		if _, found := previous["consumed"]; !found {
+			return false, derrors.NewNotFoundError("token").WithParams(username, tokenID)
+		}
+		return false, nil
+	}
+	
+	return true, nil
+}
+
+// List the tokens of a user.
+func (sp *ScyllaTokenProvider) List(username string) ([]entities.TokenData, derrors.Error) {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return nil, err
+	}
+	
+	result := make([]entities.TokenData, 0)
+	
+	stmt, names := qb.Select(table).Where(qb.Eq(tablePK_1)).ToCql()
+	q := gocqlx.Query(sp.Session.Query(stmt), names).BindMap(qb.M{
+		tablePK_1: username,
+	})
+	
+	cqlErr := gocqlx.Select(&result, q.Query)
+	
+	if cqlErr != nil {
+		return nil, derrors.AsError(cqlErr, "cannot list tokens")
+	}
+	
+	return result, nil
+}
+
+// DeleteByUsername removes all the tokens of a user.
+func (sp *ScyllaTokenProvider) DeleteByUsername(username string) derrors.Error {
+	
+	sp.Lock()
+	defer sp.Unlock()
+	
+	if err := sp.checkConnectionAndConnect(); err != nil {
+		return err
+	}
+	
+	stmt, _ := qb.Delete(table).Where(qb.Eq(tablePK_1)).ToCql()
+	cqlErr := sp.Session.Query(stmt, username).Exec()
+	
+	if cqlErr != nil {
+		return derrors.AsError(cqlErr, "cannot delete tokens")
+	}
+	
+	return nil
+}
+
 // Truncate cleans all data.
 func (sp *ScyllaTokenProvider) Truncate() derrors.Error {
 	
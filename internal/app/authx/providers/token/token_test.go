@@ -51,6 +51,36 @@ func TokenContexts(provider Token) {
 			gomega.Expect(t).To(gomega.BeNil())
 			
 		})
+		ginkgo.It("can list and delete the tokens of the user", func() {
+			err := provider.Add(entities.NewTokenData(token.Username, "t2", []byte("r2"), time.Now().Unix()))
+			gomega.Expect(err).To(gomega.Succeed())
+			err = provider.Add(entities.NewTokenData("u2", "t3", []byte("r3"), time.Now().Unix()))
+			gomega.Expect(err).To(gomega.Succeed())
+			
+			list, err := provider.List(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.HaveLen(2))
+			
+			err = provider.DeleteByUsername(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			list, err = provider.List(token.Username)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.BeEmpty())
+			list, err = provider.List("u2")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(list).To(gomega.HaveLen(1))
+		})
+		ginkgo.It("can consume the token only once", func() {
+			consumed, err := provider.Consume(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(consumed).To(gomega.BeTrue())
+			consumed, err = provider.Consume(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(consumed).To(gomega.BeFalse())
+			t, err := provider.Get(token.Username, token.TokenID)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(t.Consumed).To(gomega.BeTrue())
+		})
 		ginkgo.It("should be able to update the token", func() {
 			token.ExpirationDate = time.Now().Add(time.Second * 2).Unix()
 			token.RefreshToken = []byte("r2")
@@ -83,6 +113,17 @@ func TokenContexts(provider Token) {
 			gomega.Expect(err).To(gomega.HaveOccurred())
 		})
 		
+		ginkgo.It("consume doesn't work", func() {
+			consumed, err := provider.Consume("u1", "t1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(consumed).To(gomega.BeFalse())
+		})
+		
+		ginkgo.It("delete by username of an unknown user works", func() {
+			err := provider.DeleteByUsername("u1")
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
 	})
 	ginkgo.AfterEach(func() {
 		err := provider.Truncate()
@@ -19,24 +19,38 @@ package authx
 
 import (
 	"fmt"
+	nalejToken "github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
 	pbAuthx "github.com/nalej/grpc-authx-go"
 	"github.com/rs/zerolog/log"
 	"github.com/stronker/authx/internal/app/authx/certificates"
 	"github.com/stronker/authx/internal/app/authx/config"
 	"github.com/stronker/authx/internal/app/authx/handler"
 	"github.com/stronker/authx/internal/app/authx/inventory"
+	"github.com/stronker/authx/internal/app/authx/jwks"
 	"github.com/stronker/authx/internal/app/authx/manager"
+	"github.com/stronker/authx/internal/app/authx/providers/access_token"
 	"github.com/stronker/authx/internal/app/authx/providers/credentials"
 	"github.com/stronker/authx/internal/app/authx/providers/device"
 	"github.com/stronker/authx/internal/app/authx/providers/device_token"
 	inventoryProv "github.com/stronker/authx/internal/app/authx/providers/inventory"
+	"github.com/stronker/authx/internal/app/authx/providers/lockout"
+	"github.com/stronker/authx/internal/app/authx/providers/reset_token"
+	"github.com/stronker/authx/internal/app/authx/providers/revocation"
 	"github.com/stronker/authx/internal/app/authx/providers/role"
+	"github.com/stronker/authx/internal/app/authx/providers/service_account"
 	"github.com/stronker/authx/internal/app/authx/providers/token"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 	"net"
+	"net/http"
+	"os"
+	"time"
 )
 
+// KeyRingReloadPeriod is the period to check if the key ring descriptor has changed.
+const KeyRingReloadPeriod = time.Minute
+
 // Service is the Authx service instance.
 type Service struct {
 	// Config is the required parameters.
@@ -47,9 +61,14 @@ type Providers struct {
 	roleProvider      role.Role
 	credProvider      credentials.BasicCredentials
 	devProvider       device.Provider
-	tokenProvider     token.Token
-	devTokenProvider  device_token.Provider
-	inventoryProvider inventoryProv.Provider
+	tokenProvider      token.Token
+	revocationProvider revocation.Provider
+	devTokenProvider   device_token.Provider
+	inventoryProvider  inventoryProv.Provider
+	lockoutProvider    lockout.Provider
+	resetTokenProvider reset_token.Provider
+	accessTokenProvider access_token.Provider
+	serviceAccountProvider service_account.Provider
 }
 
 type TokenManagers struct {
@@ -67,9 +86,14 @@ func (s *Service) CreateInMemoryProviders() *Providers {
 		roleProvider:      role.NewRoleMockup(),
 		credProvider:      credentials.NewBasicCredentialMockup(),
 		devProvider:       device.NewMockupDeviceCredentialsProvider(),
-		tokenProvider:     token.NewTokenMockup(),
-		devTokenProvider:  device_token.NewDeviceTokenMockup(),
-		inventoryProvider: inventoryProv.NewMockupInventoryProvider(),
+		tokenProvider:      token.NewTokenMockup(),
+		revocationProvider: revocation.NewRevocationMockup(),
+		devTokenProvider:   device_token.NewDeviceTokenMockup(),
+		inventoryProvider:  inventoryProv.NewMockupInventoryProvider(),
+		lockoutProvider:    lockout.NewLockoutMockup(),
+		resetTokenProvider: reset_token.NewResetTokenMockup(),
+		accessTokenProvider: access_token.NewAccessTokenMockup(),
+		serviceAccountProvider: service_account.NewServiceAccountMockup(),
 	}
 }
 
@@ -83,10 +107,20 @@ func (s *Service) CreateDBScyllaProviders() *Providers {
 			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
 		tokenProvider: token.NewScyllaTokenProvider(
 			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
+		revocationProvider: revocation.NewScyllaRevocationProvider(
+			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
 		devTokenProvider: device_token.NewScyllaDeviceTokenProvider(
 			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
 		// TODO Use an scylladb provider
 		inventoryProvider: inventoryProv.NewMockupInventoryProvider(),
+		lockoutProvider: lockout.NewScyllaLockoutProvider(
+			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
+		resetTokenProvider: reset_token.NewScyllaResetTokenProvider(
+			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
+		accessTokenProvider: access_token.NewScyllaAccessTokenProvider(
+			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
+		serviceAccountProvider: service_account.NewScyllaServiceAccountProvider(
+			s.Config.ScyllaDBAddress, s.Config.ScyllaDBPort, s.Config.KeySpace),
 	}
 }
 
@@ -101,31 +135,149 @@ func (s *Service) GetProviders() *Providers {
 	return nil
 }
 
-func (s *Service) createInMemoryManagers() *TokenManagers {
+func (s *Service) createInMemoryManagers(tokenProvider token.Token, revocationProvider revocation.Provider, password manager.Password,
+	deviceProvider device.Provider, deviceTokenProvider device_token.Provider, keyRing *manager.KeyRing) *TokenManagers {
 	return &TokenManagers{
-		tokenManager:       manager.NewJWTTokenMockup(),
-		deviceTokenManager: manager.NewJWTDeviceTokenMockup(),
+		tokenManager:       manager.NewJWTTokenWithAudience(tokenProvider, revocationProvider, password, keyRing, s.TokenAudience),
+		deviceTokenManager: manager.NewJWTDeviceToken(deviceProvider, deviceTokenProvider),
 	}
 }
-func (s *Service) createDBScyllaManagers(tokenProvider token.Token, password manager.Password,
-	deviceProvider device.Provider, deviceTokenProvider device_token.Provider) *TokenManagers {
+func (s *Service) createDBScyllaManagers(tokenProvider token.Token, revocationProvider revocation.Provider, password manager.Password,
+	deviceProvider device.Provider, deviceTokenProvider device_token.Provider, keyRing *manager.KeyRing) *TokenManagers {
 	return &TokenManagers{
-		tokenManager:       manager.NewJWTToken(tokenProvider, password),
+		tokenManager:       manager.NewJWTTokenWithAudience(tokenProvider, revocationProvider, password, keyRing, s.TokenAudience),
 		deviceTokenManager: manager.NewJWTDeviceToken(deviceProvider, deviceTokenProvider),
 	}
 }
 
-func (s *Service) getTokenManager(tokenProvider token.Token, password manager.Password,
-	deviceProvider device.Provider, deviceTokenProvider device_token.Provider) *TokenManagers {
+func (s *Service) getTokenManager(tokenProvider token.Token, revocationProvider revocation.Provider, password manager.Password,
+	deviceProvider device.Provider, deviceTokenProvider device_token.Provider, keyRing *manager.KeyRing) *TokenManagers {
 	if s.Config.UseInMemoryProviders {
-		return s.createInMemoryManagers()
+		return s.createInMemoryManagers(tokenProvider, revocationProvider, password, deviceProvider, deviceTokenProvider, keyRing)
 	} else if s.Config.UseDBScyllaProviders {
-		return s.createDBScyllaManagers(tokenProvider, password, deviceProvider, deviceTokenProvider)
+		return s.createDBScyllaManagers(tokenProvider, revocationProvider, password, deviceProvider, deviceTokenProvider, keyRing)
 	}
 	log.Fatal().Msg("unsupported type of provider")
 	return nil
 }
 
+// getArgon2idParams builds the parameters of the Argon2id password hashing.
+func (s *Service) getArgon2idParams() manager.Argon2idParams {
+	params := manager.NewDefaultArgon2idParams()
+	params.Memory = s.Config.Argon2Memory
+	params.Iterations = s.Config.Argon2Iterations
+	params.Parallelism = s.Config.Argon2Parallelism
+	return params
+}
+
+// getPasswordPolicies loads the policies the passwords must satisfy and the deny list of common passwords.
+func (s *Service) getPasswordPolicies() *manager.PasswordPolicies {
+	policies := manager.NewPasswordPolicies(manager.NewDefaultPasswordPolicy())
+	if s.Config.PasswordPolicyPath != "" {
+		loaded, err := manager.LoadPasswordPolicies(s.Config.PasswordPolicyPath)
+		if err != nil {
+			log.Fatal().Str("trace", err.DebugReport()).Msg("cannot load password policies")
+		}
+		policies = loaded
+	}
+	if s.Config.PasswordDenyListPath != "" {
+		err := policies.LoadDenyList(s.Config.PasswordDenyListPath)
+		if err != nil {
+			log.Fatal().Str("trace", err.DebugReport()).Msg("cannot load password deny list")
+		}
+	}
+	return policies
+}
+
+// getLockoutPolicy builds the policy that protects the basic credentials against brute-force attacks.
+func (s *Service) getLockoutPolicy() manager.LockoutPolicy {
+	policy := manager.NewDefaultLockoutPolicy()
+	policy.MaxUserFailures = s.Config.MaxLoginFailures
+	policy.MaxClientFailures = s.Config.MaxClientLoginFailures
+	policy.LockoutDuration = s.Config.LoginLockoutDuration
+	return policy
+}
+
+// getResetNotifier builds the notifier that delivers the password reset tokens. The password reset is disabled if
+// there is none.
+func (s *Service) getResetNotifier() manager.Notifier {
+	switch s.Config.ResetNotifier {
+	case "file":
+		return manager.NewFileNotifier(s.Config.ResetNotificationPath)
+	case "log":
+		return manager.NewLogNotifier()
+	}
+	return nil
+}
+
+// getSigningKey loads the key used to sign the user tokens. The shared secret is used if no private key is set.
+func (s *Service) getSigningKey() *nalejToken.SigningKey {
+	if s.Config.SigningKeyPath == "" {
+		return nalejToken.NewHMACSigningKey("", s.Secret)
+	}
+	key, err := nalejToken.LoadSigningKey(s.Config.SigningKeyID, s.Config.SigningKeyPath)
+	if err != nil {
+		log.Fatal().Str("trace", err.DebugReport()).Msg("cannot load signing key")
+	}
+	return key
+}
+
+// getKeyRing loads the keys used to sign the user tokens. A key ring with a single key is used if no key ring
+// descriptor is set.
+func (s *Service) getKeyRing() *manager.KeyRing {
+	if s.Config.KeyRingPath == "" {
+		return manager.NewKeyRingWithKey(s.getSigningKey())
+	}
+	keyRing, err := s.loadKeyRing()
+	if err != nil {
+		log.Fatal().Str("trace", err.DebugReport()).Msg("cannot load signing key ring")
+	}
+	return keyRing
+}
+
+func (s *Service) loadKeyRing() (*manager.KeyRing, derrors.Error) {
+	descriptor, err := manager.LoadKeyRingDescriptor(s.Config.KeyRingPath)
+	if err != nil {
+		return nil, err
+	}
+	return descriptor.Build()
+}
+
+// watchKeyRing reloads the key ring when the descriptor is modified. This allows to promote new keys without
+// restarting the service.
+func (s *Service) watchKeyRing(keyRing *manager.KeyRing) {
+	lastModified := time.Now()
+	ticker := time.NewTicker(KeyRingReloadPeriod)
+	for range ticker.C {
+		info, err := os.Stat(s.Config.KeyRingPath)
+		if err != nil {
+			log.Warn().Err(err).Msg("cannot check signing key ring")
+			continue
+		}
+		if !info.ModTime().After(lastModified) {
+			continue
+		}
+		lastModified = info.ModTime()
+		loaded, lErr := s.loadKeyRing()
+		if lErr != nil {
+			log.Error().Str("trace", lErr.DebugReport()).Msg("cannot reload signing key ring")
+			continue
+		}
+		keyRing.Replace(loaded)
+		log.Info().Msg("signing key ring reloaded")
+	}
+}
+
+// launchJWKSServer publishes the public signing keys.
+func (s *Service) launchJWKSServer(keyRing *manager.KeyRing) {
+	mux := http.NewServeMux()
+	mux.Handle(jwks.Path, jwks.NewHandler(keyRing))
+	log.Info().Int("Port", s.JWKSPort).Msg("Launching JWKS server")
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", s.JWKSPort), mux); err != nil {
+		log.Fatal().Errs("failed to serve JWKS: %v", []error{err})
+	}
+}
+
 //Run launch the Authx service.
 func (s *Service) Run() {
 	vErr := s.Config.Validate()
@@ -141,17 +293,30 @@ func (s *Service) Run() {
 		return
 	}
 	
-	passwordMgr := manager.NewBCryptPassword()
+	passwordMgr, pErr := manager.NewPassword(s.Config.PasswordHash, s.Config.BCryptCost, s.getArgon2idParams())
+	if pErr != nil {
+		log.Fatal().Str("trace", pErr.DebugReport()).Msg("cannot create password manager")
+	}
+	keyRing := s.getKeyRing()
 	
 	// Create the token manager (memory/scylla)
-	t := s.getTokenManager(p.tokenProvider, passwordMgr, p.devProvider, p.devTokenProvider)
+	t := s.getTokenManager(p.tokenProvider, p.revocationProvider, passwordMgr, p.devProvider, p.devTokenProvider, keyRing)
 	tokenMgr := t.tokenManager
 	deviceMgr := t.deviceTokenManager
 	
+	lockoutMgr := manager.NewProviderLockout(p.lockoutProvider, s.getLockoutPolicy())
+	resetMgr := manager.NewPasswordReset(p.resetTokenProvider, s.getResetNotifier(), s.ResetTokenExpiration)
+	accessTokensMgr := manager.NewAccessTokens(p.accessTokenProvider, s.MaxAccessTokenExpiration)
+	
 	authxMgr := manager.NewAuthx(passwordMgr, tokenMgr, deviceMgr, p.credProvider, p.roleProvider, p.devProvider,
-		s.Secret, s.ExpirationTime, s.DeviceExpirationTime, p.devTokenProvider)
+		s.Secret, s.ExpirationTime, s.DeviceExpirationTime, p.devTokenProvider, lockoutMgr, s.getPasswordPolicies(),
+		resetMgr, accessTokensMgr, p.serviceAccountProvider)
 	
-	h := handler.NewAuthx(authxMgr)
+	trustedProxies, tpErr := s.Config.TrustedProxyNetworks()
+	if tpErr != nil {
+		log.Fatal().Str("trace", tpErr.DebugReport()).Msg("cannot parse trusted proxies")
+	}
+	h := handler.NewAuthxWithTrustedProxies(authxMgr, s.Config.ClientIPHeader, trustedProxies)
 	
 	inventoryManager := inventory.NewManager(p.inventoryProvider, s.Config)
 	inventoryHandler := inventory.NewHandler(inventoryManager)
@@ -176,6 +341,13 @@ func (s *Service) Run() {
 		reflection.Register(grpcServer)
 	}
 	
+	if s.Config.KeyRingPath != "" {
+		go s.watchKeyRing(keyRing)
+		go s.launchJWKSServer(keyRing)
+	} else if s.Config.SigningKeyPath != "" {
+		go s.launchJWKSServer(keyRing)
+	}
+	
 	log.Info().Int("Port", s.Port).Msg("Launching gRPC server")
 	if err := grpcServer.Serve(lis); err != nil {
 		log.Fatal().Errs("failed to serve: %v", []error{err})
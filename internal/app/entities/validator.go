@@ -163,3 +163,13 @@ func ValidRefreshToken(request *grpc_authx_go.RefreshTokenRequest) derrors.Error
 	}
 	return nil
 }
+
+func ValidMFARequirement(request *grpc_authx_go.MFARequirement) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError(emptyOrganizationId)
+	}
+	if !request.AllUsers && len(request.Primitives) == 0 {
+		return derrors.NewInvalidArgumentError("MFA requirement must include all users or a primitive")
+	}
+	return nil
+}
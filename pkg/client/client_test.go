@@ -0,0 +1,296 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// authxMockup is an authx client that issues tokens with a given expiration.
+type authxMockup struct {
+	pbAuthx.AuthxClient
+	sync.Mutex
+	expiration    time.Duration
+	delay         time.Duration
+	logins        int
+	refreshes     int
+	deviceLogins  int
+	refreshToken  string
+	rejectRefresh bool
+}
+
+func (a *authxMockup) response() *pbAuthx.LoginResponse {
+	issued := a.logins + a.deviceLogins + a.refreshes
+	claims := &jwt.StandardClaims{Id: strconv.Itoa(issued), ExpiresAt: time.Now().Add(a.expiration).Unix()}
+	token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	a.refreshToken = "refresh" + strconv.Itoa(issued)
+	return &pbAuthx.LoginResponse{Token: token, RefreshToken: a.refreshToken}
+}
+
+func (a *authxMockup) LoginWithBasicCredentials(_ context.Context, in *pbAuthx.LoginWithBasicCredentialsRequest, _ ...grpc.CallOption) (*pbAuthx.LoginResponse, error) {
+	time.Sleep(a.delay)
+	a.Lock()
+	defer a.Unlock()
+	if in.Password != "pass" {
+		return nil, status.Error(codes.Unauthenticated, "invalid credentials")
+	}
+	a.logins++
+	return a.response(), nil
+}
+
+func (a *authxMockup) RefreshToken(_ context.Context, in *pbAuthx.RefreshTokenRequest, _ ...grpc.CallOption) (*pbAuthx.LoginResponse, error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.rejectRefresh || in.RefreshToken != a.refreshToken {
+		return nil, status.Error(codes.Unauthenticated, "invalid refresh token")
+	}
+	a.refreshes++
+	return a.response(), nil
+}
+
+func (a *authxMockup) DeviceLogin(_ context.Context, in *pbAuthx.DeviceLoginRequest, _ ...grpc.CallOption) (*pbAuthx.LoginResponse, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.deviceLogins++
+	return a.response(), nil
+}
+
+func (a *authxMockup) RefreshDeviceToken(ctx context.Context, in *pbAuthx.RefreshTokenRequest, opts ...grpc.CallOption) (*pbAuthx.LoginResponse, error) {
+	return a.RefreshToken(ctx, in, opts...)
+}
+
+// streamMockup is a client stream that rejects the first message if it was created with a rejected token.
+type streamMockup struct {
+	grpc.ClientStream
+	token    string
+	rejected string
+	sent     []interface{}
+	closed   bool
+}
+
+func (s *streamMockup) SendMsg(m interface{}) error {
+	s.sent = append(s.sent, m)
+	return nil
+}
+
+func (s *streamMockup) CloseSend() error {
+	s.closed = true
+	return nil
+}
+
+func (s *streamMockup) RecvMsg(_ interface{}) error {
+	if s.token == s.rejected {
+		return status.Error(codes.Unauthenticated, "token has been revoked")
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("Session", func() {
+
+	ginkgo.It("should reuse a valid token", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		token, err := session.Token(context.Background())
+		gomega.Expect(err).To(gomega.Succeed())
+		for i := 0; i < 3; i++ {
+			other, err := session.Token(context.Background())
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(other).To(gomega.Equal(token))
+		}
+		gomega.Expect(authx.logins).To(gomega.Equal(1))
+		gomega.Expect(authx.refreshes).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should refresh a token before it expires", func() {
+		authx := &authxMockup{expiration: 30 * time.Second}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		token, err := session.Token(context.Background())
+		gomega.Expect(err).To(gomega.Succeed())
+		refreshed, err := session.Token(context.Background())
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(refreshed).NotTo(gomega.Equal(token))
+		gomega.Expect(authx.logins).To(gomega.Equal(1))
+		gomega.Expect(authx.refreshes).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should login again if the token cannot be refreshed", func() {
+		authx := &authxMockup{expiration: 30 * time.Second, rejectRefresh: true}
+		session := NewSession(authx, NewDeviceCredentials("o1", "apiKey"))
+		for i := 0; i < 2; i++ {
+			_, err := session.Token(context.Background())
+			gomega.Expect(err).To(gomega.Succeed())
+		}
+		gomega.Expect(authx.deviceLogins).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should use the initial refresh token only once", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		initial, err := authx.LoginWithBasicCredentials(context.Background(),
+			&pbAuthx.LoginWithBasicCredentialsRequest{Username: "u1", Password: "pass"})
+		gomega.Expect(err).To(gomega.Succeed())
+		credentials := NewRefreshTokenCredentials(initial.Token, initial.RefreshToken)
+		session := NewSession(authx, credentials)
+		_, dErr := session.Token(context.Background())
+		gomega.Expect(dErr).To(gomega.Succeed())
+		gomega.Expect(authx.refreshes).To(gomega.Equal(1))
+
+		_, err = credentials.Login(context.Background(), authx)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should share the renewal of the token among concurrent calls", func() {
+		authx := &authxMockup{expiration: time.Hour, delay: 100 * time.Millisecond}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		tokens := make([]string, 5)
+		var wg sync.WaitGroup
+		for i := range tokens {
+			wg.Add(1)
+			go func(i int) {
+				defer ginkgo.GinkgoRecover()
+				defer wg.Done()
+				token, err := session.Token(context.Background())
+				gomega.Expect(err).To(gomega.Succeed())
+				tokens[i] = token
+			}(i)
+		}
+		wg.Wait()
+		gomega.Expect(authx.logins).To(gomega.Equal(1))
+		for _, token := range tokens {
+			gomega.Expect(token).To(gomega.Equal(tokens[0]))
+		}
+	})
+
+	ginkgo.It("should not wait for the renewal after the context is done", func() {
+		authx := &authxMockup{expiration: time.Hour, delay: time.Second}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		go session.Token(context.Background())
+		time.Sleep(50 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := session.Token(ctx)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should return the login errors", func() {
+		session := NewSession(&authxMockup{expiration: time.Hour}, NewBasicCredentials("u1", "wrong"))
+		_, err := session.Token(context.Background())
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
+
+var _ = ginkgo.Describe("Client interceptor", func() {
+
+	ginkgo.It("should send the token of the session", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		expected, _ := session.Token(context.Background())
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			gomega.Expect(md.Get(DefaultHeader)).To(gomega.Equal([]string{expected}))
+			return nil
+		}
+		err := authxInterceptor(session)(context.Background(), "/test.Test/Method", nil, nil, nil, invoker)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should retry once with a new token", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		tokens := make([]string, 0)
+		invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			tokens = append(tokens, md.Get(DefaultHeader)[0])
+			return status.Error(codes.Unauthenticated, "token has been revoked")
+		}
+		err := authxInterceptor(session)(context.Background(), "/test.Test/Method", nil, nil, nil, invoker)
+		gomega.Expect(status.Code(err)).To(gomega.Equal(codes.Unauthenticated))
+		gomega.Expect(tokens).To(gomega.HaveLen(2))
+		gomega.Expect(tokens[1]).NotTo(gomega.Equal(tokens[0]))
+		gomega.Expect(authx.refreshes).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should create a server stream again if the first message is rejected", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		rejected, _ := session.Token(context.Background())
+		streams := make([]*streamMockup, 0)
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			md, _ := metadata.FromOutgoingContext(ctx)
+			stream := &streamMockup{token: md.Get(DefaultHeader)[0], rejected: rejected}
+			streams = append(streams, stream)
+			return stream, nil
+		}
+		stream, err := authxStreamInterceptor(session)(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil,
+			"/test.Test/Watch", streamer)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(stream.SendMsg("request")).To(gomega.Succeed())
+		gomega.Expect(stream.CloseSend()).To(gomega.Succeed())
+		gomega.Expect(stream.RecvMsg(nil)).To(gomega.Succeed())
+		gomega.Expect(streams).To(gomega.HaveLen(2))
+		gomega.Expect(streams[1].token).NotTo(gomega.Equal(rejected))
+		gomega.Expect(streams[1].sent).To(gomega.Equal([]interface{}{"request"}))
+		gomega.Expect(streams[1].closed).To(gomega.BeTrue())
+		gomega.Expect(authx.refreshes).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should discard the token if the first message of a client stream is rejected", func() {
+		authx := &authxMockup{expiration: time.Hour}
+		session := NewSession(authx, NewBasicCredentials("u1", "pass"))
+		rejected, _ := session.Token(context.Background())
+		calls := 0
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			calls++
+			md, _ := metadata.FromOutgoingContext(ctx)
+			return &streamMockup{token: md.Get(DefaultHeader)[0], rejected: rejected}, nil
+		}
+		stream, err := authxStreamInterceptor(session)(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil,
+			"/test.Test/Upload", streamer)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(stream.SendMsg("chunk1")).To(gomega.Succeed())
+		gomega.Expect(stream.SendMsg("chunk2")).To(gomega.Succeed())
+		gomega.Expect(status.Code(stream.RecvMsg(nil))).To(gomega.Equal(codes.Unauthenticated))
+		gomega.Expect(calls).To(gomega.Equal(1))
+		// the next calls use a new token
+		token, dErr := session.Token(context.Background())
+		gomega.Expect(dErr).To(gomega.Succeed())
+		gomega.Expect(token).NotTo(gomega.Equal(rejected))
+	})
+
+	ginkgo.It("should not retry other errors", func() {
+		session := NewSession(&authxMockup{expiration: time.Hour}, NewBasicCredentials("u1", "pass"))
+		calls := 0
+		streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+			calls++
+			return nil, status.Error(codes.PermissionDenied, "not allowed")
+		}
+		_, err := authxStreamInterceptor(session)(context.Background(), &grpc.StreamDesc{}, nil, "/test.Test/Watch", streamer)
+		gomega.Expect(status.Code(err)).To(gomega.Equal(codes.PermissionDenied))
+		gomega.Expect(calls).To(gomega.Equal(1))
+	})
+})
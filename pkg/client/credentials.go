@@ -0,0 +1,112 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"sync"
+)
+
+// Credentials obtains the tokens used by a client.
+type Credentials interface {
+	// Login obtains a new token from authx.
+	Login(ctx context.Context, client pbAuthx.AuthxClient) (*pbAuthx.LoginResponse, error)
+	// Refresh renews a token using its refresh token.
+	Refresh(ctx context.Context, client pbAuthx.AuthxClient, current *pbAuthx.LoginResponse) (*pbAuthx.LoginResponse, error)
+}
+
+// BasicCredentials logs in a user with a username and a password.
+type BasicCredentials struct {
+	Username string
+	Password string
+}
+
+// NewBasicCredentials creates the credentials of a user.
+func NewBasicCredentials(username string, password string) *BasicCredentials {
+	return &BasicCredentials{Username: username, Password: password}
+}
+
+// Login obtains a new token from authx.
+func (c *BasicCredentials) Login(ctx context.Context, client pbAuthx.AuthxClient) (*pbAuthx.LoginResponse, error) {
+	return client.LoginWithBasicCredentials(ctx, &pbAuthx.LoginWithBasicCredentialsRequest{
+		Username: c.Username,
+		Password: c.Password,
+	})
+}
+
+// Refresh renews a token using its refresh token.
+func (c *BasicCredentials) Refresh(ctx context.Context, client pbAuthx.AuthxClient, current *pbAuthx.LoginResponse) (*pbAuthx.LoginResponse, error) {
+	return client.RefreshToken(ctx, &pbAuthx.RefreshTokenRequest{Token: current.Token, RefreshToken: current.RefreshToken})
+}
+
+// DeviceCredentials logs in a device with its API key.
+type DeviceCredentials struct {
+	OrganizationID string
+	DeviceAPIKey   string
+}
+
+// NewDeviceCredentials creates the credentials of a device.
+func NewDeviceCredentials(organizationID string, deviceAPIKey string) *DeviceCredentials {
+	return &DeviceCredentials{OrganizationID: organizationID, DeviceAPIKey: deviceAPIKey}
+}
+
+// Login obtains a new token from authx.
+func (c *DeviceCredentials) Login(ctx context.Context, client pbAuthx.AuthxClient) (*pbAuthx.LoginResponse, error) {
+	return client.DeviceLogin(ctx, &pbAuthx.DeviceLoginRequest{
+		OrganizationId: c.OrganizationID,
+		DeviceApiKey:   c.DeviceAPIKey,
+	})
+}
+
+// Refresh renews a token using its refresh token.
+func (c *DeviceCredentials) Refresh(ctx context.Context, client pbAuthx.AuthxClient, current *pbAuthx.LoginResponse) (*pbAuthx.LoginResponse, error) {
+	return client.RefreshDeviceToken(ctx, &pbAuthx.RefreshTokenRequest{Token: current.Token, RefreshToken: current.RefreshToken})
+}
+
+// RefreshTokenCredentials starts from a token and its refresh token obtained by other means. The refresh tokens are
+// rotated by authx, so the initial pair can only be used once and a new login is not possible once the session ends.
+type RefreshTokenCredentials struct {
+	sync.Mutex
+	token        string
+	refreshToken string
+	used         bool
+}
+
+// NewRefreshTokenCredentials creates the credentials from a token and its refresh token.
+func NewRefreshTokenCredentials(token string, refreshToken string) *RefreshTokenCredentials {
+	return &RefreshTokenCredentials{token: token, refreshToken: refreshToken}
+}
+
+// Login renews the initial token. It fails if it has already been used.
+func (c *RefreshTokenCredentials) Login(ctx context.Context, client pbAuthx.AuthxClient) (*pbAuthx.LoginResponse, error) {
+	c.Lock()
+	defer c.Unlock()
+	if c.used {
+		return nil, conversions.ToGRPCError(derrors.NewUnauthenticatedError("the refresh token has already been used"))
+	}
+	c.used = true
+	return client.RefreshToken(ctx, &pbAuthx.RefreshTokenRequest{Token: c.token, RefreshToken: c.refreshToken})
+}
+
+// Refresh renews a token using its refresh token.
+func (c *RefreshTokenCredentials) Refresh(ctx context.Context, client pbAuthx.AuthxClient, current *pbAuthx.LoginResponse) (*pbAuthx.LoginResponse, error) {
+	return client.RefreshToken(ctx, &pbAuthx.RefreshTokenRequest{Token: current.Token, RefreshToken: current.RefreshToken})
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// WithClientAuthxInterceptor is a gRPC dial option. If this option is included, the token of the session is sent
+// in the unary calls. A call rejected as unauthenticated is retried once with a new token.
+func WithClientAuthxInterceptor(session *Session) grpc.DialOption {
+	return grpc.WithUnaryInterceptor(authxInterceptor(session))
+}
+
+// WithClientAuthxStreamInterceptor is a gRPC dial option. If this option is included, the token of the session is
+// sent when a stream is created. The servers usually reject the token when the first message is received, so if the
+// first message of a stream fails as unauthenticated, the token is discarded and the stream is created again once
+// with a new token. Only the streams that send a single message, such as the server streams, can be created again;
+// the error of the rest of the streams is returned, and the next streams use a new token.
+func WithClientAuthxStreamInterceptor(session *Session) grpc.DialOption {
+	return grpc.WithStreamInterceptor(authxStreamInterceptor(session))
+}
+
+// WithClientAuthxInterceptors returns the gRPC dial options that send the token of the session in the unary and the
+// streaming calls.
+func WithClientAuthxInterceptors(session *Session) []grpc.DialOption {
+	return []grpc.DialOption{WithClientAuthxInterceptor(session), WithClientAuthxStreamInterceptor(session)}
+}
+
+func authxInterceptor(session *Session) grpc.UnaryClientInterceptor {
+
+	return func(ctx context.Context,
+		method string,
+		req, reply interface{},
+		cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker,
+		opts ...grpc.CallOption) error {
+
+		token, dErr := session.Token(ctx)
+		if dErr != nil {
+			return conversions.ToGRPCError(dErr)
+		}
+		err := invoker(metadata.AppendToOutgoingContext(ctx, session.Header, token), method, req, reply, cc, opts...)
+		if status.Code(err) != codes.Unauthenticated {
+			return err
+		}
+		session.Invalidate(token)
+		token, dErr = session.Token(ctx)
+		if dErr != nil {
+			return conversions.ToGRPCError(dErr)
+		}
+		return invoker(metadata.AppendToOutgoingContext(ctx, session.Header, token), method, req, reply, cc, opts...)
+	}
+
+}
+
+func authxStreamInterceptor(session *Session) grpc.StreamClientInterceptor {
+
+	return func(ctx context.Context,
+		desc *grpc.StreamDesc,
+		cc *grpc.ClientConn,
+		method string,
+		streamer grpc.Streamer,
+		opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+		create := func() (grpc.ClientStream, string, error) {
+			token, dErr := session.Token(ctx)
+			if dErr != nil {
+				return nil, "", conversions.ToGRPCError(dErr)
+			}
+			stream, err := streamer(metadata.AppendToOutgoingContext(ctx, session.Header, token), desc, cc, method, opts...)
+			return stream, token, err
+		}
+		stream, token, err := create()
+		if status.Code(err) == codes.Unauthenticated {
+			session.Invalidate(token)
+			stream, token, err = create()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &authxClientStream{ClientStream: stream, session: session, token: token, desc: desc, create: create}, nil
+	}
+
+}
+
+// authxClientStream is a stream that discards the token of the session if its first message is rejected as
+// unauthenticated, and creates the stream again with a new token if the request can be sent again.
+type authxClientStream struct {
+	grpc.ClientStream
+	session *Session
+	token   string
+	desc    *grpc.StreamDesc
+	create  func() (grpc.ClientStream, string, error)
+	// request is the message sent by a stream that only sends a message.
+	request  interface{}
+	sent     int
+	closed   bool
+	received bool
+}
+
+// SendMsg sends a message and keeps it in case the stream must be created again.
+func (s *authxClientStream) SendMsg(m interface{}) error {
+	s.request = m
+	s.sent++
+	return s.ClientStream.SendMsg(m)
+}
+
+// CloseSend closes the send direction of the stream.
+func (s *authxClientStream) CloseSend() error {
+	s.closed = true
+	return s.ClientStream.CloseSend()
+}
+
+// RecvMsg receives a message. If the first message is rejected as unauthenticated, the stream is created again with
+// a new token if it only sent a message.
+func (s *authxClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if s.received || status.Code(err) != codes.Unauthenticated {
+		s.received = true
+		return err
+	}
+	s.received = true
+	s.session.Invalidate(s.token)
+	if s.desc.ClientStreams || s.sent != 1 || !s.closed {
+		return err
+	}
+	stream, token, createErr := s.create()
+	if createErr != nil {
+		return createErr
+	}
+	s.ClientStream, s.token = stream, token
+	if err := stream.SendMsg(s.request); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+	return stream.RecvMsg(m)
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package client
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"sync"
+	"time"
+)
+
+// DefaultHeader is the header where the token is sent.
+const DefaultHeader = "authorization"
+
+// DefaultRefreshMargin is the time before the expiration of a token when it is refreshed.
+const DefaultRefreshMargin = time.Minute
+
+// Session keeps the token of a client. The token is obtained with the credentials the first time it is needed and
+// it is refreshed before it expires. If the token cannot be refreshed, a new login is done.
+type Session struct {
+	sync.Mutex
+	// Header where the token is sent.
+	Header string
+	// RefreshMargin is the time before the expiration of a token when it is refreshed.
+	RefreshMargin time.Duration
+	// client of authx. It must not use the interceptors of the session.
+	client      pbAuthx.AuthxClient
+	credentials Credentials
+	response    *pbAuthx.LoginResponse
+	expiresAt   time.Time
+	// renewal is the request in progress to obtain a new token, if any.
+	renewal *renewal
+}
+
+// renewal is a request to authx to obtain a new token. The concurrent calls that need a new token wait for the same
+// renewal.
+type renewal struct {
+	done  chan struct{}
+	token string
+	err   derrors.Error
+}
+
+// NewSession creates a session that obtains the tokens from authx with some credentials.
+func NewSession(client pbAuthx.AuthxClient, credentials Credentials) *Session {
+	return &Session{
+		Header:        DefaultHeader,
+		RefreshMargin: DefaultRefreshMargin,
+		client:        client,
+		credentials:   credentials,
+	}
+}
+
+// Token returns a valid token. The token is refreshed if it expires in less than RefreshMargin. The lock of the
+// session is not held while authx is called, and the concurrent calls share the same request.
+func (s *Session) Token(ctx context.Context) (string, derrors.Error) {
+	s.Lock()
+	if s.response != nil && (s.expiresAt.IsZero() || time.Until(s.expiresAt) > s.RefreshMargin) {
+		token := s.response.Token
+		s.Unlock()
+		return token, nil
+	}
+	current := s.renewal
+	if current == nil {
+		current = &renewal{done: make(chan struct{})}
+		s.renewal = current
+		previous := s.response
+		s.Unlock()
+		s.renew(ctx, current, previous)
+		return current.token, current.err
+	}
+	s.Unlock()
+	select {
+	case <-current.done:
+		return current.token, current.err
+	case <-ctx.Done():
+		return "", derrors.NewUnavailableError("impossible obtain a token", ctx.Err())
+	}
+}
+
+// renew obtains a new token from authx. The token is refreshed if there is a previous one, and a new login is done
+// otherwise or if it cannot be refreshed. The calls waiting for the renewal receive the same result.
+func (s *Session) renew(ctx context.Context, current *renewal, previous *pbAuthx.LoginResponse) {
+	var response *pbAuthx.LoginResponse
+	var err error
+	if previous != nil && previous.RefreshToken != "" {
+		response, err = s.credentials.Refresh(ctx, s.client, previous)
+		if err != nil {
+			log.Debug().Err(err).Msg("cannot refresh token, a new login is required")
+		}
+	}
+	if response == nil {
+		response, err = s.credentials.Login(ctx, s.client)
+	}
+	s.Lock()
+	if err == nil {
+		s.response = response
+		s.expiresAt = expiresAt(response.Token)
+		current.token = response.Token
+	} else {
+		current.err = conversions.ToDerror(err)
+	}
+	s.renewal = nil
+	s.Unlock()
+	close(current.done)
+}
+
+// Invalidate discards a token rejected by a server, so the next call to Token obtains a new one.
+func (s *Session) Invalidate(token string) {
+	s.Lock()
+	defer s.Unlock()
+	if s.response != nil && s.response.Token == token {
+		s.expiresAt = time.Now()
+	}
+}
+
+// expiresAt returns the expiration date of a token. The signature is not verified as the token is only used to
+// know when it must be refreshed.
+func expiresAt(token string) time.Time {
+	claims := &jwt.StandardClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+	"time"
 )
 
 // WithServerAuthxInterceptor is a gRPC option. If this option is included, the interceptor verifies that the user is
@@ -34,6 +35,18 @@ func WithServerAuthxInterceptor(config *Config) grpc.ServerOption {
 	return grpc.UnaryInterceptor(authxInterceptor(config))
 }
 
+// WithServerAuthxStreamInterceptor is a gRPC option. If this option is included, the interceptor verifies that the
+// user is authorized to use the streaming methods, using the JWT token.
+func WithServerAuthxStreamInterceptor(config *Config) grpc.ServerOption {
+	return grpc.StreamInterceptor(authxStreamInterceptor(config))
+}
+
+// WithServerAuthxInterceptors returns the gRPC options that verify the unary and the streaming methods with the same
+// configuration.
+func WithServerAuthxInterceptors(config *Config) []grpc.ServerOption {
+	return []grpc.ServerOption{WithServerAuthxInterceptor(config), WithServerAuthxStreamInterceptor(config)}
+}
+
 func authxInterceptor(config *Config) grpc.UnaryServerInterceptor {
 
 	return func(ctx context.Context,
@@ -41,82 +54,341 @@ func authxInterceptor(config *Config) grpc.UnaryServerInterceptor {
 		info *grpc.UnaryServerInfo,
 		handler grpc.UnaryHandler) (interface{}, error) {
 
-		_, ok := config.Authorization.Permissions[info.FullMethod]
+		newContext, err := authorizeContext(ctx, info.FullMethod, config)
+		if err != nil {
+			return nil, err
+		}
+		return handler(newContext, req)
+	}
 
-		if ok {
-			claim, dErr := checkJWT(ctx, config)
-			if dErr != nil {
-				return nil, conversions.ToGRPCError(dErr)
-			}
-			dErr = authorize(info.FullMethod, claim, config)
+}
 
-			if dErr != nil {
-				return nil, conversions.ToGRPCError(dErr)
-			}
+func authxStreamInterceptor(config *Config) grpc.StreamServerInterceptor {
 
-			values := make([]string, 0)
-			values = append(values, "user_id", claim.UserID, "organization_id", claim.OrganizationID)
-			for _, p := range claim.Primitives {
-				values = append(values, p, "true")
-			}
-			newMD := metadata.Pairs(values...)
-			oldMD, ok := metadata.FromIncomingContext(ctx)
-			if !ok {
-				return nil, derrors.NewInternalError("impossible to extract metadata")
-			}
-			newContext := metadata.NewIncomingContext(ctx, metadata.Join(oldMD, newMD))
-			return handler(newContext, req)
-
-		} else {
-			if !config.Authorization.AllowsAll {
-				return nil, conversions.ToGRPCError(
-					derrors.NewUnauthenticatedError("unauthorized method").
-						WithParams(info.FullMethod))
-			}
+	return func(srv interface{},
+		stream grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler) error {
+
+		newContext, err := authorizeContext(stream.Context(), info.FullMethod, config)
+		if err != nil {
+			return err
 		}
-		log.Warn().Msg("auth metadata has not been added")
-		return handler(ctx, req)
+		return handler(srv, &authxServerStream{ServerStream: stream, ctx: newContext})
 	}
 
 }
 
-func checkJWT(ctx context.Context, config *Config) (*token.Claim, derrors.Error) {
+// authxServerStream is a stream that replaces the context with the one that includes the auth metadata.
+type authxServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context returns the context of the stream with the auth metadata.
+func (s *authxServerStream) Context() context.Context {
+	return s.ctx
+}
+
+// authorizeContext verifies that the user is authorized to use a method and returns the context that must be passed
+// to the handler. The reserved metadata keys are removed from the context, and the principal is added if the method
+// requires a token.
+func authorizeContext(ctx context.Context, method string, config *Config) (context.Context, error) {
+	ctx = stripReservedMetadata(ctx)
+	permission, ok := config.Authorization.Permission(method)
+
+	if ok {
+		t, dErr := tokenFromContext(ctx, config)
+		if dErr != nil {
+			return nil, conversions.ToGRPCError(dErr)
+		}
+		principal, dErr := authenticate(ctx, t, method, permission, config)
+		if dErr != nil {
+			return nil, conversions.ToGRPCError(dErr)
+		}
+
+		newMD := metadata.Pairs(principal.metadata()...)
+		oldMD, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil, derrors.NewInternalError("impossible to extract metadata")
+		}
+		return ContextWithPrincipal(metadata.NewIncomingContext(ctx, metadata.Join(oldMD, newMD)), principal), nil
+
+	} else {
+		if !config.Authorization.AllowsAll {
+			return nil, conversions.ToGRPCError(
+				derrors.NewUnauthenticatedError("unauthorized method").
+					WithParams(method))
+		}
+	}
+	log.Warn().Msg("auth metadata has not been added")
+	return ctx, nil
+}
+
+// authenticate verifies the token of a request to a method with its permission and returns the principal of the
+// user or the device.
+func authenticate(ctx context.Context, t string, method string, permission *Permission, config *Config) (*Principal, derrors.Error) {
+	if config.DeviceSecrets != nil {
+		claim, dErr := validateDeviceToken(ctx, t, config)
+		if dErr != nil {
+			return nil, dErr
+		}
+		dErr = authorizeDevice(method, permission, claim)
+		if dErr != nil {
+			return nil, dErr
+		}
+		return newDevicePrincipal(claim), nil
+	}
+
+	claim, dErr := validateToken(ctx, t, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	dErr = authorizeUser(method, permission, claim)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return newUserPrincipal(claim), nil
+}
+
+// tokenFromContext returns the token included in the metadata of a request.
+func tokenFromContext(ctx context.Context, config *Config) (string, derrors.Error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return nil, derrors.NewInternalError("impossible to extract metadata")
+		return "", derrors.NewInternalError("impossible to extract metadata")
 	}
 
 	authHeader, ok := md[config.Header]
 	if !ok {
-		return nil, derrors.NewUnauthenticatedError("token is not supplied")
+		return "", derrors.NewUnauthenticatedError("token is not supplied")
 	}
-	t := authHeader[0]
-	// validateToken function validates the token
-	tk, err := jwt.ParseWithClaims(t, &token.Claim{}, func(token *jwt.Token) (interface{}, error) {
-		return []byte(config.Secret), nil
-	})
+	return authHeader[0], nil
+}
+
+func checkJWT(ctx context.Context, config *Config) (*token.Claim, derrors.Error) {
+	t, dErr := tokenFromContext(ctx, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return validateToken(ctx, t, config)
+}
 
+// validateToken verifies a user token and returns its claim.
+func validateToken(ctx context.Context, t string, config *Config) (*token.Claim, derrors.Error) {
+	parser := &jwt.Parser{ValidMethods: config.algorithms(), SkipClaimsValidation: true}
+	tk, err := parser.ParseWithClaims(t, &token.Claim{}, config.verificationKey)
+
+	if err != nil {
+		return nil, derrors.NewUnauthenticatedError("token is not valid", err)
+	}
+
+	claim := tk.Claims.(*token.Claim)
+	dErr := validateClaim(&claim.StandardClaims, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	if config.Revocation != nil {
+		revoked, dErr := config.Revocation.IsRevoked(ctx, claim.Id)
+		if dErr != nil {
+			return nil, dErr
+		}
+		if revoked {
+			return nil, derrors.NewUnauthenticatedError("token has been revoked").WithParams(claim.Id)
+		}
+	}
+	return claim, nil
+}
+
+// validateDeviceToken verifies a device token and returns its claim.
+func validateDeviceToken(ctx context.Context, t string, config *Config) (*token.DeviceClaim, derrors.Error) {
+	// the errors of the secret source are returned as they are, so an unavailable authx is not reported as an
+	// invalid token
+	var secretErr derrors.Error
+	keyFunc := func(tk *jwt.Token) (interface{}, error) {
+		if _, ok := tk.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, derrors.NewUnauthenticatedError("device tokens must be signed with the group secret")
+		}
+		claim := tk.Claims.(*token.DeviceClaim)
+		secret, err := config.DeviceSecrets.GetDeviceGroupSecret(ctx, claim.OrganizationID, claim.DeviceGroupID)
+		if err != nil {
+			if err.Type() != derrors.NotFound {
+				secretErr = err
+			}
+			return nil, err
+		}
+		return []byte(secret), nil
+	}
+	parser := &jwt.Parser{ValidMethods: config.algorithms(), SkipClaimsValidation: true}
+	tk, err := parser.ParseWithClaims(t, &token.DeviceClaim{}, keyFunc)
+	if secretErr != nil {
+		return nil, secretErr
+	}
+	if invalidSignature(err) {
+		// the secret of the group may have changed since it was cached
+		refreshed, rErr := refreshDeviceSecret(ctx, tk, config)
+		if rErr != nil {
+			return nil, rErr
+		}
+		if refreshed {
+			tk, err = parser.ParseWithClaims(t, &token.DeviceClaim{}, keyFunc)
+			if secretErr != nil {
+				return nil, secretErr
+			}
+		}
+	}
 	if err != nil {
 		return nil, derrors.NewUnauthenticatedError("token is not valid", err)
 	}
 
-	return tk.Claims.(*token.Claim), nil
+	claim := tk.Claims.(*token.DeviceClaim)
+	if claim.DeviceID == "" {
+		return nil, derrors.NewUnauthenticatedError("token is not a device token")
+	}
+	dErr := validateClaim(&claim.StandardClaims, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return claim, nil
+}
+
+// invalidSignature checks if a token has been rejected because its signature does not match the key.
+func invalidSignature(err error) bool {
+	validationErr, ok := err.(*jwt.ValidationError)
+	return ok && validationErr.Errors&jwt.ValidationErrorSignatureInvalid != 0
+}
+
+// refreshDeviceSecret downloads again the secret of the group of a device token if the secrets are cached. It returns
+// true if the secret has changed.
+func refreshDeviceSecret(ctx context.Context, tk *jwt.Token, config *Config) (bool, derrors.Error) {
+	cached, ok := config.DeviceSecrets.(*CachedDeviceSecretSource)
+	if !ok || tk == nil {
+		return false, nil
+	}
+	claim := tk.Claims.(*token.DeviceClaim)
+	refreshed, err := cached.RefreshDeviceGroupSecret(ctx, claim.OrganizationID, claim.DeviceGroupID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			return false, derrors.NewUnauthenticatedError("token is not valid", err)
+		}
+		return false, err
+	}
+	return refreshed, nil
+}
+
+// validateClaim checks the dates, the issuer and the audience of a token. The dates are checked allowing the
+// configured clock skew.
+func validateClaim(claim *jwt.StandardClaims, config *Config) derrors.Error {
+	now := time.Now().Unix()
+	leeway := int64(config.Leeway.Seconds())
+	if claim.ExpiresAt != 0 && now > claim.ExpiresAt+leeway {
+		return derrors.NewUnauthenticatedError("token is expired").WithParams(claim.ExpiresAt)
+	}
+	if claim.NotBefore != 0 && now+leeway < claim.NotBefore {
+		return derrors.NewUnauthenticatedError("token is not valid yet").WithParams(claim.NotBefore)
+	}
+	if claim.IssuedAt != 0 && now+leeway < claim.IssuedAt {
+		return derrors.NewUnauthenticatedError("token used before issued").WithParams(claim.IssuedAt)
+	}
+	if len(config.Issuers) > 0 && !contains(config.Issuers, claim.Issuer) {
+		return derrors.NewUnauthenticatedError("token issuer is not valid").WithParams(claim.Issuer)
+	}
+	if len(config.Audiences) > 0 {
+		// The services of an environment only accept the tokens issued for it.
+		if claim.Audience == "" {
+			return derrors.NewUnauthenticatedError("token audience is required")
+		}
+		if !contains(config.Audiences, claim.Audience) {
+			return derrors.NewUnauthenticatedError("token audience is not valid").WithParams(claim.Audience)
+		}
+	} else if claim.Audience != "" {
+		return derrors.NewUnauthenticatedError("token audience is not valid").WithParams(claim.Audience)
+	}
+	return nil
+}
+
+// contains checks if a list includes a value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
 }
 
 // authorize function authorizes the token received from Metadata
 func authorize(method string, claim *token.Claim, config *Config) derrors.Error {
-	permission, ok := config.Authorization.Permissions[method]
+	permission, ok := config.Authorization.Permission(method)
 	if !ok {
 		if config.Authorization.AllowsAll {
 			return nil
 		}
 		return derrors.NewUnauthenticatedError("unauthorized method").WithParams(method)
 	}
+	return authorizeUser(method, permission, claim)
+}
+
+// authorizeUser authorizes the token of a user or a service account with the permission of a method.
+func authorizeUser(method string, permission *Permission, claim *token.Claim) derrors.Error {
+	if !permission.AllowsPrincipal(claim.GetPrincipalType()) {
+		return derrors.NewUnauthenticatedError("principal type not allowed").WithParams(method, claim.GetPrincipalType())
+	}
+	if claim.IsMFAEnrolment() && !permission.AllowMFAEnrolment {
+		return derrors.NewUnauthenticatedError("MFA enrolment is required").WithParams(method)
+	}
+	if permission.RequireMFA && !claim.HasAuthenticationMethod(token.MultiFactorAuthentication) {
+		return derrors.NewUnauthenticatedError("method requires MFA").WithParams(method)
+	}
 
 	valid := permission.Valid(claim.Primitives)
 	if !valid {
 		return derrors.NewUnauthenticatedError("unauthorized method").WithParams(method)
 	}
+	if permission.Rule != nil && !permission.Rule.Evaluate(newUserPrincipal(claim)) {
+		return derrors.NewUnauthenticatedError("unauthorized method").WithParams(method)
+	}
+
+	return nil
+}
+
+// authorizeDevice authorizes the token of a device with the permission of a method. The methods that require MFA
+// cannot be used by the devices.
+func authorizeDevice(method string, permission *Permission, claim *token.DeviceClaim) derrors.Error {
+	if !permission.AllowsPrincipal(token.DevicePrincipal) {
+		return derrors.NewUnauthenticatedError("principal type not allowed").WithParams(method, token.DevicePrincipal)
+	}
+	if permission.RequireMFA {
+		return derrors.NewUnauthenticatedError("method requires MFA").WithParams(method)
+	}
+	if !permission.Valid(claim.Primitives) {
+		return derrors.NewUnauthenticatedError("unauthorized method").WithParams(method)
+	}
+	if permission.Rule != nil && !permission.Rule.Evaluate(newDevicePrincipal(claim)) {
+		return derrors.NewUnauthenticatedError("unauthorized method").WithParams(method)
+	}
 
 	return nil
 }
+
+// verificationKey returns the key that must be used to check the signature of a token. HMAC tokens are verified with
+// the shared secret and the rest with the public key, both selected by the kid header.
+func (config *Config) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header[token.KeyIDHeader].(string)
+	if _, ok := t.Method.(*jwt.SigningMethodHMAC); ok {
+		if secret, found := config.Secrets[kid]; found {
+			return []byte(secret), nil
+		}
+		if config.Secret == "" {
+			return nil, derrors.NewUnauthenticatedError("shared secret tokens are not accepted")
+		}
+		return []byte(config.Secret), nil
+	}
+	if config.KeySet == nil {
+		return nil, derrors.NewUnauthenticatedError("public keys are not configured").WithParams(t.Method.Alg())
+	}
+	key, err := config.KeySet.VerificationKey(kid, t.Method.Alg())
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
@@ -19,8 +19,13 @@ package interceptor
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
 	pbAuthx "github.com/nalej/grpc-authx-go"
 	"github.com/nalej/grpc-utils/pkg/test"
 	"github.com/onsi/ginkgo"
@@ -30,6 +35,8 @@ import (
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/test/bufconn"
+	"net/http"
+	"net/http/httptest"
 	"time"
 )
 
@@ -244,6 +251,104 @@ var _ = ginkgo.Describe("Authorize method", func() {
 	
 })
 
+var _ = ginkgo.Describe("Authorize method with MFA", func() {
+
+	duration, _ := time.ParseDuration("1h")
+	sensitiveMethod := "sensitiveMethod"
+	enrolMethod := "enrolMethod"
+	method := "method"
+	primitive := "primitive"
+
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: false, Permissions: map[string]Permission{
+		sensitiveMethod: {Must: []string{primitive}, RequireMFA: true},
+		enrolMethod:     {AllowMFAEnrolment: true},
+		method:          {},
+	}},
+		"myLittleSecret", "auth")
+
+	newClaim := func(primitives []string, methods ...string) *token.Claim {
+		personalClaim := token.NewPersonalClaim("u1", "r1", primitives, "o1")
+		personalClaim.AuthenticationMethods = methods
+		return token.NewClaim(*personalClaim, "i1", time.Now(), duration)
+	}
+
+	ginkgo.It("should require MFA for sensitive methods", func() {
+		claim := newClaim([]string{primitive}, token.PasswordAuthentication)
+		gomega.Expect(authorize(sensitiveMethod, claim, cfg)).To(gomega.HaveOccurred())
+		gomega.Expect(authorize(method, claim, cfg)).To(gomega.Succeed())
+
+		claim = newClaim([]string{primitive}, token.PasswordAuthentication, token.OTPAuthentication,
+			token.MultiFactorAuthentication)
+		gomega.Expect(authorize(sensitiveMethod, claim, cfg)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should only allow the enrolment with a restricted token", func() {
+		claim := newClaim([]string{token.MFAEnrolmentPrimitive}, token.PasswordAuthentication)
+		gomega.Expect(authorize(enrolMethod, claim, cfg)).To(gomega.Succeed())
+		gomega.Expect(authorize(method, claim, cfg)).To(gomega.HaveOccurred())
+		gomega.Expect(authorize(sensitiveMethod, claim, cfg)).To(gomega.HaveOccurred())
+	})
+})
+
+var _ = ginkgo.Describe("Authorize method with principal types", func() {
+
+	duration, _ := time.ParseDuration("1h")
+	serviceMethod := "serviceMethod"
+	userMethod := "userMethod"
+	method := "method"
+
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: false, Permissions: map[string]Permission{
+		serviceMethod: {Principals: []string{token.ServicePrincipal}},
+		userMethod:    {Principals: []string{token.UserPrincipal}},
+		method:        {},
+	}},
+		"myLittleSecret", "auth")
+
+	ginkgo.It("should tell users and services apart", func() {
+		user := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"), "i1", time.Now(), duration)
+		service := token.NewClaim(*token.NewServicePersonalClaim("sa1", "r1", []string{}, "o1"), "i1", time.Now(), duration)
+
+		gomega.Expect(authorize(userMethod, user, cfg)).To(gomega.Succeed())
+		gomega.Expect(authorize(serviceMethod, user, cfg)).To(gomega.HaveOccurred())
+		gomega.Expect(authorize(method, user, cfg)).To(gomega.Succeed())
+
+		gomega.Expect(authorize(userMethod, service, cfg)).To(gomega.HaveOccurred())
+		gomega.Expect(authorize(serviceMethod, service, cfg)).To(gomega.Succeed())
+		gomega.Expect(authorize(method, service, cfg)).To(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("Authorize method with rules", func() {
+
+	duration, _ := time.ParseDuration("1h")
+
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{
+		"/nalej.app.Applications/*": {Rule: &Rule{Any: []Rule{
+			{Primitive: "APPS"},
+			{Claim: &ClaimCondition{Field: PrincipalTypeClaimField, Values: []string{token.ServicePrincipal}}},
+		}}},
+	}, Default: &Permission{Rule: &Rule{Claim: &ClaimCondition{Field: OrganizationIDClaimField, Values: []string{"o1"}}}}},
+		"myLittleSecret", "auth")
+
+	ginkgo.It("should evaluate the rule of a wildcard method", func() {
+		user := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"), "i1", time.Now(), duration)
+		appsUser := token.NewClaim(*token.NewPersonalClaim("u2", "r1", []string{"APPS"}, "o1"), "i1", time.Now(), duration)
+		service := token.NewClaim(*token.NewServicePersonalClaim("sa1", "r1", []string{}, "o1"), "i1", time.Now(), duration)
+
+		gomega.Expect(authorize("/nalej.app.Applications/AddApp", user, cfg)).To(gomega.HaveOccurred())
+		gomega.Expect(authorize("/nalej.app.Applications/AddApp", appsUser, cfg)).To(gomega.Succeed())
+		gomega.Expect(authorize("/nalej.app.Applications/AddApp", service, cfg)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should use the default permission instead of AllowsAll", func() {
+		user := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"), "i1", time.Now(), duration)
+		other := token.NewClaim(*token.NewPersonalClaim("u2", "r1", []string{}, "o2"), "i1", time.Now(), duration)
+
+		gomega.Expect(authorize("/nalej.org.Organizations/Get", user, cfg)).To(gomega.Succeed())
+		gomega.Expect(authorize("/nalej.org.Organizations/Get", other, cfg)).To(gomega.HaveOccurred())
+	})
+})
+
 var _ = ginkgo.Describe("checkJWT method", func() {
 	ginkgo.Context("with valid JWT", func() {
 		duration, _ := time.ParseDuration("1d")
@@ -312,6 +417,49 @@ var _ = ginkgo.Describe("checkJWT method", func() {
 			gomega.Expect(claim).To(gomega.BeNil())
 		})
 		
+	})
+	ginkgo.Context("with an audience", func() {
+		duration, _ := time.ParseDuration("1h")
+		secret := "myLittleSecret"
+		header := "auth"
+		cfg := NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+			secret, header)
+		cfg.Audiences = []string{"prod", "backend"}
+		
+		contextWithAudience := func(audience string) context.Context {
+			claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"),
+				"i1", time.Now(), duration)
+			claim.Audience = audience
+			tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secret))
+			return metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		}
+		
+		ginkgo.It("should accept the tokens of the service", func() {
+			_, err := checkJWT(contextWithAudience("backend"), cfg)
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+		
+		ginkgo.It("should reject the tokens without audience", func() {
+			_, err := checkJWT(contextWithAudience(""), cfg)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+		
+		ginkgo.It("should only accept the tokens without audience if the service has none", func() {
+			noAudience := NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+				secret, header)
+			_, err := checkJWT(contextWithAudience(""), noAudience)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, err = checkJWT(contextWithAudience("backend"), noAudience)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+		})
+		
+		ginkgo.It("should reject the tokens of other services", func() {
+			_, err := checkJWT(contextWithAudience("other"), cfg)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		})
+		
 	})
 	ginkgo.Context("with wrong MD", func() {
 		duration, _ := time.ParseDuration("1d")
@@ -338,6 +486,283 @@ var _ = ginkgo.Describe("checkJWT method", func() {
 	
 })
 
+var _ = ginkgo.Describe("checkJWT method with a key set", func() {
+	duration, _ := time.ParseDuration("1d")
+	header := "auth"
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	signingKey := token.NewECSigningKey("k1", privateKey)
+	cfg := NewConfigWithKeySet(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+		token.NewJSONWebKeySet(signingKey), header)
+	claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"),
+		"i1", time.Now(), duration)
+
+	ginkgo.It("should work with a token signed with the private key", func() {
+		tokenString, err := signingKey.Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(result.UserID).To(gomega.Equal("u1"))
+	})
+
+	ginkgo.It("should not work with an unknown key id", func() {
+		otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tokenString, err := token.NewECSigningKey("k2", otherKey).Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(result).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should not work with a shared secret token", func() {
+		tokenString, err := token.NewHMACSigningKey("k1", "myLittleSecret").Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(result).To(gomega.BeNil())
+	})
+})
+
+var _ = ginkgo.Describe("checkJWT method with claim validation", func() {
+	duration, _ := time.ParseDuration("1h")
+	secret := "myLittleSecret"
+	header := "auth"
+	var cfg *Config
+	
+	ginkgo.BeforeEach(func() {
+		cfg = NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+			secret, header)
+	})
+	
+	contextWithClaim := func(method jwt.SigningMethod, claim *token.Claim) context.Context {
+		tokenString, _ := jwt.NewWithClaims(method, claim).SignedString([]byte(secret))
+		return metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+	}
+	newClaim := func(issuer string, creationTime time.Time, expiration time.Duration) *token.Claim {
+		return token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"), issuer, creationTime, expiration)
+	}
+	
+	ginkgo.It("should check the issuer", func() {
+		cfg.Issuers = []string{"authx", "authx-staging"}
+		_, err := checkJWT(contextWithClaim(jwt.SigningMethodHS256, newClaim("authx-staging", time.Now(), duration)), cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = checkJWT(contextWithClaim(jwt.SigningMethodHS256, newClaim("other", time.Now(), duration)), cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+	
+	ginkgo.It("should only accept the allowed algorithms", func() {
+		ctx := contextWithClaim(jwt.SigningMethodHS512, newClaim("authx", time.Now(), duration))
+		_, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		
+		cfg.Algorithms = []string{jwt.SigningMethodHS512.Alg()}
+		_, err = checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = checkJWT(contextWithClaim(jwt.SigningMethodHS256, newClaim("authx", time.Now(), duration)), cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+	
+	ginkgo.It("should allow the clock skew of the leeway", func() {
+		expired := contextWithClaim(jwt.SigningMethodHS256, newClaim("authx", time.Now().Add(-duration), duration-30*time.Second))
+		notValidYet := contextWithClaim(jwt.SigningMethodHS256, newClaim("authx", time.Now().Add(30*time.Second), duration))
+		_, err := checkJWT(expired, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		_, err = checkJWT(notValidYet, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		
+		cfg.Leeway = time.Minute
+		_, err = checkJWT(expired, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = checkJWT(notValidYet, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+	
+	ginkgo.It("should reject the tokens of another environment", func() {
+		claim := token.NewClaimWithAudience(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"), "authx",
+			"staging", time.Now(), duration)
+		cfg.Audiences = []string{"production"}
+		_, err := checkJWT(contextWithClaim(jwt.SigningMethodHS256, claim), cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		cfg.Audiences = []string{"staging"}
+		_, err = checkJWT(contextWithClaim(jwt.SigningMethodHS256, claim), cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+})
+
+var _ = ginkgo.Describe("checkJWT method with a revocation list", func() {
+	duration, _ := time.ParseDuration("1d")
+	header := "auth"
+	secret := "myLittleSecret"
+	claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"),
+		"i1", time.Now(), duration)
+	tokenString, _ := token.NewHMACSigningKey("", secret).Sign(claim)
+
+	ginkgo.It("should reject a revoked token", func() {
+		list := newRevocationListMockup()
+		cfg := NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}}, secret, header)
+		cfg.Revocation = list
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(result.UserID).To(gomega.Equal("u1"))
+
+		list.revoke(claim.Id)
+		result, err = checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(result).To(gomega.BeNil())
+	})
+})
+
+var _ = ginkgo.Describe("checkJWT method with rotated keys", func() {
+	duration, _ := time.ParseDuration("1d")
+	header := "auth"
+	claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{}, "o1"),
+		"i1", time.Now(), duration)
+
+	ginkgo.It("should select the shared secret by key id", func() {
+		cfg := NewConfig(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+			"newSecret", header)
+		cfg.Secrets = map[string]string{"k1": "oldSecret", "k2": "newSecret"}
+
+		for _, key := range []*token.SigningKey{token.NewHMACSigningKey("k1", "oldSecret"),
+			token.NewHMACSigningKey("k2", "newSecret"), token.NewHMACSigningKey("", "newSecret")} {
+			tokenString, err := key.Sign(claim)
+			gomega.Expect(err).To(gomega.Succeed())
+			ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+			result, err := checkJWT(ctx, cfg)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(result.UserID).To(gomega.Equal("u1"))
+		}
+
+		tokenString, err := token.NewHMACSigningKey("k1", "newSecret").Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(result).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should download the remote key set when a new key is promoted", func() {
+		firstKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		secondKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		keys := []*token.SigningKey{token.NewECSigningKey("k1", firstKey)}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(token.NewJSONWebKeySet(keys...))
+		}))
+		defer server.Close()
+
+		keySet := NewRemoteKeySet(server.URL)
+		keySet.RefreshInterval = 0
+		cfg := NewConfigWithKeySet(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+			keySet, header)
+
+		tokenString, err := keys[0].Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		_, err = checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		keys = append(keys, token.NewECSigningKey("k2", secondKey))
+		tokenString, err = keys[1].Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx = metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(result.UserID).To(gomega.Equal("u1"))
+	})
+
+	ginkgo.It("should reject the keys retired from the remote key set", func() {
+		firstKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		secondKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		keys := []*token.SigningKey{token.NewECSigningKey("k1", firstKey), token.NewECSigningKey("k2", secondKey)}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(token.NewJSONWebKeySet(keys...))
+		}))
+		defer server.Close()
+
+		keySet := NewRemoteKeySet(server.URL)
+		keySet.RefreshInterval = 0
+		cfg := NewConfigWithKeySet(&AuthorizationConfig{AllowsAll: true, Permissions: map[string]Permission{}},
+			keySet, header)
+
+		tokenString, err := keys[0].Sign(claim)
+		gomega.Expect(err).To(gomega.Succeed())
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		_, err = checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		keys = keys[1:]
+		result, err := checkJWT(ctx, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(result).To(gomega.BeNil())
+	})
+})
+
+// contextServerStream is a server stream that only provides a context.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+var _ = ginkgo.Describe("Stream interceptor", func() {
+	duration, _ := time.ParseDuration("1h")
+	secret := "myLittleSecret"
+	header := "auth"
+	method := "/test.Test/Watch"
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: false,
+		Permissions: map[string]Permission{method: {Must: []string{"primitive1"}}}}, secret, header)
+	interceptor := authxStreamInterceptor(cfg)
+	
+	streamWithToken := func(primitives []string) grpc.ServerStream {
+		claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", primitives, "o1"), "i1", time.Now(), duration)
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secret))
+		md := metadata.New(map[string]string{header: tokenString})
+		return &contextServerStream{ctx: metadata.NewIncomingContext(context.Background(), md)}
+	}
+	
+	ginkgo.It("should pass the auth metadata to the handler", func() {
+		var received metadata.MD
+		err := interceptor(nil, streamWithToken([]string{"primitive1"}), &grpc.StreamServerInfo{FullMethod: method},
+			func(srv interface{}, stream grpc.ServerStream) error {
+				received, _ = metadata.FromIncomingContext(stream.Context())
+				return nil
+			})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(received.Get(UserIdField)).To(gomega.Equal([]string{"u1"}))
+		gomega.Expect(received.Get(OrganizationIdField)).To(gomega.Equal([]string{"o1"}))
+	})
+	
+	ginkgo.It("should reject unauthorized streams", func() {
+		called := false
+		handler := func(srv interface{}, stream grpc.ServerStream) error {
+			called = true
+			return nil
+		}
+		err := interceptor(nil, streamWithToken([]string{"primitive2"}), &grpc.StreamServerInfo{FullMethod: method}, handler)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		err = interceptor(nil, &contextServerStream{ctx: context.Background()}, &grpc.StreamServerInfo{FullMethod: method}, handler)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		err = interceptor(nil, streamWithToken([]string{"primitive1"}), &grpc.StreamServerInfo{FullMethod: "/test.Test/Other"}, handler)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(called).To(gomega.BeFalse())
+	})
+	
+	ginkgo.It("should include the unary and stream interceptors", func() {
+		gomega.Expect(WithServerAuthxInterceptors(cfg)).To(gomega.HaveLen(2))
+	})
+})
+
 var _ = ginkgo.Describe("GRP interceptor method ", func() {
 	
 	// gRPC server
@@ -18,20 +18,72 @@
 package interceptor
 
 import (
+	"bytes"
 	"encoding/json"
 	"github.com/nalej/derrors"
 	"github.com/rs/zerolog/log"
 	"io/ioutil"
+	"strings"
+	"time"
 )
 
 const DefaultCacheEntries = 100
 
+// DefaultAlgorithms contains the signing algorithms used by authx.
+var DefaultAlgorithms = []string{"HS256", "RS256", "ES256"}
+
 // AuthorizationConfig is structure that contains a set of permissions. The key of the map is the method name.
 type AuthorizationConfig struct {
 	// AllowsAll If the header is not found, allow access depending on this parameter.
 	AllowsAll bool `json:"allows_all"`
-	// Permission is a map of permissions the key is the method name.
+	// Permission is a map of permissions the key is the method name. A key ending with * applies to all the methods
+	// that start with the rest of the key, such as /nalej.app.Applications/*. If several keys match a method, the
+	// exact one or the longest one is used.
 	Permissions map[string]Permission `json:"permissions"`
+	// Default is the permission of the methods that are not included in Permissions. If it is set, AllowsAll is
+	// ignored and the unlisted methods require a token.
+	Default *Permission `json:"default,omitempty"`
+}
+
+// Permission returns the permission that applies to a method.
+func (ac *AuthorizationConfig) Permission(method string) (*Permission, bool) {
+	if permission, found := ac.Permissions[method]; found {
+		return &permission, true
+	}
+	wildcard, found := "", false
+	for key := range ac.Permissions {
+		if !strings.HasSuffix(key, "*") {
+			continue
+		}
+		if strings.HasPrefix(method, strings.TrimSuffix(key, "*")) && (!found || len(key) > len(wildcard)) {
+			wildcard, found = key, true
+		}
+	}
+	if found {
+		permission := ac.Permissions[wildcard]
+		return &permission, true
+	}
+	if ac.Default != nil {
+		return ac.Default, true
+	}
+	return nil, false
+}
+
+// Validate checks the rules of the permissions.
+func (ac *AuthorizationConfig) Validate() derrors.Error {
+	for method, permission := range ac.Permissions {
+		if permission.Rule != nil {
+			if err := permission.Rule.Validate(); err != nil {
+				return derrors.NewInvalidArgumentError("invalid permission rule", err).WithParams(method)
+			}
+		}
+	}
+	if ac.Default != nil && ac.Default.Rule != nil {
+		if err := ac.Default.Rule.Validate(); err != nil {
+			return derrors.NewInvalidArgumentError("invalid default permission rule", err)
+		}
+	}
+	return nil
 }
 
 func LoadAuthorizationConfig(path string) (*AuthorizationConfig, derrors.Error) {
@@ -41,10 +93,17 @@ func LoadAuthorizationConfig(path string) (*AuthorizationConfig, derrors.Error)
 	}
 
 	authCfg := &AuthorizationConfig{}
-	jErr := json.Unmarshal(dat, authCfg)
+	// The unknown fields are rejected so a misspelled condition does not grant access to a method.
+	decoder := json.NewDecoder(bytes.NewReader(dat))
+	decoder.DisallowUnknownFields()
+	jErr := decoder.Decode(authCfg)
 	if jErr != nil {
 		return nil, derrors.NewInternalError("impossible unmarshal file", jErr)
 	}
+	vErr := authCfg.Validate()
+	if vErr != nil {
+		return nil, vErr
+	}
 	log.Debug().Int("permissions", len(authCfg.Permissions)).Msg("Authorization matrix loaded")
 	return authCfg, nil
 }
@@ -54,6 +113,28 @@ type Config struct {
 	Authorization *AuthorizationConfig
 	// Secret contains the shared secret with the authx component to sign the JWT token.
 	Secret string
+	// Secrets contains the shared secrets indexed by kid. It is used while a shared secret is being rotated, the
+	// tokens without kid or with an unknown one are verified with Secret.
+	Secrets map[string]string
+	// KeySet contains the public keys published by authx. Tokens signed with an asymmetric algorithm are verified
+	// with the key identified by the kid header.
+	KeySet KeySource
+	// Revocation checks if a valid token has been revoked. If it is not set, the revocation list is not checked.
+	Revocation RevocationChecker
+	// Issuers contains the accepted issuers of the tokens. If it is empty, the issuer is not checked.
+	Issuers []string
+	// Audiences identifies the service and the environment. If it is not empty, only the tokens limited to one of
+	// them are accepted. Otherwise, the tokens limited to an audience are rejected. The tokens exchanged for a backend
+	// are limited to the audience of the environment followed by "/" and the backend, such as "production/billing",
+	// so a backend that accepts them and the user tokens lists both audiences.
+	Audiences []string
+	// Algorithms contains the accepted signing algorithms. If it is empty, DefaultAlgorithms are accepted.
+	Algorithms []string
+	// Leeway is the clock skew allowed when the expiration, not before and issued at dates are checked.
+	Leeway time.Duration
+	// DeviceSecrets provides the secrets of the device groups. If it is set, the interceptor works in device mode and
+	// only accepts the tokens of the devices.
+	DeviceSecrets DeviceSecretSource
 	// Name of the header where the token is found.
 	Header string
 	// Number of cached entries for group secrets
@@ -66,3 +147,38 @@ func NewConfig(config *AuthorizationConfig,
 
 	return &Config{Authorization: config, Secret: secret, Header: header, NumCacheEntries: DefaultCacheEntries}
 }
+
+// NewConfigWithKeySet creates a configuration that verifies the tokens using only the public keys of authx.
+func NewConfigWithKeySet(config *AuthorizationConfig,
+	keySet KeySource, header string) *Config {
+
+	return &Config{Authorization: config, KeySet: keySet, Header: header, NumCacheEntries: DefaultCacheEntries}
+}
+
+// NewDeviceConfig creates a configuration that verifies the tokens of the devices. The secrets of numCacheEntries
+// device groups are cached for DefaultDeviceSecretTTL.
+func NewDeviceConfig(config *AuthorizationConfig,
+	secrets DeviceSecretSource, header string, numCacheEntries int) (*Config, derrors.Error) {
+
+	return NewDeviceConfigWithTTL(config, secrets, header, numCacheEntries, DefaultDeviceSecretTTL)
+}
+
+// NewDeviceConfigWithTTL creates a configuration that verifies the tokens of the devices. The secrets of
+// numCacheEntries device groups are cached for secretTTL.
+func NewDeviceConfigWithTTL(config *AuthorizationConfig,
+	secrets DeviceSecretSource, header string, numCacheEntries int, secretTTL time.Duration) (*Config, derrors.Error) {
+
+	cached, err := NewCachedDeviceSecretSourceWithTTL(secrets, numCacheEntries, secretTTL)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{Authorization: config, DeviceSecrets: cached, Header: header, NumCacheEntries: numCacheEntries}, nil
+}
+
+// algorithms returns the accepted signing algorithms.
+func (config *Config) algorithms() []string {
+	if len(config.Algorithms) == 0 {
+		return DefaultAlgorithms
+	}
+	return config.Algorithms
+}
@@ -44,6 +44,69 @@ const InValidConfig = `
 }
 `
 
+const RuleConfig = `
+{
+	"permissions": {
+		"/nalej.app.Applications/*":{
+			"must": ["APPS"]
+		},
+		"/nalej.app.Applications/Remove*":{
+			"rule": {"claim": {"field": "role", "values": ["admin"]}}
+		},
+		"/nalej.app.Applications/RemoveApp":{
+			"should": ["APPS"]
+		}
+	},
+	"default": {
+		"rule": {"any": [{"primitive": "ORG"}, {"not": {"claim": {"field": "principal_type", "values": ["user"]}}}]}
+	}
+}
+`
+
+const InvalidRuleConfig = `
+{
+	"permissions": {
+		"/nalej.app.Applications/*":{
+			"rule": {"claim": {"field": "email", "values": ["admin@nalej.com"]}}
+		}
+	}
+}
+`
+
+const EmptyRuleConfig = `
+{
+	"permissions": {
+		"/nalej.app.Applications/*":{
+			"rule": {}
+		}
+	}
+}
+`
+
+const UnknownFieldConfig = `
+{
+	"permissions": {
+		"/nalej.app.Applications/*":{
+			"must": ["APPS"],
+			"mustNot": ["ORG_MNGT"]
+		}
+	}
+}
+`
+
+// loadConfig writes a configuration in a temporal file and loads it.
+func loadConfig(content string) (*AuthorizationConfig, error) {
+	tmpFile, fileErr := ioutil.TempFile("", "load-test")
+	gomega.Expect(fileErr).To(gomega.Succeed())
+	defer os.Remove(tmpFile.Name())
+	tmpFile.WriteString(content)
+	cfg, err := LoadAuthorizationConfig(tmpFile.Name())
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 var _ = ginkgo.Describe("Load config", func() {
 	ginkgo.Context("with a valid config file", func() {
 
@@ -86,4 +149,60 @@ var _ = ginkgo.Describe("Load config", func() {
 			gomega.Expect(cfg).To(gomega.BeNil())
 		})
 	})
+
+	ginkgo.Context("with rules, wildcards and a default permission", func() {
+
+		ginkgo.It("should load the rules", func() {
+			cfg, err := loadConfig(RuleConfig)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(cfg.Default).NotTo(gomega.BeNil())
+			gomega.Expect(cfg.Default.Rule.Any).To(gomega.HaveLen(2))
+		})
+
+		ginkgo.It("should use the exact permission, the longest wildcard and then the default", func() {
+			cfg, err := loadConfig(RuleConfig)
+			gomega.Expect(err).To(gomega.Succeed())
+
+			permission, found := cfg.Permission("/nalej.app.Applications/RemoveApp")
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(permission.Should).To(gomega.ConsistOf("APPS"))
+
+			permission, found = cfg.Permission("/nalej.app.Applications/RemoveInstance")
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(permission.Rule).NotTo(gomega.BeNil())
+
+			permission, found = cfg.Permission("/nalej.app.Applications/AddApp")
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(permission.Must).To(gomega.ConsistOf("APPS"))
+
+			permission, found = cfg.Permission("/nalej.org.Organizations/Get")
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(permission).To(gomega.Equal(cfg.Default))
+		})
+
+		ginkgo.It("should not find unlisted methods without a default permission", func() {
+			cfg, err := loadConfig(ValidConfig)
+			gomega.Expect(err).To(gomega.Succeed())
+			_, found := cfg.Permission("/authx.Authx/DeleteCredentials")
+			gomega.Expect(found).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should fail with an unknown claim field", func() {
+			cfg, err := loadConfig(InvalidRuleConfig)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(cfg).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should fail with a rule without conditions", func() {
+			cfg, err := loadConfig(EmptyRuleConfig)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(cfg).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should fail with an unknown field", func() {
+			cfg, err := loadConfig(UnknownFieldConfig)
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(cfg).To(gomega.BeNil())
+		})
+	})
 })
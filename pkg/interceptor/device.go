@@ -0,0 +1,166 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"context"
+	"github.com/hashicorp/golang-lru"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/nalej/grpc-device-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"time"
+)
+
+// DefaultDeviceSecretTTL is the time a device group secret is cached.
+const DefaultDeviceSecretTTL = 5 * time.Minute
+
+// minDeviceSecretRefresh is the minimum age of a cached secret to download it again after a token with an invalid
+// signature, so the invalid tokens cannot be used to flood the secret source.
+const minDeviceSecretRefresh = 10 * time.Second
+
+// DeviceSecretSource provides the secrets used to sign the tokens of the devices of a group.
+type DeviceSecretSource interface {
+	// GetDeviceGroupSecret returns the secret of a device group.
+	GetDeviceGroupSecret(ctx context.Context, organizationID string, deviceGroupID string) (string, derrors.Error)
+}
+
+// AuthxDeviceSecretSource asks authx for the secrets of the device groups.
+type AuthxDeviceSecretSource struct {
+	client pbAuthx.AuthxClient
+}
+
+// NewAuthxDeviceSecretSource creates a secret source that uses the authx client.
+func NewAuthxDeviceSecretSource(client pbAuthx.AuthxClient) *AuthxDeviceSecretSource {
+	return &AuthxDeviceSecretSource{client: client}
+}
+
+// GetDeviceGroupSecret returns the secret of a device group.
+func (s *AuthxDeviceSecretSource) GetDeviceGroupSecret(ctx context.Context, organizationID string, deviceGroupID string) (string, derrors.Error) {
+	secret, err := s.client.GetDeviceGroupSecret(ctx, &grpc_device_go.DeviceGroupId{
+		OrganizationId: organizationID,
+		DeviceGroupId:  deviceGroupID,
+	})
+	if err != nil {
+		return "", conversions.ToDerror(err)
+	}
+	return secret.Secret, nil
+}
+
+// StaticDeviceSecretSource contains a fixed set of secrets indexed by the organization and device group identifiers.
+type StaticDeviceSecretSource struct {
+	secrets map[string]string
+}
+
+// NewStaticDeviceSecretSource creates a secret source with the secrets of the device groups indexed by
+// organizationID/deviceGroupID, so the groups of different organizations with the same identifier are not mixed.
+func NewStaticDeviceSecretSource(secrets map[string]string) *StaticDeviceSecretSource {
+	return &StaticDeviceSecretSource{secrets: secrets}
+}
+
+// GetDeviceGroupSecret returns the secret of a device group.
+func (s *StaticDeviceSecretSource) GetDeviceGroupSecret(_ context.Context, organizationID string, deviceGroupID string) (string, derrors.Error) {
+	secret, found := s.secrets[cacheKey(organizationID, deviceGroupID)]
+	if !found {
+		return "", derrors.NewNotFoundError("device group secret").WithParams(organizationID, deviceGroupID)
+	}
+	return secret, nil
+}
+
+// CachedDeviceSecretSource keeps the secrets returned by another source in a LRU cache. The secrets are downloaded
+// again after TTL so the changes of the secrets of the device groups are applied.
+type CachedDeviceSecretSource struct {
+	source DeviceSecretSource
+	cache  *lru.Cache
+	// TTL is the time a secret is cached.
+	TTL time.Duration
+}
+
+// cachedSecret is an entry of the cache.
+type cachedSecret struct {
+	secret    string
+	fetchedAt time.Time
+}
+
+// NewCachedDeviceSecretSource creates a cache of numEntries device groups on top of a secret source. The secrets are
+// cached for DefaultDeviceSecretTTL.
+func NewCachedDeviceSecretSource(source DeviceSecretSource, numEntries int) (*CachedDeviceSecretSource, derrors.Error) {
+	return NewCachedDeviceSecretSourceWithTTL(source, numEntries, DefaultDeviceSecretTTL)
+}
+
+// NewCachedDeviceSecretSourceWithTTL creates a cache of numEntries device groups on top of a secret source that
+// keeps the secrets for a period of time.
+func NewCachedDeviceSecretSourceWithTTL(source DeviceSecretSource, numEntries int, ttl time.Duration) (*CachedDeviceSecretSource, derrors.Error) {
+	cache, err := lru.New(numEntries)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible create device group secret cache", err)
+	}
+	return &CachedDeviceSecretSource{source: source, cache: cache, TTL: ttl}, nil
+}
+
+// GetDeviceGroupSecret returns the secret of a device group.
+func (c *CachedDeviceSecretSource) GetDeviceGroupSecret(ctx context.Context, organizationID string, deviceGroupID string) (string, derrors.Error) {
+	if entry, found := c.get(organizationID, deviceGroupID); found && time.Since(entry.fetchedAt) < c.TTL {
+		return entry.secret, nil
+	}
+	return c.fetch(ctx, organizationID, deviceGroupID)
+}
+
+// RefreshDeviceGroupSecret downloads again the secret of a device group after a token with an invalid signature, in
+// case the secret has changed. The secret is not downloaded if the cached one is too recent. It returns true if the
+// secret has changed.
+func (c *CachedDeviceSecretSource) RefreshDeviceGroupSecret(ctx context.Context, organizationID string, deviceGroupID string) (bool, derrors.Error) {
+	entry, found := c.get(organizationID, deviceGroupID)
+	if found && time.Since(entry.fetchedAt) < minDeviceSecretRefresh {
+		return false, nil
+	}
+	secret, err := c.fetch(ctx, organizationID, deviceGroupID)
+	if err != nil {
+		return false, err
+	}
+	return !found || secret != entry.secret, nil
+}
+
+// get returns the cached entry of a device group.
+func (c *CachedDeviceSecretSource) get(organizationID string, deviceGroupID string) (*cachedSecret, bool) {
+	value, found := c.cache.Get(cacheKey(organizationID, deviceGroupID))
+	if !found {
+		return nil, false
+	}
+	return value.(*cachedSecret), true
+}
+
+// fetch downloads the secret of a device group and stores it in the cache. The unknown device groups are removed
+// from the cache.
+func (c *CachedDeviceSecretSource) fetch(ctx context.Context, organizationID string, deviceGroupID string) (string, derrors.Error) {
+	key := cacheKey(organizationID, deviceGroupID)
+	secret, err := c.source.GetDeviceGroupSecret(ctx, organizationID, deviceGroupID)
+	if err != nil {
+		if err.Type() == derrors.NotFound {
+			c.cache.Remove(key)
+		}
+		return "", err
+	}
+	c.cache.Add(key, &cachedSecret{secret: secret, fetchedAt: time.Now()})
+	return secret, nil
+}
+
+// cacheKey returns the key of a device group in the cache and in the static secrets.
+func cacheKey(organizationID string, deviceGroupID string) string {
+	return organizationID + "/" + deviceGroupID
+}
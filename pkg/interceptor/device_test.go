@@ -0,0 +1,186 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/metadata"
+	"sync"
+	"time"
+)
+
+// deviceSecretsMockup is a secret source that counts the number of requests.
+type deviceSecretsMockup struct {
+	sync.Mutex
+	source   DeviceSecretSource
+	requests int
+}
+
+func (d *deviceSecretsMockup) GetDeviceGroupSecret(ctx context.Context, organizationID string, deviceGroupID string) (string, derrors.Error) {
+	d.Lock()
+	d.requests++
+	d.Unlock()
+	return d.source.GetDeviceGroupSecret(ctx, organizationID, deviceGroupID)
+}
+
+// deviceContext returns the context of a request with the token of a device signed with a secret.
+func deviceContext(header string, claim *token.DeviceClaim, secret string) context.Context {
+	tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secret))
+	return metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+}
+
+var _ = ginkgo.Describe("CachedDeviceSecretSource", func() {
+
+	ginkgo.It("should cache the secrets of the device groups", func() {
+		source := &deviceSecretsMockup{source: NewStaticDeviceSecretSource(map[string]string{"o1/dg1": "s1", "o1/dg2": "s2"})}
+		cached, err := NewCachedDeviceSecretSource(source, 1)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		for i := 0; i < 3; i++ {
+			secret, err := cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(secret).To(gomega.Equal("s1"))
+		}
+		gomega.Expect(source.requests).To(gomega.Equal(1))
+
+		// the least recently used entry is evicted
+		_, err = cached.GetDeviceGroupSecret(context.Background(), "o1", "dg2")
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(source.requests).To(gomega.Equal(3))
+	})
+
+	ginkgo.It("should download the secrets again after the TTL", func() {
+		source := &deviceSecretsMockup{source: NewStaticDeviceSecretSource(map[string]string{"o1/dg1": "s1"})}
+		cached, err := NewCachedDeviceSecretSourceWithTTL(source, DefaultCacheEntries, 10*time.Millisecond)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		_, err = cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(source.requests).To(gomega.Equal(1))
+
+		time.Sleep(20 * time.Millisecond)
+		_, err = cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(source.requests).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should not cache unknown device groups", func() {
+		source := &deviceSecretsMockup{source: NewStaticDeviceSecretSource(map[string]string{})}
+		cached, err := NewCachedDeviceSecretSource(source, DefaultCacheEntries)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		for i := 0; i < 2; i++ {
+			_, err := cached.GetDeviceGroupSecret(context.Background(), "o1", "dg1")
+			gomega.Expect(err).To(gomega.HaveOccurred())
+			gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+		}
+		gomega.Expect(source.requests).To(gomega.Equal(2))
+	})
+})
+
+var _ = ginkgo.Describe("Interceptor in device mode", func() {
+	header := "auth"
+	method := "/device.Device/Ping"
+	userMethod := "/device.Device/List"
+	secrets := map[string]string{"o1/dg1": "groupSecret1"}
+	authConfig := &AuthorizationConfig{AllowsAll: false, Permissions: map[string]Permission{
+		method:     {Must: []string{"DEVICE"}},
+		userMethod: {Principals: []string{token.UserPrincipal}},
+	}}
+	cfg, err := NewDeviceConfig(authConfig, NewStaticDeviceSecretSource(secrets), header, DefaultCacheEntries)
+	gomega.Expect(err).To(gomega.Succeed())
+
+	ginkgo.It("should add the identifiers of the device to the context", func() {
+		claim := token.NewDeviceClaim("o1", "dg1", "d1", time.Hour)
+		ctx, err := authorizeContext(deviceContext(header, claim, secrets["o1/dg1"]), method, cfg)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		device, dErr := GetDeviceRequestMetadata(ctx)
+		gomega.Expect(dErr).To(gomega.Succeed())
+		gomega.Expect(device).To(gomega.Equal(&DeviceRequestMetadata{OrganizationID: "o1", DeviceGroupID: "dg1",
+			DeviceID: "d1"}))
+	})
+
+	ginkgo.It("should reject the tokens not signed with the group secret", func() {
+		claim := token.NewDeviceClaim("o1", "dg1", "d1", time.Hour)
+		_, err := authorizeContext(deviceContext(header, claim, "otherSecret"), method, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		unknownGroup := token.NewDeviceClaim("o1", "dg2", "d1", time.Hour)
+		_, err = authorizeContext(deviceContext(header, unknownGroup, secrets["o1/dg1"]), method, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		// the secrets are not shared by the groups of other organizations with the same identifier
+		otherOrganization := token.NewDeviceClaim("o2", "dg1", "d1", time.Hour)
+		_, err = authorizeContext(deviceContext(header, otherOrganization, secrets["o1/dg1"]), method, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+
+		expired := token.NewDeviceClaim("o1", "dg1", "d1", -time.Minute)
+		_, err = authorizeContext(deviceContext(header, expired, secrets["o1/dg1"]), method, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should download the secret again if the signature is not valid", func() {
+		rotated := map[string]string{"o1/dg1": "s1"}
+		source := &deviceSecretsMockup{source: NewStaticDeviceSecretSource(rotated)}
+		rotatedCfg, dErr := NewDeviceConfig(authConfig, source, header, DefaultCacheEntries)
+		gomega.Expect(dErr).To(gomega.Succeed())
+		claim := token.NewDeviceClaim("o1", "dg1", "d1", time.Hour)
+		_, err := authorizeContext(deviceContext(header, claim, "s1"), method, rotatedCfg)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		rotated["o1/dg1"] = "s2"
+		// the recently downloaded secrets are not downloaded again
+		_, err = authorizeContext(deviceContext(header, claim, "s2"), method, rotatedCfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(source.requests).To(gomega.Equal(1))
+
+		entry, _ := rotatedCfg.DeviceSecrets.(*CachedDeviceSecretSource).get("o1", "dg1")
+		entry.fetchedAt = time.Now().Add(-time.Minute)
+		_, err = authorizeContext(deviceContext(header, claim, "s2"), method, rotatedCfg)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(source.requests).To(gomega.Equal(2))
+		_, err = authorizeContext(deviceContext(header, claim, "s1"), method, rotatedCfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(source.requests).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should check the permissions of the devices", func() {
+		claim := token.NewDeviceClaim("o1", "dg1", "d1", time.Hour)
+		_, err := authorizeContext(deviceContext(header, claim, secrets["o1/dg1"]), userMethod, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should reject the user tokens", func() {
+		claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", []string{"DEVICE"}, "o1"), "authx",
+			time.Now(), time.Hour)
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secrets["o1/dg1"]))
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.New(map[string]string{header: tokenString}))
+		_, err := authorizeContext(ctx, method, cfg)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+})
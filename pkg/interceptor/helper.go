@@ -21,11 +21,13 @@ import (
 	"context"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-authx-go"
-	"google.golang.org/grpc/metadata"
 )
 
 const UserIdField = "user_id"
 const OrganizationIdField = "organization_id"
+const DeviceGroupIdField = "device_group_id"
+const DeviceIdField = "device_id"
+const PrincipalTypeField = "principal_type"
 
 type RequestMetadata struct {
 	UserID                 string
@@ -35,36 +37,62 @@ type RequestMetadata struct {
 	ResourcePrimitive      bool
 	ProfilePrimitive       bool
 	AppClusterOpsPrimitive bool
+	OrgMngtPrimitive       bool
+	ResourcesMngtPrimitive bool
+	DevicePrimitive        bool
+}
+
+// newRequestMetadata creates the request metadata with a function that checks the primitives.
+func newRequestMetadata(userID string, organizationID string, hasPrimitive func(primitive string) bool) *RequestMetadata {
+	return &RequestMetadata{
+		UserID:                 userID,
+		OrganizationID:         organizationID,
+		OrgPrimitive:           hasPrimitive(grpc_authx_go.AccessPrimitive_ORG.String()),
+		AppsPrimitive:          hasPrimitive(grpc_authx_go.AccessPrimitive_APPS.String()),
+		ResourcePrimitive:      hasPrimitive(grpc_authx_go.AccessPrimitive_RESOURCES.String()),
+		ProfilePrimitive:       hasPrimitive(grpc_authx_go.AccessPrimitive_PROFILE.String()),
+		AppClusterOpsPrimitive: hasPrimitive(grpc_authx_go.AccessPrimitive_APPCLUSTEROPS.String()),
+		OrgMngtPrimitive:       hasPrimitive(grpc_authx_go.AccessPrimitive_ORG_MNGT.String()),
+		ResourcesMngtPrimitive: hasPrimitive(grpc_authx_go.AccessPrimitive_RESOURCES_MNGT.String()),
+		DevicePrimitive:        hasPrimitive(grpc_authx_go.AccessPrimitive_DEVICE.String()),
+	}
 }
 
 // GetRequestMetadata extracts the request metadata from the context so that it
-// can be easily consumed by upper layers.
+// can be easily consumed by upper layers. The metadata is obtained from the principal added by the interceptors or
+// the HTTP middleware, see PrincipalFromContext. The incoming gRPC metadata is not used as it can be sent by the
+// clients, so an Unauthenticated error is returned if the request has not been authenticated.
 func GetRequestMetadata(ctx context.Context) (*RequestMetadata, derrors.Error) {
-	md, ok := metadata.FromIncomingContext(ctx)
+	principal, ok := PrincipalFromContext(ctx)
 	if !ok {
-		return nil, derrors.NewInvalidArgumentError("expecting JWT metadata")
+		return nil, derrors.NewUnauthenticatedError("the request has not been authenticated")
 	}
-	userID, found := md[UserIdField]
-	if !found {
+	if principal.IsDevice() {
 		return nil, derrors.NewUnauthenticatedError("userID not found")
 	}
-	organizationID, found := md[OrganizationIdField]
-	if !found {
-		return nil, derrors.NewUnauthenticatedError("organizationID not found")
-	}
-	_, orgPrimitive := md[grpc_authx_go.AccessPrimitive_ORG.String()]
-	_, appsPrimitive := md[grpc_authx_go.AccessPrimitive_APPS.String()]
-	_, resourcePrimitive := md[grpc_authx_go.AccessPrimitive_RESOURCES.String()]
-	_, profilePrimitive := md[grpc_authx_go.AccessPrimitive_PROFILE.String()]
-	_, appClusterOpsPrimitive := md[grpc_authx_go.AccessPrimitive_APPCLUSTEROPS.String()]
+	return newRequestMetadata(principal.UserID, principal.OrganizationID, principal.HasPrimitive), nil
+}
 
-	return &RequestMetadata{
-		UserID:                 userID[0],
-		OrganizationID:         organizationID[0],
-		OrgPrimitive:           orgPrimitive,
-		AppsPrimitive:          appsPrimitive,
-		ResourcePrimitive:      resourcePrimitive,
-		ProfilePrimitive:       profilePrimitive,
-		AppClusterOpsPrimitive: appClusterOpsPrimitive,
+// DeviceRequestMetadata contains the identifiers of the device that sends a request.
+type DeviceRequestMetadata struct {
+	OrganizationID string
+	DeviceGroupID  string
+	DeviceID       string
+}
+
+// GetDeviceRequestMetadata extracts the device metadata added by an interceptor or a HTTP middleware in device mode.
+// Like GetRequestMetadata, it returns an Unauthenticated error if the request has not been authenticated.
+func GetDeviceRequestMetadata(ctx context.Context) (*DeviceRequestMetadata, derrors.Error) {
+	principal, ok := PrincipalFromContext(ctx)
+	if !ok {
+		return nil, derrors.NewUnauthenticatedError("the request has not been authenticated")
+	}
+	if !principal.IsDevice() {
+		return nil, derrors.NewUnauthenticatedError("deviceID not found")
+	}
+	return &DeviceRequestMetadata{
+		OrganizationID: principal.OrganizationID,
+		DeviceGroupID:  principal.DeviceGroupID,
+		DeviceID:       principal.DeviceID,
 	}, nil
 }
@@ -0,0 +1,160 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// bearerPrefix is the authentication scheme of the HTTP tokens.
+const bearerPrefix = "Bearer "
+
+// WithHTTPAuthxMiddleware returns a net/http middleware that verifies that the user is authorized to use an endpoint,
+// using the bearer token of the request. The permissions are indexed by the HTTP method and a path pattern separated
+// by a space, such as "GET /v1/users/*". The patterns use the syntax of path.Match and the method can be "*" to
+// match any method. If several patterns match a request, the longest one is used. The patterns are matched against
+// the escaped path of the request without the trailing slash. The requests whose path is not canonical, as returned
+// by path.Clean, or is not escaped in the standard way, such as an escaped slash, are rejected.
+func WithHTTPAuthxMiddleware(config *Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, dErr := authorizeHTTPRequest(r, config)
+			if dErr != nil {
+				writeHTTPError(w, dErr)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authorizeHTTPRequest verifies that the user is authorized to send a request and returns the context that must be
+// passed to the handler. The context includes the principal if the endpoint requires a token.
+func authorizeHTTPRequest(r *http.Request, config *Config) (context.Context, derrors.Error) {
+	requestPath, dErr := canonicalPath(r)
+	if dErr != nil {
+		return nil, dErr
+	}
+	method, permission, found := httpPermission(r.Method, requestPath, config.Authorization)
+	if !found {
+		if !config.Authorization.AllowsAll {
+			return nil, derrors.NewUnauthenticatedError("unauthorized method").WithParams(r.Method, requestPath)
+		}
+		log.Warn().Msg("auth metadata has not been added")
+		return r.Context(), nil
+	}
+	t, dErr := bearerToken(r, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	principal, dErr := authenticate(r.Context(), t, method, permission, config)
+	if dErr != nil {
+		return nil, dErr
+	}
+	return ContextWithPrincipal(r.Context(), principal), nil
+}
+
+// canonicalPath returns the path used to match the patterns of a request. The patterns are matched against the
+// escaped path, which is the representation used by the routers to split the segments. The path must be canonical,
+// otherwise a path such as /public/../admin could match the permission of a different endpoint than the one served
+// by the handler. The paths escaped in a non standard way are rejected too, so the escaped and unescaped paths have
+// the same segments and any router serves the endpoint matched by the pattern.
+func canonicalPath(r *http.Request) (string, derrors.Error) {
+	if r.URL.RawPath != "" {
+		return "", derrors.NewInvalidArgumentError("the path of the request is not canonical").WithParams(r.URL.RawPath)
+	}
+	requestPath := r.URL.EscapedPath()
+	if len(requestPath) > 1 && strings.HasSuffix(requestPath, "/") {
+		requestPath = requestPath[:len(requestPath)-1]
+	}
+	if path.Clean(requestPath) != requestPath {
+		return "", derrors.NewInvalidArgumentError("the path of the request is not canonical").WithParams(r.URL.Path)
+	}
+	return requestPath, nil
+}
+
+// httpPermission returns the key and the permission that apply to a request. The default permission is used if no
+// pattern matches the request.
+func httpPermission(method string, requestPath string, authorization *AuthorizationConfig) (string, *Permission, bool) {
+	if key, found := matchHTTPPermission(method, requestPath, authorization); found {
+		permission := authorization.Permissions[key]
+		return key, &permission, true
+	}
+	if authorization.Default != nil {
+		return method + " " + requestPath, authorization.Default, true
+	}
+	return "", nil, false
+}
+
+// matchHTTPPermission returns the key of the permission that applies to a request.
+func matchHTTPPermission(requestMethod string, requestPath string, authorization *AuthorizationConfig) (string, bool) {
+	result := ""
+	resultPattern := ""
+	for key := range authorization.Permissions {
+		separator := strings.Index(key, " ")
+		if separator < 0 {
+			continue
+		}
+		method, pattern := key[:separator], key[separator+1:]
+		if method != requestMethod && method != "*" {
+			continue
+		}
+		if matched, err := path.Match(pattern, requestPath); err != nil || !matched {
+			continue
+		}
+		if result == "" || len(pattern) > len(resultPattern) ||
+			(len(pattern) == len(resultPattern) && key < result) {
+			result, resultPattern = key, pattern
+		}
+	}
+	return result, result != ""
+}
+
+// bearerToken returns the token of the authorization header of a request.
+func bearerToken(r *http.Request, config *Config) (string, derrors.Error) {
+	value := r.Header.Get(config.Header)
+	if value == "" {
+		return "", derrors.NewUnauthenticatedError("token is not supplied")
+	}
+	if len(value) <= len(bearerPrefix) || !strings.EqualFold(value[:len(bearerPrefix)], bearerPrefix) {
+		return "", derrors.NewUnauthenticatedError("token is not a bearer token")
+	}
+	return value[len(bearerPrefix):], nil
+}
+
+// writeHTTPError sends the status code that corresponds to an error.
+func writeHTTPError(w http.ResponseWriter, err derrors.Error) {
+	code := http.StatusInternalServerError
+	switch err.Type() {
+	case derrors.Unauthenticated:
+		code = http.StatusUnauthorized
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case derrors.InvalidArgument:
+		code = http.StatusBadRequest
+	case derrors.PermissionDenied:
+		code = http.StatusForbidden
+	case derrors.Unavailable:
+		code = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), code)
+}
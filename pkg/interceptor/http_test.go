@@ -0,0 +1,118 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+var _ = ginkgo.Describe("HTTP middleware", func() {
+	secret := "myLittleSecret"
+	header := "Authorization"
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: false, Permissions: map[string]Permission{
+		"GET /v1/users/*":       {Must: []string{"PROFILE"}},
+		"GET /v1/users/me":      {Must: []string{"ORG"}},
+		"* /v1/users/*/keys":    {Must: []string{"ORG"}},
+		"POST /v1/webhooks/*/*": {},
+	}}, secret, header)
+
+	var received *RequestMetadata
+	handler := WithHTTPAuthxMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestMetadata, err := GetRequestMetadata(r.Context())
+		gomega.Expect(err).To(gomega.Succeed())
+		received = requestMetadata
+	}))
+
+	bearer := func(primitives []string) string {
+		claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", primitives, "o1"), "authx", time.Now(), time.Hour)
+		tokenString, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secret))
+		return "Bearer " + tokenString
+	}
+	serve := func(method string, path string, authorization string) int {
+		received = nil
+		request := httptest.NewRequest(method, path, nil)
+		if authorization != "" {
+			request.Header.Set(header, authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	ginkgo.It("should expose the request metadata to the handler", func() {
+		code := serve(http.MethodGet, "/v1/users/u1", bearer([]string{"PROFILE"}))
+		gomega.Expect(code).To(gomega.Equal(http.StatusOK))
+		gomega.Expect(received).NotTo(gomega.BeNil())
+		gomega.Expect(received.UserID).To(gomega.Equal("u1"))
+		gomega.Expect(received.OrganizationID).To(gomega.Equal("o1"))
+		gomega.Expect(received.ProfilePrimitive).To(gomega.BeTrue())
+		gomega.Expect(received.OrgPrimitive).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should use the most specific pattern", func() {
+		gomega.Expect(serve(http.MethodGet, "/v1/users/me", bearer([]string{"PROFILE"}))).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodGet, "/v1/users/me", bearer([]string{"ORG"}))).
+			To(gomega.Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should match any method", func() {
+		gomega.Expect(serve(http.MethodGet, "/v1/users/u1/keys", bearer([]string{"PROFILE"}))).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodDelete, "/v1/users/u1/keys", bearer([]string{"ORG"}))).
+			To(gomega.Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should reject requests without a valid bearer token", func() {
+		gomega.Expect(serve(http.MethodPost, "/v1/webhooks/o1/w1", "")).To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodPost, "/v1/webhooks/o1/w1", bearer(nil)[len("Bearer "):])).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodPost, "/v1/webhooks/o1/w1", "Bearer invalid")).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodPost, "/v1/webhooks/o1/w1", bearer(nil))).To(gomega.Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should reject the paths that are not canonical", func() {
+		for _, p := range []string{"/v1/webhooks/o1/../../users/me", "/v1/users//me", "/v1/users/./me", "/v1/users/me//",
+			"/v1/webhooks/o1/w1%2F..%2F..%2Fusers", "/v1/users/u1%2Fkeys", "/v1/users/%6De"} {
+			gomega.Expect(serve(http.MethodPost, p, bearer(nil))).To(gomega.Equal(http.StatusBadRequest))
+			gomega.Expect(received).To(gomega.BeNil())
+		}
+	})
+
+	ginkgo.It("should ignore the trailing slash", func() {
+		gomega.Expect(serve(http.MethodGet, "/v1/users/me/", bearer([]string{"PROFILE"}))).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodGet, "/v1/users/me/", bearer([]string{"ORG"}))).
+			To(gomega.Equal(http.StatusOK))
+	})
+
+	ginkgo.It("should reject the endpoints without permissions", func() {
+		gomega.Expect(serve(http.MethodPost, "/v1/users/u1", bearer([]string{"PROFILE"}))).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(serve(http.MethodGet, "/v1/other", bearer([]string{"PROFILE"}))).
+			To(gomega.Equal(http.StatusUnauthorized))
+		gomega.Expect(received).To(gomega.BeNil())
+	})
+})
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package interceptor
+
+import (
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultKeySetRefreshInterval is the maximum age of a downloaded key set.
+const DefaultKeySetRefreshInterval = time.Minute
+
+// KeySource provides the public keys to verify the tokens signed with asymmetric algorithms.
+type KeySource interface {
+	// VerificationKey returns the public key with a given kid and algorithm.
+	VerificationKey(keyID string, algorithm string) (interface{}, derrors.Error)
+}
+
+// RemoteKeySet is a key source that downloads the JWKS published by authx. The key set is downloaded again once it
+// is older than RefreshInterval, so newly promoted keys are accepted and retired keys are rejected without
+// restarting the service.
+type RemoteKeySet struct {
+	sync.Mutex
+	// URL of the JWKS document.
+	URL string
+	// RefreshInterval is the maximum age of the downloaded key set.
+	RefreshInterval time.Duration
+	client          *http.Client
+	keySet          *token.JSONWebKeySet
+	lastRefresh     time.Time
+	// downloading is closed when the download in progress finishes. It is nil if there is no download.
+	downloading chan struct{}
+	downloadErr derrors.Error
+}
+
+// NewRemoteKeySet creates a key source for the JWKS published in a given URL.
+func NewRemoteKeySet(url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		URL:             url,
+		RefreshInterval: DefaultKeySetRefreshInterval,
+		client:          &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// VerificationKey returns the public key with a given kid and algorithm.
+func (r *RemoteKeySet) VerificationKey(keyID string, algorithm string) (interface{}, derrors.Error) {
+	keySet, err := r.current()
+	if err != nil {
+		return nil, err
+	}
+	return keySet.VerificationKey(keyID, algorithm)
+}
+
+// current returns the key set, downloading it if it is missing or too old. Only one download runs at a time and
+// the lock is not held while it runs, so the requests that arrive meanwhile wait for its result.
+func (r *RemoteKeySet) current() (*token.JSONWebKeySet, derrors.Error) {
+	r.Lock()
+	if r.keySet != nil && time.Since(r.lastRefresh) < r.RefreshInterval {
+		keySet := r.keySet
+		r.Unlock()
+		return keySet, nil
+	}
+	downloading := r.downloading
+	if downloading == nil {
+		downloading = make(chan struct{})
+		r.downloading = downloading
+		r.Unlock()
+		keySet, err := r.download()
+		r.Lock()
+		if err == nil {
+			r.keySet = keySet
+			r.lastRefresh = time.Now()
+		}
+		r.downloadErr = err
+		r.downloading = nil
+		close(downloading)
+		r.Unlock()
+		return keySet, err
+	}
+	r.Unlock()
+	<-downloading
+	r.Lock()
+	defer r.Unlock()
+	if r.downloadErr != nil {
+		return nil, r.downloadErr
+	}
+	return r.keySet, nil
+}
+
+// download retrieves and parses the JWKS document.
+func (r *RemoteKeySet) download() (*token.JSONWebKeySet, derrors.Error) {
+	response, err := r.client.Get(r.URL)
+	if err != nil {
+		return nil, derrors.NewUnavailableError("cannot download JWKS", err).WithParams(r.URL)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, derrors.NewUnavailableError("cannot download JWKS").WithParams(r.URL, response.Status)
+	}
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, derrors.NewUnavailableError("cannot read JWKS", err).WithParams(r.URL)
+	}
+	return token.ParseJSONWebKeySet(content)
+}
@@ -17,6 +17,10 @@
 
 package interceptor
 
+import (
+	"github.com/nalej/derrors"
+)
+
 // Permission is a set of rules that uses the define primitive of the system.
 type Permission struct {
 	// Must is a list of primitives that the role MUST contains. If the role doesn't include
@@ -26,39 +30,169 @@ type Permission struct {
 	Should []string `json:"should,omitempty"`
 	// MustNot is a list of primitive that the role MUST NOT include. The role must not include any primitive.
 	MustNot []string `json:"must_not,omitempty"`
+	// RequireMFA requires a token obtained with multi-factor authentication.
+	RequireMFA bool `json:"require_mfa,omitempty"`
+	// AllowMFAEnrolment allows the tokens restricted to the enrolment of MFA. They are rejected by the rest of
+	// methods.
+	AllowMFAEnrolment bool `json:"allow_mfa_enrolment,omitempty"`
+	// Principals is the list of principal types (user, service, device) that can use the method. If it is empty, all
+	// the principal types are allowed.
+	Principals []string `json:"principals,omitempty"`
+	// Rule is an expression that the principal must satisfy in addition to the rest of conditions.
+	Rule *Rule `json:"rule,omitempty"`
+}
+
+// AllowsPrincipal verifies if a type of principal can use the method.
+func (p *Permission) AllowsPrincipal(principalType string) bool {
+	if len(p.Principals) == 0 {
+		return true
+	}
+	for _, principal := range p.Principals {
+		if principal == principalType {
+			return true
+		}
+	}
+	return false
 }
 
 // Valid verifies if a list of primitives are valid for a set of rules.
 func (p *Permission) Valid(primitives []string) bool {
 	for _, must := range p.Must {
-		check := false
-		for _, pri := range primitives {
-			if !check && pri == must {
-				check = true
+		if !contains(primitives, must) {
+			return false
+		}
+	}
+	if len(p.Should) > 0 {
+		found := false
+		for _, should := range p.Should {
+			if contains(primitives, should) {
+				found = true
+				break
 			}
 		}
-		if !check {
+		if !found {
 			return false
 		}
 	}
-	counter := 0
-	for _, should := range p.Should {
-		for _, pri := range primitives {
-			if pri == should {
-				counter++
-			}
+	for _, mustNot := range p.MustNot {
+		if contains(primitives, mustNot) {
+			return false
+		}
+	}
+	return true
+}
+
+// Fields of the claim that can be used in the conditions of the rules.
+const (
+	PrincipalTypeClaimField  = "principal_type"
+	UserIDClaimField         = "user_id"
+	OrganizationIDClaimField = "organization_id"
+	RoleClaimField           = "role"
+	DeviceGroupIDClaimField  = "device_group_id"
+	DeviceIDClaimField       = "device_id"
+)
 
+// Rule is a composable expression over the principal of a request. A rule is satisfied if all the conditions that
+// it defines are satisfied, so an empty rule is always satisfied.
+type Rule struct {
+	// All is satisfied if all the rules are satisfied.
+	All []Rule `json:"all,omitempty"`
+	// Any is satisfied if at least one of the rules is satisfied.
+	Any []Rule `json:"any,omitempty"`
+	// Not is satisfied if the rule is not satisfied.
+	Not *Rule `json:"not,omitempty"`
+	// Primitive is satisfied if the token includes the primitive.
+	Primitive string `json:"primitive,omitempty"`
+	// Claim is satisfied if a field of the claim has one of the values.
+	Claim *ClaimCondition `json:"claim,omitempty"`
+}
+
+// ClaimCondition checks the value of a field of the claim.
+type ClaimCondition struct {
+	// Field of the claim: principal_type, user_id, organization_id, role, device_group_id or device_id.
+	Field string `json:"field"`
+	// Values contains the accepted values of the field.
+	Values []string `json:"values"`
+}
+
+// Evaluate checks if a principal satisfies the rule.
+func (r *Rule) Evaluate(principal *Principal) bool {
+	for _, rule := range r.All {
+		if !rule.Evaluate(principal) {
+			return false
+		}
+	}
+	if len(r.Any) > 0 {
+		found := false
+		for _, rule := range r.Any {
+			if rule.Evaluate(principal) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
 		}
 	}
-	if len(p.Should) > 0 && counter == 0 {
+	if r.Not != nil && r.Not.Evaluate(principal) {
 		return false
 	}
-	for _, mustNo := range p.MustNot {
-		for _, pri := range primitives {
-			if pri == mustNo {
-				return false
-			}
+	if r.Primitive != "" && !principal.HasPrimitive(r.Primitive) {
+		return false
+	}
+	if r.Claim != nil {
+		value, found := claimField(principal, r.Claim.Field)
+		if !found || !contains(r.Claim.Values, value) {
+			return false
 		}
 	}
 	return true
 }
+
+// Validate checks that the rule and its nested rules have a condition and that the claim conditions use known
+// fields. A rule without conditions is satisfied by any principal, so it is usually a typo in the configuration.
+func (r *Rule) Validate() derrors.Error {
+	if len(r.All) == 0 && len(r.Any) == 0 && r.Not == nil && r.Primitive == "" && r.Claim == nil {
+		return derrors.NewInvalidArgumentError("the rule has no condition")
+	}
+	for _, rule := range r.All {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, rule := range r.Any {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Not != nil {
+		if err := r.Not.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Claim != nil {
+		if _, found := claimField(&Principal{}, r.Claim.Field); !found {
+			return derrors.NewInvalidArgumentError("unknown claim field").WithParams(r.Claim.Field)
+		}
+	}
+	return nil
+}
+
+// claimField returns the value of a field of the claim of a principal.
+func claimField(principal *Principal, field string) (string, bool) {
+	switch field {
+	case PrincipalTypeClaimField:
+		return principal.Type, true
+	case UserIDClaimField:
+		return principal.UserID, true
+	case OrganizationIDClaimField:
+		return principal.OrganizationID, true
+	case RoleClaimField:
+		return principal.RoleName, true
+	case DeviceGroupIDClaimField:
+		return principal.DeviceGroupID, true
+	case DeviceIDClaimField:
+		return principal.DeviceID, true
+	}
+	return "", false
+}
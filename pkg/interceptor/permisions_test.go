@@ -280,4 +280,70 @@ var _ = ginkgo.Describe("Permissions", func() {
 		})
 
 	})
+
+	ginkgo.Context("with a should primitive included twice", func() {
+		p := Permission{Should: []string{"primitive", "primitive"}}
+
+		ginkgo.It("allow with the primitive", func() {
+			valid := p.Valid([]string{"primitive"})
+			gomega.Expect(valid).To(gomega.BeTrue())
+		})
+	})
+})
+
+var _ = ginkgo.Describe("Rules", func() {
+	user := &Principal{Type: "user", UserID: "u1", OrganizationID: "org1", RoleName: "admin",
+		Primitives: []string{"APPS", "RESOURCES"}}
+	service := &Principal{Type: "service", UserID: "s1", OrganizationID: "org2", Primitives: []string{"APPS"}}
+
+	ginkgo.It("allows with an empty rule", func() {
+		rule := Rule{}
+		gomega.Expect(rule.Evaluate(user)).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("checks a primitive", func() {
+		rule := Rule{Primitive: "RESOURCES"}
+		gomega.Expect(rule.Evaluate(user)).To(gomega.BeTrue())
+		gomega.Expect(rule.Evaluate(service)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("checks a claim field", func() {
+		rule := Rule{Claim: &ClaimCondition{Field: OrganizationIDClaimField, Values: []string{"org1", "org3"}}}
+		gomega.Expect(rule.Evaluate(user)).To(gomega.BeTrue())
+		gomega.Expect(rule.Evaluate(service)).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("combines nested rules", func() {
+		rule := Rule{
+			Any: []Rule{
+				{All: []Rule{
+					{Claim: &ClaimCondition{Field: PrincipalTypeClaimField, Values: []string{"user"}}},
+					{Claim: &ClaimCondition{Field: RoleClaimField, Values: []string{"admin"}}},
+				}},
+				{Claim: &ClaimCondition{Field: PrincipalTypeClaimField, Values: []string{"service"}}},
+			},
+			Not: &Rule{Primitive: "RESOURCES"},
+		}
+		gomega.Expect(rule.Evaluate(user)).To(gomega.BeFalse())
+		gomega.Expect(rule.Evaluate(service)).To(gomega.BeTrue())
+		rule.Not = nil
+		gomega.Expect(rule.Evaluate(user)).To(gomega.BeTrue())
+		gomega.Expect(rule.Evaluate(&Principal{Type: "user", RoleName: "developer"})).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("rejects an unknown claim field", func() {
+		rule := Rule{Any: []Rule{{Not: &Rule{Claim: &ClaimCondition{Field: "email"}}}}}
+		gomega.Expect(rule.Validate()).To(gomega.HaveOccurred())
+		rule = Rule{Any: []Rule{{Not: &Rule{Claim: &ClaimCondition{Field: DeviceIDClaimField}}}}}
+		gomega.Expect(rule.Validate()).To(gomega.Succeed())
+	})
+
+	ginkgo.It("rejects a rule without conditions", func() {
+		rule := Rule{}
+		gomega.Expect(rule.Validate()).To(gomega.HaveOccurred())
+		rule = Rule{Any: []Rule{{Primitive: "APPS"}, {}}}
+		gomega.Expect(rule.Validate()).To(gomega.HaveOccurred())
+		rule = Rule{Not: &Rule{}}
+		gomega.Expect(rule.Validate()).To(gomega.HaveOccurred())
+	})
 })
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"context"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/grpc-authx-go"
+	"google.golang.org/grpc/metadata"
+	"strings"
+)
+
+// Principal is the verified identity of the user, service account or device that sends a request.
+type Principal struct {
+	// Type of principal: user, service or device.
+	Type string
+	// UserID identifies the user or the service account. It is empty for the devices.
+	UserID         string
+	OrganizationID string
+	// RoleName is the name of the role of the user or the service account.
+	RoleName      string
+	DeviceGroupID string
+	DeviceID      string
+	// Primitives contains all the primitives of the token.
+	Primitives []string
+	// Claim is the verified claim of a user or service account token.
+	Claim *token.Claim
+	// DeviceClaim is the verified claim of a device token.
+	DeviceClaim *token.DeviceClaim
+}
+
+// newUserPrincipal creates the principal of a user or service account token.
+func newUserPrincipal(claim *token.Claim) *Principal {
+	return &Principal{
+		Type:           claim.GetPrincipalType(),
+		UserID:         claim.UserID,
+		OrganizationID: claim.OrganizationID,
+		RoleName:       claim.RoleName,
+		Primitives:     claim.Primitives,
+		Claim:          claim,
+	}
+}
+
+// newDevicePrincipal creates the principal of a device token.
+func newDevicePrincipal(claim *token.DeviceClaim) *Principal {
+	return &Principal{
+		Type:           token.DevicePrincipal,
+		OrganizationID: claim.OrganizationID,
+		DeviceGroupID:  claim.DeviceGroupID,
+		DeviceID:       claim.DeviceID,
+		Primitives:     claim.Primitives,
+		DeviceClaim:    claim,
+	}
+}
+
+// IsDevice checks if the principal is a device.
+func (p *Principal) IsDevice() bool {
+	return p.Type == token.DevicePrincipal
+}
+
+// HasPrimitive checks if the token of the principal includes a primitive.
+func (p *Principal) HasPrimitive(primitive string) bool {
+	for _, pri := range p.Primitives {
+		if pri == primitive {
+			return true
+		}
+	}
+	return false
+}
+
+// metadata returns the pairs of the incoming metadata that describe the principal. They are kept for the services
+// that read the metadata, the new ones should use PrincipalFromContext.
+func (p *Principal) metadata() []string {
+	values := make([]string, 0)
+	if p.IsDevice() {
+		values = append(values, OrganizationIdField, p.OrganizationID, DeviceGroupIdField, p.DeviceGroupID,
+			DeviceIdField, p.DeviceID)
+	} else {
+		values = append(values, UserIdField, p.UserID, OrganizationIdField, p.OrganizationID)
+	}
+	values = append(values, PrincipalTypeField, p.Type)
+	for _, pri := range p.Primitives {
+		values = append(values, pri, "true")
+	}
+	return values
+}
+
+// principalKey is the key of the principal in the context.
+type principalKey struct{}
+
+// ContextWithPrincipal returns a copy of the context that includes a principal.
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal added to the context by the interceptors or the HTTP middleware. It is
+// the only trusted source of the identity of the caller: the principal is only added after the token has been
+// verified, and it is not found in the requests that have not been authenticated, such as the methods without
+// permissions when AllowsAll is set.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// stripReservedMetadata removes from the incoming metadata the keys that describe the principal, so they cannot be
+// set by the clients.
+func stripReservedMetadata(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	stripped := md.Copy()
+	for key := range stripped {
+		if isReservedKey(key) {
+			delete(stripped, key)
+		}
+	}
+	return metadata.NewIncomingContext(ctx, stripped)
+}
+
+// isReservedKey checks if a metadata key is added by the interceptor.
+func isReservedKey(key string) bool {
+	switch strings.ToLower(key) {
+	case UserIdField, OrganizationIdField, PrincipalTypeField, DeviceGroupIdField, DeviceIdField,
+		strings.ToLower(token.MFAEnrolmentPrimitive):
+		return true
+	}
+	_, found := grpc_authx_go.AccessPrimitive_value[strings.ToUpper(key)]
+	return found
+}
@@ -0,0 +1,104 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package interceptor
+
+import (
+	"context"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/authx/pkg/token"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"time"
+)
+
+var _ = ginkgo.Describe("Principal in context", func() {
+	secret := "myLittleSecret"
+	header := "auth"
+	method := "/test.Test/Method"
+	cfg := NewConfig(&AuthorizationConfig{AllowsAll: true,
+		Permissions: map[string]Permission{method: {Must: []string{"ORG_MNGT"}}}}, secret, header)
+	primitives := []string{pbAuthx.AccessPrimitive_ORG_MNGT.String(), pbAuthx.AccessPrimitive_DEVICE.String()}
+
+	// intercept calls a method with some metadata and returns the context received by the handler.
+	intercept := func(method string, md metadata.MD) context.Context {
+		var received context.Context
+		_, err := authxInterceptor(cfg)(metadata.NewIncomingContext(context.Background(), md), nil,
+			&grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				received = ctx
+				return nil, nil
+			})
+		gomega.Expect(err).To(gomega.Succeed())
+		return received
+	}
+	tokenString := func() string {
+		claim := token.NewClaim(*token.NewPersonalClaim("u1", "r1", primitives, "o1"), "authx", time.Now(), time.Hour)
+		result, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, claim).SignedString([]byte(secret))
+		return result
+	}
+
+	ginkgo.It("should include the verified principal", func() {
+		ctx := intercept(method, metadata.Pairs(header, tokenString()))
+		principal, found := PrincipalFromContext(ctx)
+		gomega.Expect(found).To(gomega.BeTrue())
+		gomega.Expect(principal.Type).To(gomega.Equal(token.UserPrincipal))
+		gomega.Expect(principal.UserID).To(gomega.Equal("u1"))
+		gomega.Expect(principal.RoleName).To(gomega.Equal("r1"))
+		gomega.Expect(principal.Primitives).To(gomega.Equal(primitives))
+		gomega.Expect(principal.Claim).NotTo(gomega.BeNil())
+
+		requestMetadata, err := GetRequestMetadata(ctx)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(requestMetadata.OrgMngtPrimitive).To(gomega.BeTrue())
+		gomega.Expect(requestMetadata.DevicePrimitive).To(gomega.BeTrue())
+		gomega.Expect(requestMetadata.OrgPrimitive).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should replace the metadata sent by the client", func() {
+		ctx := intercept(method, metadata.Pairs(header, tokenString(), UserIdField, "admin", "ORG", "true"))
+		md, _ := metadata.FromIncomingContext(ctx)
+		gomega.Expect(md.Get(UserIdField)).To(gomega.Equal([]string{"u1"}))
+		gomega.Expect(md.Get("ORG")).To(gomega.BeEmpty())
+		gomega.Expect(md.Get(header)).NotTo(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should strip the reserved metadata of the methods without permissions", func() {
+		ctx := intercept("/test.Test/Public", metadata.Pairs(UserIdField, "admin", OrganizationIdField, "o2",
+			"org_mngt", "true", "other", "value"))
+		_, found := PrincipalFromContext(ctx)
+		gomega.Expect(found).To(gomega.BeFalse())
+		md, _ := metadata.FromIncomingContext(ctx)
+		gomega.Expect(md).To(gomega.Equal(metadata.Pairs("other", "value")))
+		_, err := GetRequestMetadata(ctx)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+	})
+
+	ginkgo.It("should not trust the metadata without a principal", func() {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(UserIdField, "admin",
+			OrganizationIdField, "o1", DeviceGroupIdField, "dg1", DeviceIdField, "d1", "org_mngt", "true"))
+		_, err := GetRequestMetadata(ctx)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+		_, err = GetDeviceRequestMetadata(ctx)
+		gomega.Expect(err).To(gomega.HaveOccurred())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.Unauthenticated))
+	})
+})
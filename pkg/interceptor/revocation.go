@@ -0,0 +1,92 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package interceptor
+
+import (
+	"context"
+	"github.com/hashicorp/golang-lru"
+	"github.com/nalej/derrors"
+	pbAuthx "github.com/nalej/grpc-authx-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"time"
+)
+
+// DefaultRevocationCacheTTL is the time a token that is not revoked is cached.
+const DefaultRevocationCacheTTL = 5 * time.Second
+
+// RevocationChecker checks if a token has been revoked before its expiration.
+type RevocationChecker interface {
+	// IsRevoked checks if the token with the given jti has been revoked.
+	IsRevoked(ctx context.Context, tokenID string) (bool, derrors.Error)
+}
+
+// AuthxRevocationChecker asks authx for the status of the tokens.
+type AuthxRevocationChecker struct {
+	client pbAuthx.AuthxClient
+}
+
+// NewAuthxRevocationChecker creates a checker that uses the authx client.
+func NewAuthxRevocationChecker(client pbAuthx.AuthxClient) *AuthxRevocationChecker {
+	return &AuthxRevocationChecker{client: client}
+}
+
+// IsRevoked checks if the token with the given jti has been revoked.
+func (c *AuthxRevocationChecker) IsRevoked(ctx context.Context, tokenID string) (bool, derrors.Error) {
+	revocation, err := c.client.IsTokenRevoked(ctx, &pbAuthx.TokenId{TokenId: tokenID})
+	if err != nil {
+		return false, conversions.ToDerror(err)
+	}
+	return revocation.Revoked, nil
+}
+
+// CachedRevocationChecker caches the answers of another checker. A revoked token cannot be restored so it is cached
+// until it is evicted, while a valid token is cached only for a short period.
+type CachedRevocationChecker struct {
+	checker RevocationChecker
+	cache   *lru.Cache
+	ttl     time.Duration
+}
+
+type revocationEntry struct {
+	revoked   bool
+	checkedAt time.Time
+}
+
+// NewCachedRevocationChecker creates a cache of numEntries tokens on top of a checker.
+func NewCachedRevocationChecker(checker RevocationChecker, numEntries int, ttl time.Duration) (*CachedRevocationChecker, derrors.Error) {
+	cache, err := lru.New(numEntries)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible create revocation cache", err)
+	}
+	return &CachedRevocationChecker{checker: checker, cache: cache, ttl: ttl}, nil
+}
+
+// IsRevoked checks if the token with the given jti has been revoked.
+func (c *CachedRevocationChecker) IsRevoked(ctx context.Context, tokenID string) (bool, derrors.Error) {
+	if value, found := c.cache.Get(tokenID); found {
+		entry := value.(revocationEntry)
+		if entry.revoked || time.Since(entry.checkedAt) < c.ttl {
+			return entry.revoked, nil
+		}
+	}
+	revoked, err := c.checker.IsRevoked(ctx, tokenID)
+	if err != nil {
+		return false, err
+	}
+	c.cache.Add(tokenID, revocationEntry{revoked: revoked, checkedAt: time.Now()})
+	return revoked, nil
+}
@@ -0,0 +1,96 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+package interceptor
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+// revocationListMockup is a revocation checker that counts the number of requests.
+type revocationListMockup struct {
+	sync.Mutex
+	revoked  map[string]bool
+	requests int
+}
+
+func newRevocationListMockup() *revocationListMockup {
+	return &revocationListMockup{revoked: make(map[string]bool, 0)}
+}
+
+func (r *revocationListMockup) IsRevoked(_ context.Context, tokenID string) (bool, derrors.Error) {
+	r.Lock()
+	defer r.Unlock()
+	r.requests++
+	return r.revoked[tokenID], nil
+}
+
+func (r *revocationListMockup) revoke(tokenID string) {
+	r.Lock()
+	defer r.Unlock()
+	r.revoked[tokenID] = true
+}
+
+var _ = ginkgo.Describe("CachedRevocationChecker", func() {
+
+	ginkgo.It("should cache the revoked tokens", func() {
+		list := newRevocationListMockup()
+		list.revoke("t1")
+		checker, err := NewCachedRevocationChecker(list, DefaultCacheEntries, DefaultRevocationCacheTTL)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		for i := 0; i < 3; i++ {
+			revoked, err := checker.IsRevoked(context.Background(), "t1")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(revoked).To(gomega.BeTrue())
+		}
+		gomega.Expect(list.requests).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should check again a valid token when the entry expires", func() {
+		list := newRevocationListMockup()
+		checker, err := NewCachedRevocationChecker(list, DefaultCacheEntries, 0)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		revoked, err := checker.IsRevoked(context.Background(), "t1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeFalse())
+
+		list.revoke("t1")
+		revoked, err = checker.IsRevoked(context.Background(), "t1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(revoked).To(gomega.BeTrue())
+		gomega.Expect(list.requests).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should cache a valid token during the TTL", func() {
+		list := newRevocationListMockup()
+		checker, err := NewCachedRevocationChecker(list, DefaultCacheEntries, time.Minute)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		for i := 0; i < 3; i++ {
+			revoked, err := checker.IsRevoked(context.Background(), "t1")
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(revoked).To(gomega.BeFalse())
+		}
+		gomega.Expect(list.requests).To(gomega.Equal(1))
+	})
+})
@@ -23,12 +23,90 @@ import (
 	"time"
 )
 
+// Authentication methods included in the amr claim, as defined in RFC 8176.
+const (
+	// PasswordAuthentication is the authentication with a password.
+	PasswordAuthentication = "pwd"
+	// OTPAuthentication is the authentication with a one-time password.
+	OTPAuthentication = "otp"
+	// MultiFactorAuthentication indicates that more than one factor has been used.
+	MultiFactorAuthentication = "mfa"
+)
+
+// MFAEnrolmentPrimitive is the only primitive of the restricted tokens issued to the users that must enrol MFA
+// before accessing the system.
+const MFAEnrolmentPrimitive = "MFA_ENROLMENT"
+
+// PersonalAccessTokenSource is the source of the tokens obtained with a personal access token.
+const PersonalAccessTokenSource = "personal_access_token"
+
+// Types of principal that a token represents.
+const (
+	// UserPrincipal is a person. Tokens without a principal type belong to users.
+	UserPrincipal = "user"
+	// ServicePrincipal is a service account used by the platform components.
+	ServicePrincipal = "service"
+	// DevicePrincipal is a device of a device group.
+	DevicePrincipal = "device"
+)
+
+// Actor identifies the party that acts on behalf of the subject of a token, as defined in RFC 8693.
+type Actor struct {
+	Subject       string `json:"sub"`
+	PrincipalType string `json:"principalType,omitempty"`
+	// Actor is the previous actor of a chain of delegations.
+	Actor *Actor `json:"act,omitempty"`
+}
+
 // PersonalClaim is the claim that include system information.
 type PersonalClaim struct {
 	UserID         string   `json:"userID,omitempty"`
 	Primitives     []string `json:"access,omitempty"`
 	RoleName       string   `json:"role,omitempty"`
 	OrganizationID string   `json:"organizationID,omitempty"`
+	// AuthenticationMethods contains the methods used to authenticate the user.
+	AuthenticationMethods []string `json:"amr,omitempty"`
+	// TokenSource identifies the credential used to obtain the token when it is not the user login.
+	TokenSource string `json:"tokenSource,omitempty"`
+	// TokenSourceID is the identifier of the credential used to obtain the token.
+	TokenSourceID string `json:"tokenSourceID,omitempty"`
+	// PrincipalType is the type of principal identified by UserID. It is only set for service accounts.
+	PrincipalType string `json:"principalType,omitempty"`
+	// Actor is the party that uses the token on behalf of the user. It is only set in exchanged tokens.
+	Actor *Actor `json:"act,omitempty"`
+}
+
+// HasAuthenticationMethod checks if the user has been authenticated with a method.
+func (pc *PersonalClaim) HasAuthenticationMethod(method string) bool {
+	for _, m := range pc.AuthenticationMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMFAEnrolment checks if the claim is restricted to the enrolment of MFA.
+func (pc *PersonalClaim) IsMFAEnrolment() bool {
+	for _, p := range pc.Primitives {
+		if p == MFAEnrolmentPrimitive {
+			return true
+		}
+	}
+	return false
+}
+
+// GetPrincipalType returns the type of principal of the claim.
+func (pc *PersonalClaim) GetPrincipalType() string {
+	if pc.PrincipalType == "" {
+		return UserPrincipal
+	}
+	return pc.PrincipalType
+}
+
+// IsServicePrincipal checks if the claim belongs to a service account.
+func (pc *PersonalClaim) IsServicePrincipal() bool {
+	return pc.PrincipalType == ServicePrincipal
 }
 
 // NewPersonalClaim creates a new instance of the structure.
@@ -36,6 +114,13 @@ func NewPersonalClaim(userID string, roleName string, primitives []string, organ
 	return &PersonalClaim{UserID: userID, RoleName: roleName, Primitives: primitives, OrganizationID: organizationID}
 }
 
+// NewServicePersonalClaim creates the claim of a service account. The identifier of the service account is used as
+// UserID.
+func NewServicePersonalClaim(serviceAccountID string, roleName string, primitives []string, organizationID string) *PersonalClaim {
+	return &PersonalClaim{UserID: serviceAccountID, RoleName: roleName, Primitives: primitives,
+		OrganizationID: organizationID, PrincipalType: ServicePrincipal}
+}
+
 // Claim joins the personal claim and the standard JWT claim.
 type Claim struct {
 	jwt.StandardClaims
@@ -44,8 +129,16 @@ type Claim struct {
 
 // NewClaim create a new instance of the structure.
 func NewClaim(personalClaim PersonalClaim, issuer string, creationTime time.Time, expirationPeriod time.Duration) *Claim {
+	return NewClaimWithAudience(personalClaim, issuer, "", creationTime, expirationPeriod)
+}
+
+// NewClaimWithAudience create a new instance of the structure limited to an audience. An empty audience is not
+// included in the token.
+func NewClaimWithAudience(personalClaim PersonalClaim, issuer string, audience string, creationTime time.Time,
+	expirationPeriod time.Duration) *Claim {
 	stdClaim := jwt.StandardClaims{
 		Issuer:    issuer,
+		Audience:  audience,
 		Id:        GenerateUUID(),
 		ExpiresAt: creationTime.Add(expirationPeriod).Unix(),
 		NotBefore: creationTime.Unix(),
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+	"math/big"
+)
+
+// JSONWebKey is the public part of a signing key following RFC 7517.
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	// RSA parameters.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC parameters.
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+	Y     string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is the set of public keys that can be used to verify the tokens.
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// NewJSONWebKey builds the public JWK of an asymmetric signing key.
+func NewJSONWebKey(key *SigningKey) (*JSONWebKey, derrors.Error) {
+	jwk := &JSONWebKey{KeyID: key.KeyID, Use: "sig", Algorithm: key.Method.Alg()}
+	switch pub := key.VerificationKey().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = encodeBigInt(pub.N)
+		jwk.E = encodeBigInt(big.NewInt(int64(pub.E)))
+	case *ecdsa.PublicKey:
+		jwk.KeyType = "EC"
+		jwk.Curve = pub.Curve.Params().Name
+		jwk.X = encodeBigInt(pub.X)
+		jwk.Y = encodeBigInt(pub.Y)
+	default:
+		return nil, derrors.NewInvalidArgumentError("symmetric keys cannot be published").WithParams(key.KeyID)
+	}
+	return jwk, nil
+}
+
+// NewJSONWebKeySet builds the set with the public part of the asymmetric keys. Symmetric keys are skipped.
+func NewJSONWebKeySet(keys ...*SigningKey) *JSONWebKeySet {
+	set := &JSONWebKeySet{Keys: make([]JSONWebKey, 0, len(keys))}
+	for _, k := range keys {
+		if !k.IsAsymmetric() {
+			continue
+		}
+		jwk, err := NewJSONWebKey(k)
+		if err == nil {
+			set.Keys = append(set.Keys, *jwk)
+		}
+	}
+	return set
+}
+
+// ParseJSONWebKeySet unmarshals a JWKS document.
+func ParseJSONWebKeySet(content []byte) (*JSONWebKeySet, derrors.Error) {
+	set := &JSONWebKeySet{}
+	err := json.Unmarshal(content, set)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible unmarshal JWKS", err)
+	}
+	return set, nil
+}
+
+// LoadJSONWebKeySet reads a JWKS document from a file.
+func LoadJSONWebKeySet(path string) (*JSONWebKeySet, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible read JWKS file", err).WithParams(path)
+	}
+	return ParseJSONWebKeySet(content)
+}
+
+// Find returns the key with a given kid.
+func (s *JSONWebKeySet) Find(keyID string) (*JSONWebKey, bool) {
+	for i := range s.Keys {
+		if s.Keys[i].KeyID == keyID {
+			return &s.Keys[i], true
+		}
+	}
+	return nil, false
+}
+
+// VerificationKey returns the public key with a given kid and algorithm.
+func (s *JSONWebKeySet) VerificationKey(keyID string, algorithm string) (interface{}, derrors.Error) {
+	jwk, found := s.Find(keyID)
+	if !found {
+		return nil, derrors.NewNotFoundError("verification key").WithParams(keyID)
+	}
+	if jwk.Algorithm != "" && jwk.Algorithm != algorithm {
+		return nil, derrors.NewUnauthenticatedError("unexpected signing algorithm").WithParams(keyID, algorithm)
+	}
+	return jwk.PublicKey()
+}
+
+// PublicKey decodes the public key of the JWK.
+func (k *JSONWebKey) PublicKey() (interface{}, derrors.Error) {
+	switch k.KeyType {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		if k.Curve != elliptic.P256().Params().Name {
+			return nil, derrors.NewInvalidArgumentError("unsupported curve").WithParams(k.KeyID, k.Curve)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}, nil
+	}
+	return nil, derrors.NewInvalidArgumentError("unsupported key type").WithParams(k.KeyID, k.KeyType)
+}
+
+func encodeBigInt(value *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(value.Bytes())
+}
+
+func decodeBigInt(value string) (*big.Int, derrors.Error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("invalid JWK parameter", err)
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
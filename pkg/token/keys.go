@@ -0,0 +1,130 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ */
+
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	"io/ioutil"
+)
+
+// KeyIDHeader is the name of the JWT header that identifies the signing key.
+const KeyIDHeader = "kid"
+
+// SigningKey is a key used to sign JWT tokens. Depending on the algorithm, the key is a shared secret (HS256) or
+// a private key (RS256, ES256) whose public part can be published.
+type SigningKey struct {
+	// KeyID is the identifier of the key that is included in the kid header of the signed tokens.
+	KeyID string
+	// Method is the signing algorithm.
+	Method jwt.SigningMethod
+	// key is the secret or private key used to sign.
+	key interface{}
+}
+
+// NewHMACSigningKey creates a signing key using a shared secret.
+func NewHMACSigningKey(keyID string, secret string) *SigningKey {
+	return &SigningKey{KeyID: keyID, Method: jwt.SigningMethodHS256, key: []byte(secret)}
+}
+
+// NewRSASigningKey creates a signing key using a RSA private key.
+func NewRSASigningKey(keyID string, privateKey *rsa.PrivateKey) *SigningKey {
+	return &SigningKey{KeyID: keyID, Method: jwt.SigningMethodRS256, key: privateKey}
+}
+
+// NewECSigningKey creates a signing key using an ECDSA P-256 private key.
+func NewECSigningKey(keyID string, privateKey *ecdsa.PrivateKey) *SigningKey {
+	return &SigningKey{KeyID: keyID, Method: jwt.SigningMethodES256, key: privateKey}
+}
+
+// NewSigningKeyFromPEM creates a signing key from a PEM encoded RSA or EC private key. The keys can be encoded
+// with PKCS1 (RSA), SEC1 (EC) or PKCS8. EC keys must use the P-256 curve.
+func NewSigningKeyFromPEM(keyID string, content []byte) (*SigningKey, derrors.Error) {
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, derrors.NewInvalidArgumentError("private key is not PEM encoded").WithParams(keyID)
+	}
+	var privateKey interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		privateKey, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		privateKey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, derrors.NewInvalidArgumentError("unsupported private key type").WithParams(keyID, block.Type)
+	}
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("cannot parse private key", err).WithParams(keyID)
+	}
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		return NewRSASigningKey(keyID, key), nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, derrors.NewInvalidArgumentError("EC private key must use the P-256 curve").WithParams(keyID, key.Curve.Params().Name)
+		}
+		return NewECSigningKey(keyID, key), nil
+	}
+	return nil, derrors.NewInvalidArgumentError("unsupported private key algorithm").WithParams(keyID)
+}
+
+// LoadSigningKey reads a PEM encoded private key from a file.
+func LoadSigningKey(keyID string, path string) (*SigningKey, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible read private key file", err).WithParams(path)
+	}
+	return NewSigningKeyFromPEM(keyID, content)
+}
+
+// IsAsymmetric returns true if the key can be verified with a public key.
+func (k *SigningKey) IsAsymmetric() bool {
+	return k.Method != jwt.SigningMethodHS256
+}
+
+// VerificationKey returns the key required to verify the tokens signed with this key.
+func (k *SigningKey) VerificationKey() interface{} {
+	switch key := k.key.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case *ecdsa.PrivateKey:
+		return &key.PublicKey
+	}
+	return k.key
+}
+
+// Sign generates a signed JWT with the given claims including the kid header.
+func (k *SigningKey) Sign(claims jwt.Claims) (string, derrors.Error) {
+	t := jwt.NewWithClaims(k.Method, claims)
+	if k.KeyID != "" {
+		t.Header[KeyIDHeader] = k.KeyID
+	}
+	signed, err := t.SignedString(k.key)
+	if err != nil {
+		return "", derrors.NewInternalError("impossible sign JWT token", err).WithParams(k.KeyID)
+	}
+	return signed, nil
+}